@@ -4,24 +4,46 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/ciii1/kuuhaku/internal/formatter"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_analyzer"
 )
 
 func main() {
 	flag.Usage = PrintHelp
 	var isRecursive = flag.Bool("recursive", false, "Process files recursively")
 	var isDebugAnalyzer = flag.Bool("debug-analyzer", false, "Print debug messages for the analyzer")
-	var isDebugParser = flag.Bool("debug-parser", false, "Print debug messages for the parser")
+	var isDebugParser = flag.Bool("debug-parser", false, "Print debug messages for the parser and the merged grammar")
 	var isDebugRuntime = flag.Bool("debug-runtime", false, "Print debug messages for the runtime")
 	var isDebugReader = flag.Bool("debug-reader", false, "Print debug messages for the reader")
 	var isStatic = flag.Bool("static", false, "Stop after analyzing the config file")
+	var isWerror = flag.Bool("Werror", false, "Treat analyzer warnings as errors")
+	var isPlain = flag.Bool("plain", false, "Print diagnostics without colors")
+	var suppress = flag.String("Wsuppress", "", "Comma separated warning codes to suppress, like W004,W007")
+	var outputFormat = flag.String("format", formatter.OUTPUT_TEXT, "The output of the diagnostics, text, json or sarif")
 
-	if len(os.Args) > 1 {
+	if len(os.Args) > 1 && os.Args[1] == "parse" {
+		runParse(os.Args[2:])
+	} else if len(os.Args) > 1 {
 		println("Kuuhaku is still in its experimental state! Make sure to commit your project files using your version control program before running the formatter. The formatter will run in 3 seconds...")
 		time.Sleep(3000000000)
 		flag.Parse()
+		var suppressedWarnings []string
+		if len(*suppress) != 0 {
+			suppressedWarnings = strings.Split(*suppress, ",")
+		}
+		for _, code := range suppressedWarnings {
+			if !kuuhaku_analyzer.IsWarningCode(code) {
+				println("Unknown warning code " + code)
+				os.Exit(1)
+			}
+		}
+		if !formatter.IsOutputFormat(*outputFormat) {
+			println("Unknown output format " + *outputFormat + ", expected text, json or sarif")
+			os.Exit(1)
+		}
 		filename := flag.Arg(0)
 		configName := flag.Arg(1)
 		if *isDebugReader {
@@ -32,27 +54,77 @@ func main() {
 				fmt.Println("Format=", configName)
 			}
 		}
-		formatter.Format(filename, configName, *isRecursive, *isDebugRuntime, *isDebugAnalyzer, *isDebugParser, *isDebugReader, *isStatic)
+		err := formatter.Format(filename, configName, *isRecursive, *isDebugRuntime, *isDebugAnalyzer, *isDebugParser, *isDebugReader, *isStatic, *isWerror, suppressedWarnings, *isPlain, *outputFormat)
+		if err != nil {
+			println(err.Error())
+			os.Exit(1)
+		}
 	} else {
 		println("Expected at least 1 argument")
 		PrintHelp()
 	}
 }
 
+// kuuhaku parse prints the parse tree of a file instead of formatting it
+func runParse(args []string) {
+	flags := flag.NewFlagSet("parse", flag.ExitOnError)
+	flags.Usage = PrintHelp
+	var isDebugAnalyzer = flags.Bool("debug-analyzer", false, "Print debug messages for the analyzer")
+	var isDebugParser = flags.Bool("debug-parser", false, "Print debug messages for the parser and the merged grammar")
+	var isDebugRuntime = flags.Bool("debug-runtime", false, "Print debug messages for the runtime")
+	var isDebugReader = flags.Bool("debug-reader", false, "Print debug messages for the reader")
+	var isPlain = flags.Bool("plain", false, "Print diagnostics without colors")
+	var suppress = flags.String("Wsuppress", "", "Comma separated warning codes to suppress, like W004,W007")
+	var treeFormat = flags.String("tree", formatter.TREE_JSON, "The format of the tree, json or sexpr")
+	flags.Parse(args)
+
+	var suppressedWarnings []string
+	if len(*suppress) != 0 {
+		suppressedWarnings = strings.Split(*suppress, ",")
+	}
+	for _, code := range suppressedWarnings {
+		if !kuuhaku_analyzer.IsWarningCode(code) {
+			println("Unknown warning code " + code)
+			os.Exit(1)
+		}
+	}
+	if !formatter.IsTreeFormat(*treeFormat) {
+		println("Unknown tree format " + *treeFormat + ", expected json or sexpr")
+		os.Exit(1)
+	}
+	if flags.NArg() < 1 {
+		println("Expected the file to parse")
+		PrintHelp()
+		os.Exit(1)
+	}
+	err := formatter.Export(flags.Arg(0), flags.Arg(1), *treeFormat, *isDebugRuntime, *isDebugAnalyzer, *isDebugParser, *isDebugReader, suppressedWarnings, *isPlain)
+	if err != nil {
+		println(err.Error())
+		os.Exit(1)
+	}
+}
+
 func PrintHelp() {
 	println("Kuuhaku - A highly costumizable code formatter")
 	println("")
 	println("Usage:")
 	println("kuuhaku <flags> <filename> <config_name>")
+	println("kuuhaku parse <flags> <filename> <config_name>")
 	println("Filename is the file to be formatted. If filename is a directory, kuuhaku will process all of the files inside the directory")
 	println("Config name is the name of the format configuration to be used inside the kuuhaku's config directory ($HOME/.config/kuuhaku), without the .khk extension. If ommitted, the extension of files that are going to be formatted will be used")
+	println("The parse command prints the parse tree of the file without formatting it, the tree has the rule, alternative, span and text of each node and the regex of each terminal")
 	println("")
 	println("Flags:")
 	println("-recursive\t\tProcess directories recursively")
 	println("-debug-analyzer\t\tPrint debug messages for the analyzer")
-	println("-debug-parser\t\tPrint debug messages for the parser")
+	println("-debug-parser\t\tPrint debug messages for the parser and the merged grammar")
 	println("-debug-runtime\t\tPrint debug messages for the runtime")
 	println("-debug-reader\t\tPrint debug messages for the file reader")
+	println("-plain\t\t\tPrint diagnostics without colors, for logs. Colors are only used on a terminal")
+	println("-Werror\t\t\tTreat analyzer warnings as errors, notes are still only displayed")
+	println("-Wsuppress=<codes>\tSuppress the comma separated warning codes, like -Wsuppress=W004,W007")
+	println("-format=<format>\tPrint the diagnostics and the result of each file as text (the default), json or sarif")
+	println("-tree=<format>\t\tThe format of the tree printed by the parse command, json (the default) or sexpr")
 	println("")
 	println("Exiting...")
 }
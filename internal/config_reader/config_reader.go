@@ -41,15 +41,19 @@ func ReadConfig(extension string, isDebugAnalyzer bool, isDebugParser bool, isDe
 		return nil, []error{ErrUnrecognizedExtension}
 	}
 
-	formatGrammar, err := os.ReadFile(formatFilePath)
-	helper.Check(err)
 	if isDebugReader {
+		formatGrammar, err := os.ReadFile(formatFilePath)
+		helper.Check(err)
 		fmt.Println(string(formatGrammar))
 	}
-	ast, errs := kuuhaku_parser.Parse(string(formatGrammar))
+	ast, errs := kuuhaku_parser.ParseFile(formatFilePath, []string{ConfigDir()})
 	if len(errs) != 0 {
 		return nil, errs
 	}
+	if isDebugParser {
+		fmt.Println("ReadConfig(), merged grammar:")
+		fmt.Println(kuuhaku_parser.GrammarToString(&ast))
+	}
 	res, errs := kuuhaku_analyzer.Analyze(&ast, isDebugAnalyzer)
 	if len(errs) != 0 {
 		return nil, errs
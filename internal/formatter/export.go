@@ -0,0 +1,75 @@
+package formatter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ciii1/kuuhaku/internal/config_reader"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_analyzer"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_diagnostic"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_runtime"
+)
+
+const (
+	TREE_JSON  = "json"
+	TREE_SEXPR = "sexpr"
+)
+
+func IsTreeFormat(format string) bool {
+	return format == TREE_JSON || format == TREE_SEXPR
+}
+
+// Export parses the file without running any lua and prints its parse trees. The diagnostics are
+// printed to stderr so the trees can be piped
+func Export(filename string, specFormatConfig string, treeFormat string, isDebugRuntime bool, isDebugAnalyzer bool, isDebugParser bool, isDebugReader bool, suppressedWarnings []string, isPlain bool) error {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	input := string(content)
+	formatConfig := specFormatConfig
+	if len(formatConfig) == 0 {
+		formatConfig = filepath.Ext(filename)
+	}
+	out := &output{
+		format:   OUTPUT_TEXT,
+		writer:   os.Stderr,
+		filename: filename,
+		renderer: kuuhaku_diagnostic.InitRenderer(filename, input, !isPlain && kuuhaku_diagnostic.IsColorTerminal(os.Stderr)),
+	}
+
+	res, errs := config_reader.ReadConfig(formatConfig, isDebugAnalyzer, isDebugParser, isDebugReader)
+	if len(errs) != 0 {
+		out.heading("Error while reading configuration, file " + filepath.Ext(filename) + ":")
+		out.add(errs)
+		return errors.New("Couldn't read the configuration " + formatConfig)
+	}
+	warnings := kuuhaku_analyzer.FilterWarnings(res.Warnings, suppressedWarnings)
+	if len(warnings) != 0 {
+		out.heading("Warnings while reading configuration, file " + filepath.Ext(filename) + ":")
+		out.add(warnings)
+	}
+
+	trees, err := kuuhaku_runtime.ParseTrees(input, res, isDebugRuntime)
+	if err != nil {
+		out.heading("Error while parsing the code, file " + filename + ":")
+		out.add([]error{err})
+		return errors.New("Couldn't parse " + filename)
+	}
+	var nodes []*kuuhaku_runtime.ExportedNode
+	for _, tree := range trees {
+		nodes = append(nodes, kuuhaku_runtime.ExportTree(tree, input))
+	}
+	if treeFormat == TREE_SEXPR {
+		fmt.Print(kuuhaku_runtime.ExportSExpr(nodes))
+		return nil
+	}
+	exported, err := kuuhaku_runtime.ExportJSON(nodes)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(exported))
+	return nil
+}
@@ -3,12 +3,15 @@ package formatter
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"unicode/utf8"
 
 	"github.com/ciii1/kuuhaku/internal/config_reader"
 	"github.com/ciii1/kuuhaku/internal/helper"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_analyzer"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_diagnostic"
 	"github.com/ciii1/kuuhaku/pkg/kuuhaku_runtime"
 )
 
@@ -17,7 +20,69 @@ type FormattedFile struct {
 	Filename string
 }
 
-func Format(filename string, specFormatConfig string, isRecursive bool, isDebugRuntime bool, isDebugAnalyzer bool, isDebugParser bool, isDebugReader bool, isStatic bool) error {
+const (
+	OUTPUT_TEXT = "text"
+	OUTPUT_JSON = "json"
+	OUTPUT_SARIF = "sarif"
+)
+
+func IsOutputFormat(format string) bool {
+	return format == OUTPUT_TEXT || format == OUTPUT_JSON || format == OUTPUT_SARIF
+}
+
+// prints the diagnostics of a file as text, or collects them into the report that is printed once
+// every file is processed
+type output struct {
+	format string
+	writer io.Writer
+	report kuuhaku_diagnostic.Report
+	renderer *kuuhaku_diagnostic.Renderer
+	filename string
+}
+
+func (out *output) heading(text string) {
+	if out.format == OUTPUT_TEXT {
+		fmt.Fprintln(out.writer, text)
+	}
+}
+
+func (out *output) add(errs []error) {
+	for _, err := range errs {
+		out.addDiagnostic(kuuhaku_diagnostic.FromError(err))
+	}
+}
+
+func (out *output) addDiagnostic(diagnostic kuuhaku_diagnostic.Diagnostic) {
+	if out.format == OUTPUT_TEXT {
+		fmt.Fprint(out.writer, out.renderer.Render(diagnostic))
+	} else {
+		out.report.AddDiagnostic(diagnostic, out.filename)
+	}
+}
+
+func (out *output) addFile(status kuuhaku_diagnostic.FileStatus) {
+	out.report.AddFile(out.filename, status)
+}
+
+func (out *output) print() error {
+	var res []byte
+	var err error
+	switch out.format {
+	case OUTPUT_JSON:
+		res, err = out.report.ToJSON()
+	case OUTPUT_SARIF:
+		res, err = out.report.ToSARIF()
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out.writer, string(res))
+	return nil
+}
+
+func Format(filename string, specFormatConfig string, isRecursive bool, isDebugRuntime bool, isDebugAnalyzer bool, isDebugParser bool, isDebugReader bool, isStatic bool, isWerror bool, suppressedWarnings []string, isPlain bool, outputFormat string) error {
 	file, err := os.Stat(filename)
 	helper.Check(err)
 	var files []FormattedFile
@@ -33,6 +98,20 @@ func Format(filename string, specFormatConfig string, isRecursive bool, isDebugR
 		})
 	}
 
+	out := &output{format: outputFormat, writer: os.Stdout}
+	options := kuuhaku_runtime.FormatOptions{
+		Config:             specFormatConfig,
+		OutputFormat:       outputFormat,
+		SuppressedWarnings: suppressedWarnings,
+		IsRecursive:        isRecursive,
+		IsStatic:           isStatic,
+		IsWerror:           isWerror,
+		IsPlain:            isPlain,
+		IsDebugRuntime:     isDebugRuntime,
+		IsDebugAnalyzer:    isDebugAnalyzer,
+		IsDebugParser:      isDebugParser,
+		IsDebugReader:      isDebugReader,
+	}
 	for _, formattedFile := range files {
 		if isDebugReader {
 			fmt.Println("Format(), content:\n", formattedFile.Content)
@@ -42,29 +121,65 @@ func Format(filename string, specFormatConfig string, isRecursive bool, isDebugR
 		if len(formatConfig) == 0 {
 			formatConfig = filepath.Ext(formattedFile.Filename)
 		}
+		out.filename = formattedFile.Filename
+		out.renderer = kuuhaku_diagnostic.InitRenderer(formattedFile.Filename, formattedFile.Content, !isPlain && kuuhaku_diagnostic.IsColorTerminal(os.Stdout))
 		res, errs := config_reader.ReadConfig(formatConfig, isDebugAnalyzer, isDebugParser, isDebugReader)
 		if len(errs) != 0 {
-			fmt.Println("Error while reading configuration, file " + filepath.Ext(formattedFile.Filename) + ":")
-			helper.DisplayAllErrors(errs)
+			out.heading("Error while reading configuration, file " + filepath.Ext(formattedFile.Filename) + ":")
+			out.add(errs)
+			out.addFile(kuuhaku_diagnostic.FILE_FAILED)
 			continue
 		}
-		if !isStatic {
-			strRes, err := kuuhaku_runtime.Format(formattedFile.Content, res, true, isDebugRuntime)
-			if err != nil {
-				fmt.Println("Error while formatting the code, file " + formattedFile.Filename + ":")
-				fmt.Println(err.Error())
+		warnings := kuuhaku_analyzer.FilterWarnings(res.Warnings, suppressedWarnings)
+		if isWerror {
+			promoted, notes := kuuhaku_analyzer.SplitPromotedWarnings(warnings)
+			if len(promoted) != 0 {
+				out.heading("Error while reading configuration, file " + filepath.Ext(formattedFile.Filename) + " (warnings are treated as errors):")
+				for _, warning := range promoted {
+					diagnostic := kuuhaku_diagnostic.FromError(warning)
+					diagnostic.Severity = kuuhaku_diagnostic.SEVERITY_ERROR
+					out.addDiagnostic(diagnostic)
+				}
+				out.add(notes)
+				out.addFile(kuuhaku_diagnostic.FILE_FAILED)
 				continue
 			}
+		}
+		if len(warnings) != 0 {
+			out.heading("Warnings while reading configuration, file " + filepath.Ext(formattedFile.Filename) + ":")
+			out.add(warnings)
+		}
+		if isStatic {
+			out.addFile(kuuhaku_diagnostic.FILE_CHECKED)
+			continue
+		}
+		ctx := &kuuhaku_runtime.FormatContext{
+			Filename:  formattedFile.Filename,
+			Extension: filepath.Ext(formattedFile.Filename),
+			InputSize: len(formattedFile.Content),
+			Options:   options,
+		}
+		strRes, err := kuuhaku_runtime.FormatFile(formattedFile.Content, res, ctx, isDebugRuntime)
+		if err != nil {
+			out.heading("Error while formatting the code, file " + formattedFile.Filename + ":")
+			out.add([]error{err})
+			out.addFile(kuuhaku_diagnostic.FILE_FAILED)
+			continue
+		}
+		if strRes == formattedFile.Content {
+			out.addFile(kuuhaku_diagnostic.FILE_UNCHANGED)
+		} else {
+			out.addFile(kuuhaku_diagnostic.FILE_CHANGED)
+		}
 
-			f, err := os.OpenFile(formattedFile.Filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
-			defer f.Close()
-			helper.Check(err)
+		f, err := os.OpenFile(formattedFile.Filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+		defer f.Close()
+		helper.Check(err)
 
-			_, err = f.WriteString(strRes)
-			helper.Check(err)
-		}
+		_, err = f.WriteString(strRes)
+		helper.Check(err)
 	}
-	return nil
+	return out.print()
 }
 
 func getFilesRecursive(filename string) []FormattedFile {
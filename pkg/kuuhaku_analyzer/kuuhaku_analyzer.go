@@ -3,8 +3,10 @@ package kuuhaku_analyzer
 import (
 	"fmt"
 	"github.com/h2so5/goback/regexp"
+	"slices"
 	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/ciii1/kuuhaku/internal/helper"
 	"github.com/ciii1/kuuhaku/pkg/kuuhaku_parser"
@@ -21,8 +23,106 @@ const (
 	INVALID_REGEX
 	INVALID_ARG_LENGTH
 	INVALID_LUA_LITERAL
+	DUPLICATE_BINDING
+	BINDING_CLASHES_WITH_PARAM
+	UNDEFINED_TERMINAL
+	MODE_NEVER_PUSHED
+	MULTIPLE_PUSHED_MODES
+	BINDING_SHADOWS_GLOBAL
 )
 
+type AnalyzeWarningType int
+
+const (
+	SHADOWED_LONGER_MATCH AnalyzeWarningType = iota
+	SHADOWED_TERMINAL
+	EMPTY_MATCH
+	UNUSED_RULE
+	UNREACHABLE_RULE
+	DUPLICATE_ALTERNATIVE
+	UNUSED_PARAM
+	UNKNOWN_LUA_NAME
+)
+
+// the codes are part of the command line interface, new warning types get a new code instead of
+// taking over an existing one
+var warningCodes = map[AnalyzeWarningType]string{
+	SHADOWED_LONGER_MATCH: "W001",
+	SHADOWED_TERMINAL:     "W002",
+	EMPTY_MATCH:           "W003",
+	UNUSED_RULE:           "W004",
+	UNREACHABLE_RULE:      "W005",
+	DUPLICATE_ALTERNATIVE: "W006",
+	UNUSED_PARAM:          "W007",
+	UNKNOWN_LUA_NAME:      "W008",
+}
+
+type WarningSeverity int
+
+const (
+	SEVERITY_WARNING WarningSeverity = iota
+	SEVERITY_NOTE                    //harmless on its own, never promoted to an error
+)
+
+func (s WarningSeverity) String() string {
+	if s == SEVERITY_NOTE {
+		return "note"
+	}
+	return "warning"
+}
+
+// warnings don't stop the grammar from being used
+type AnalyzeWarning struct {
+	Position kuuhaku_tokenizer.Position
+	Message  string
+	Type     AnalyzeWarningType
+	Severity WarningSeverity
+}
+
+func (w AnalyzeWarning) Code() string {
+	return warningCodes[w.Type]
+}
+
+func (w AnalyzeWarning) Error() string {
+	return fmt.Sprintf("Analyze %s %s (%s): %s", w.Severity, w.Code(), w.Position.Location(), w.Message)
+}
+
+func IsWarningCode(code string) bool {
+	for _, warningCode := range warningCodes {
+		if warningCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterWarnings leaves out the warnings with one of the suppressed codes
+func FilterWarnings(warnings []error, suppressed []string) []error {
+	var out []error
+	for _, warning := range warnings {
+		analyzeWarning, ok := warning.(*AnalyzeWarning)
+		if ok && slices.Contains(suppressed, analyzeWarning.Code()) {
+			continue
+		}
+		out = append(out, warning)
+	}
+	return out
+}
+
+// SplitPromotedWarnings separates the warnings that -Werror turns into errors from the notes
+func SplitPromotedWarnings(warnings []error) ([]error, []error) {
+	var promoted, notes []error
+	for _, warning := range warnings {
+		analyzeWarning, ok := warning.(*AnalyzeWarning)
+		if ok && analyzeWarning.Severity == SEVERITY_NOTE {
+			notes = append(notes, warning)
+		} else {
+			promoted = append(promoted, warning)
+		}
+	}
+	return promoted, notes
+}
+
 type AnalyzeError struct {
 	Position kuuhaku_tokenizer.Position
 	Message  string
@@ -30,19 +130,24 @@ type AnalyzeError struct {
 }
 
 type ConflictError struct {
-	Position1 kuuhaku_tokenizer.Position
-	Position2 kuuhaku_tokenizer.Position
-	Symbol1   *Symbol
-	Symbol2   *Symbol
-	Message   string
+	Position1   kuuhaku_tokenizer.Position
+	Position2   kuuhaku_tokenizer.Position
+	Symbol1     *Symbol
+	Symbol2     *Symbol
+	Message     string
+	State       int
+	Terminal    SymbolTitle   //the lookahead that triggers the conflict, <end> for the end of input
+	Example     []SymbolTitle //the shortest sequence of symbols that reaches the conflicting state
+	Derivation1 string
+	Derivation2 string
 }
 
 func (e AnalyzeError) Error() string {
-	return fmt.Sprintf("Analyze error (%d, %d): %s", e.Position.Line, e.Position.Column, e.Message)
+	return fmt.Sprintf("Analyze error (%s): %s", e.Position.Location(), e.Message)
 }
 
 func (e ConflictError) Error() string {
-	return fmt.Sprintf("Analyze error (%d, %d): %s", e.Position1.Line, e.Position1.Column, e.Message)
+	return fmt.Sprintf("Analyze error (%s): %s", e.Position1.Location(), e.Message)
 }
 
 func ErrUndefinedVariable(position kuuhaku_tokenizer.Position, variableName string) *AnalyzeError {
@@ -93,32 +198,106 @@ func ErrInvalidLuaLiteral(position kuuhaku_tokenizer.Position, luaError string)
 	}
 }
 
-func ErrConflict(symbol1 *Symbol, symbol2 *Symbol, stateNumber int, isDebug bool) *ConflictError {
-	var position1 kuuhaku_tokenizer.Position
-	if symbol1.Position < len(symbol1.Rule.MatchRules) {
-		position1 = symbol1.Rule.MatchRules[symbol1.Position].GetPosition()
-	} else {
-		position1 = symbol1.Rule.MatchRules[len(symbol1.Rule.MatchRules)-1].GetPosition()
+func ErrDuplicateBinding(position kuuhaku_tokenizer.Position, name string, ruleName string) *AnalyzeError {
+	return &AnalyzeError{
+		Message:  "The name " + name + " is bound more than once in the rule " + ruleName,
+		Position: position,
+		Type:     DUPLICATE_BINDING,
 	}
+}
 
-	var position2 kuuhaku_tokenizer.Position
-	if symbol2.Position < len(symbol2.Rule.MatchRules) {
-		position2 = symbol2.Rule.MatchRules[symbol2.Position].GetPosition()
-	} else {
-		position2 = symbol2.Rule.MatchRules[len(symbol2.Rule.MatchRules)-1].GetPosition()
+func ErrBindingClashesWithParam(position kuuhaku_tokenizer.Position, name string, ruleName string) *AnalyzeError {
+	return &AnalyzeError{
+		Message:  "The name " + name + " clashes with a parameter of the rule " + ruleName,
+		Position: position,
+		Type:     BINDING_CLASHES_WITH_PARAM,
 	}
+}
+
+func ErrBindingShadowsGlobal(position kuuhaku_tokenizer.Position, name string, ruleName string) *AnalyzeError {
+	return &AnalyzeError{
+		Message:  "The name " + name + " in the rule " + ruleName + " shadows a lua global or a name the runtime defines",
+		Position: position,
+		Type:     BINDING_SHADOWS_GLOBAL,
+	}
+}
+
+func ErrConflict(symbol1 *Symbol, symbol2 *Symbol, stateNumber int, terminal SymbolTitle, example []SymbolTitle, isDebug bool) *ConflictError {
+	position1 := getSymbolPosition(symbol1)
+	position2 := getSymbolPosition(symbol2)
 	message := ""
 	if isDebug {
 		message = "(State " + strconv.Itoa(stateNumber) + ") "
 	}
-	message += "Detected conflict at rule " + strconv.Itoa(symbol1.Rule.Order+1) + " and rule " + strconv.Itoa(symbol2.Rule.Order+1) + " with position (" + strconv.Itoa(position2.Line) + ", " + strconv.Itoa(position2.Column) + ")\n--- Lookaheads are: " + symbol1.Lookahead.String + " and " + symbol2.Lookahead.String
-	return &ConflictError{
-		Message:   message,
-		Position1: position1,
-		Position2: position2,
-		Symbol1:   symbol1,
-		Symbol2:   symbol2,
+	message += "Detected conflict at rule " + strconv.Itoa(symbol1.Rule.SourceOrder+1) + " and rule " + strconv.Itoa(symbol2.Rule.SourceOrder+1) + " with position (" + strconv.Itoa(position2.Line) + ", " + strconv.Itoa(position2.Column) + ")\n--- Lookaheads are: " + symbol1.Lookahead.String + " and " + symbol2.Lookahead.String
+	conflictError := &ConflictError{
+		Position1:   position1,
+		Position2:   position2,
+		Symbol1:     symbol1,
+		Symbol2:     symbol2,
+		State:       stateNumber,
+		Terminal:    terminal,
+		Example:     example,
+		Derivation1: symbolToDerivation(symbol1),
+		Derivation2: symbolToDerivation(symbol2),
 	}
+	conflictError.Message = message + "\n" + conflictError.Counterexample()
+	return conflictError
+}
+
+// an empty rule has no match rule to point at, so the rule itself is used
+func getSymbolPosition(symbol *Symbol) kuuhaku_tokenizer.Position {
+	if len(symbol.Rule.MatchRules) == 0 {
+		return symbol.Rule.Position
+	}
+	if symbol.Position < len(symbol.Rule.MatchRules) {
+		return symbol.Rule.MatchRules[symbol.Position].GetPosition()
+	}
+	return symbol.Rule.MatchRules[len(symbol.Rule.MatchRules)-1].GetPosition()
+}
+
+// Counterexample renders the conflict the way Bison does: an example input that reaches the
+// conflicting state followed by the two derivations that compete on the conflicting terminal
+func (e ConflictError) Counterexample() string {
+	out := "--- Counterexample on " + symbolTitleToString(e.Terminal) + ":\n"
+	out += "\tExample: "
+	for _, title := range e.Example {
+		out += symbolTitleToString(title) + " "
+	}
+	out += "\u2022 " + symbolTitleToString(e.Terminal) + "\n"
+	out += "\t" + derivationKind(e.Symbol1) + " derivation: " + e.Derivation1 + "\n"
+	out += "\t" + derivationKind(e.Symbol2) + " derivation: " + e.Derivation2
+	return out
+}
+
+func derivationKind(symbol *Symbol) string {
+	if symbol.Position >= len(symbol.Rule.MatchRules) {
+		return "Reduce"
+	}
+	return "Shift"
+}
+
+// returns the item in the form of "Rule -> a b \u2022 c [lookahead]"
+func symbolToDerivation(symbol *Symbol) string {
+	out := symbol.Rule.Name + " ->"
+	for i, matchRule := range symbol.Rule.MatchRules {
+		if i == symbol.Position {
+			out += " \u2022"
+		}
+		out += " " + symbolTitleToString(getSymbolTitleFromMatchRule(matchRule))
+	}
+	if symbol.Position >= len(symbol.Rule.MatchRules) {
+		out += " \u2022"
+	}
+	out += " [" + symbolTitleToString(symbol.Lookahead) + "]"
+	return out
+}
+
+func symbolTitleToString(title SymbolTitle) string {
+	if title.Type == REGEX_LITERAL_TITLE {
+		return "<" + title.String + ">"
+	}
+	return title.String
 }
 
 type Analyzer struct {
@@ -129,28 +308,68 @@ type Analyzer struct {
 	parseTables            []ParseTable
 	stateTransitionMap     map[string]int
 	stateTransitionMapBool map[string]bool
+	statePaths             map[int][]SymbolTitle //the symbols read to reach a state for the first time
+	conflicts              []*ConflictError      //conflicts that are kept in the parse table in GLR mode
+	checked                map[checkKey]bool     //desugared alternatives share match rules and lua literals, check them once
+	nullable               map[string]bool       //rules that can match the empty string
+	matchOverrides         map[string]bool       //terminal - whether it uses the longest match, from LONGEST_MATCH and FIRST_MATCH lists
+	luaGlobals             map[string]bool       //the names the bindings must not shadow, see getLuaGlobals
+	Warnings               []error
+}
+
+type checkKey struct {
+	position kuuhaku_tokenizer.Position
+	name     string
 }
 
 func Analyze(input *kuuhaku_parser.Ast, isDebug bool) (AnalyzerResult, []error) {
 	analyzer := initAnalyzer(input, isDebug)
+	analyzer.numberAlternatives()
 	startSymbols := analyzer.analyzeStart()
+	analyzer.nullable = analyzer.getNullableRules()
 	if len(startSymbols) > 1 && !input.IsSearchMode {
 		analyzer.Errors = append(analyzer.Errors, ErrMultipleStartSymbols(input.Rules[startSymbols[1]][0].Position, startSymbols[0], startSymbols[1]))
 	}
+	trivia := analyzer.getTrivia()
+	analyzer.checkRuleUsage(startSymbols)
+	analyzer.checkDuplicateAlternatives()
+	analyzer.checkUnusedParams()
+	analyzer.checkLuaNames()
+	modes, pushes := analyzer.getLexerModes()
+	analyzer.matchOverrides = analyzer.getMatchOverrides()
 	if len(analyzer.Errors) == 0 {
 		for _, startSymbol := range startSymbols {
-			analyzer.parseTables = append(analyzer.parseTables, analyzer.makeEmptyParseTable(startSymbol))
+			parseTable := analyzer.makeEmptyParseTable(startSymbol)
+			parseTable.Trivia = trivia
+			parseTable.Modes = modes
+			parseTable.Pushes = pushes
+			analyzer.parseTables = append(analyzer.parseTables, parseTable)
 			analyzer.buildParseTable(startSymbol)
+			analyzer.checkShadowedMatches(&analyzer.parseTables[len(analyzer.parseTables)-1])
 			if isDebug {
 				PrintParseTable(&analyzer.parseTables[len(analyzer.parseTables)-1])
 			}
 		}
+		analyzer.checkOverlappingTerminals()
 	}
 
 
+	var preferences []string
+	for _, preference := range input.Preferences {
+		if len(input.Rules[preference.Name]) == 0 {
+			analyzer.Errors = append(analyzer.Errors, ErrUndefinedVariable(preference.Position, preference.Name))
+		}
+		preferences = append(preferences, preference.Name)
+	}
+
 	return AnalyzerResult{
 		ParseTables:  analyzer.parseTables,
 		IsSearchMode: input.IsSearchMode,
+		IsGLRMode:    input.IsGLRMode,
+		IsNodeMode:   input.IsNodeMode,
+		Preferences:  preferences,
+		Conflicts:    analyzer.conflicts,
+		Warnings:     analyzer.Warnings,
 		GlobalLua:    input.GlobalLua,
 	}, analyzer.Errors
 }
@@ -164,6 +383,8 @@ func initAnalyzer(input *kuuhaku_parser.Ast, isDebug bool) Analyzer {
 		parseTables:            []ParseTable{},
 		stateTransitionMap:     make(map[string]int),
 		stateTransitionMapBool: make(map[string]bool),
+		statePaths:             make(map[int][]SymbolTitle),
+		checked:                make(map[checkKey]bool),
 	}
 }
 
@@ -175,6 +396,9 @@ func (analyzer *Analyzer) makeEmptyParseTable(startSymbol string) ParseTable {
 	terminalsMap, lhsMap = analyzer.getAllTerminalsAndLhs(startSymbol, &terminalsMapInput, &lhsMapInput)
 
 	terminals := sortTerminalsMaptoArray(terminalsMap)
+	for i := range *terminals {
+		(*terminals)[i].IsLongestMatch = analyzer.isLongestMatch((*terminals)[i].Terminal)
+	}
 
 	var lhsArray []string
 	for lhs := range *lhsMap {
@@ -239,6 +463,23 @@ func (analyzer *Analyzer) getAllTerminalsAndLhs(startSymbol string, previousTerm
 	return terminalsMap, lhsMap
 }
 
+func (analyzer *Analyzer) getTrivia() []TerminalList {
+	var trivia []TerminalList
+	for i, regexLiteral := range analyzer.input.Trivia {
+		regexCompiled, err := regexp.Compile("^" + regexLiteral.RegexString)
+		if err != nil {
+			analyzer.Errors = append(analyzer.Errors, ErrInvalidRegex(regexLiteral.Position, regexLiteral.RegexString, err))
+			continue
+		}
+		trivia = append(trivia, TerminalList{
+			Terminal:   regexLiteral.RegexString,
+			Precedence: i,
+			Regexp:     regexCompiled,
+		})
+	}
+	return trivia
+}
+
 func makeEndSymbolTitle() SymbolTitle {
 	return SymbolTitle{String: "<end>", Type: EMPTY_TITLE}
 }
@@ -276,11 +517,6 @@ func (analyzer *Analyzer) expandSymbol(rules *[]*kuuhaku_parser.Rule, position i
 		}
 
 		currMatchRule := currRule.MatchRules[position]
-		nextLookahead := lookahead
-		if position+1 < len(currRule.MatchRules) {
-			nextMatchRule := currRule.MatchRules[position+1]
-			nextLookahead = getSymbolTitleFromMatchRule(nextMatchRule)
-		}
 
 		*output = append(*output, &Symbol{
 			Rule:       currRule,
@@ -292,30 +528,110 @@ func (analyzer *Analyzer) expandSymbol(rules *[]*kuuhaku_parser.Rule, position i
 		currIdentifier, ok := currMatchRule.(kuuhaku_parser.Identifier)
 
 		if ok {
-			is_included := false
-			for _, e := range *output {
-				booleanExpr := e.Rule.Name == currIdentifier.Name
-				if withLookaheadNPos {
-					booleanExpr = booleanExpr && e.Lookahead == nextLookahead && e.Position == 0
+			for _, nextLookahead := range analyzer.getNextLookaheads(currRule, position, lookahead) {
+				is_included := false
+				for _, e := range *output {
+					booleanExpr := e.Rule.Name == currIdentifier.Name
+					if withLookaheadNPos {
+						booleanExpr = booleanExpr && e.Lookahead == nextLookahead && e.Position == 0
+					}
+					if booleanExpr {
+						is_included = true
+						break
+					}
+				}
+				if !is_included {
+					rules := analyzer.input.Rules[currIdentifier.Name]
+					output = analyzer.expandSymbol(&rules, 0, output, nextLookahead, true)
 				}
-				if booleanExpr {
-					is_included = true
+			}
+		}
+	}
+	return output
+}
+
+// the lookaheads of the match rule at position are the match rules after it up to the first one that
+// can't match the empty string. If all of them can, the lookahead of the rule itself follows too
+func (analyzer *Analyzer) getNextLookaheads(rule *kuuhaku_parser.Rule, position int, lookahead SymbolTitle) []SymbolTitle {
+	var out []SymbolTitle
+	for _, nextMatchRule := range rule.MatchRules[position+1:] {
+		out = append(out, getSymbolTitleFromMatchRule(nextMatchRule))
+		identifier, ok := nextMatchRule.(kuuhaku_parser.Identifier)
+		if !ok || !analyzer.nullable[identifier.Name] {
+			return out
+		}
+	}
+	return append(out, lookahead)
+}
+
+// a rule is nullable if one of its alternatives only consists of nullable rules, which is repeated
+// until no more rules are found
+func (analyzer *Analyzer) getNullableRules() map[string]bool {
+	nullable := make(map[string]bool)
+	isChanged := true
+	for isChanged {
+		isChanged = false
+		for name, rules := range analyzer.input.Rules {
+			if nullable[name] {
+				continue
+			}
+			for _, rule := range rules {
+				if analyzer.isNullableSequence(rule.MatchRules, nullable) {
+					nullable[name] = true
+					isChanged = true
 					break
 				}
 			}
-			if !is_included {
-				rules := analyzer.input.Rules[currIdentifier.Name]
-				output = analyzer.expandSymbol(&rules, 0, output, nextLookahead, true)
+		}
+	}
+	return nullable
+}
+
+func (analyzer *Analyzer) isNullableSequence(matchRules []kuuhaku_parser.MatchRule, nullable map[string]bool) bool {
+	for _, matchRule := range matchRules {
+		identifier, ok := matchRule.(kuuhaku_parser.Identifier)
+		if !ok || !nullable[identifier.Name] {
+			return false
+		}
+	}
+	return true
+}
+
+// returns the terminals the rule can start with. A nullable match rule lets the terminals of the
+// match rule after it through
+func (analyzer *Analyzer) getFirstTerminals(ruleName string, visited map[string]bool, terminals *[]string) {
+	if visited[ruleName] {
+		return
+	}
+	visited[ruleName] = true
+	for _, rule := range analyzer.input.Rules[ruleName] {
+		for _, matchRule := range rule.MatchRules {
+			identifier, ok := matchRule.(kuuhaku_parser.Identifier)
+			if !ok {
+				regexLiteral, _ := matchRule.(kuuhaku_parser.RegexLiteral)
+				if !slices.Contains(*terminals, regexLiteral.RegexString) {
+					*terminals = append(*terminals, regexLiteral.RegexString)
+				}
+				break
+			}
+			analyzer.getFirstTerminals(identifier.Name, visited, terminals)
+			if !analyzer.nullable[identifier.Name] {
+				break
 			}
 		}
 	}
-	return output
 }
 
 func (analyzer *Analyzer) buildParseTable(startSymbolString string) *[]*StateTransition {
 	if len(analyzer.Errors) != 0 {
 		return nil
 	}
+	// state numbers are local to each parse table
+	analyzer.stateNumber = 1
+	analyzer.stateTransitionMap = make(map[string]int)
+	analyzer.stateTransitionMapBool = make(map[string]bool)
+	analyzer.statePaths = map[int][]SymbolTitle{0: {}}
+
 	startRules := analyzer.input.Rules[startSymbolString]
 	expandedStartSymbols := analyzer.expandSymbol(&startRules, 0, &[]*Symbol{}, makeEndSymbolTitle(), true)
 
@@ -424,7 +740,15 @@ func (analyzer *Analyzer) buildParseTableState(symbolGroups *[]*SymbolGroup, sta
 			if symbol.Position >= len(symbol.Rule.MatchRules) {
 				if symbol.Lookahead.Type == EMPTY_TITLE {
 					if isThereEndReduce {
-						analyzer.Errors = append(analyzer.Errors, ErrConflict(symbol, endReducedSymbol, len(currParseTable.States) - 1, analyzer.isDebug))
+						var action Action = REDUCE
+						if symbol.Rule.Name == startSymbol {
+							action = ACCEPT
+						}
+						analyzer.reportConflict(symbol, endReducedSymbol, makeEndSymbolTitle(), endReduceRule, &ActionCell{
+							LookaheadTerminal: "",
+							Action:            action,
+							ReduceRule:        symbol.Rule,
+						})
 					} else {
 						endReducedSymbol = symbol
 						isThereEndReduce = true
@@ -451,24 +775,28 @@ func (analyzer *Analyzer) buildParseTableState(symbolGroups *[]*SymbolGroup, sta
 			for lookahead := range existingLookeaheads { 
 				oneLookahead = lookahead
 			}
+			usedTerminalsWithSymbol[oneLookahead.String] = (*emptyTitleGroup.Symbols)[0]
+			endReduceRule = &ActionCell{
+				LookaheadTerminal: oneLookahead.String,
+				Action:            REDUCE,
+				ReduceRule:        (*emptyTitleGroup.Symbols)[0].Rule,
+				ShiftState:        0,
+			}
 			// check all symbols with the same lookahead, if exists, then produce error
 			isFound := false
 			for _, symbol := range *emptyTitleGroup.Symbols {
 				if symbol.Lookahead == oneLookahead {
 					if isFound {
-						analyzer.Errors = append(analyzer.Errors, ErrConflict(symbol, (*emptyTitleGroup.Symbols)[0], len(currParseTable.States) - 1, analyzer.isDebug))
+						analyzer.reportConflict(symbol, (*emptyTitleGroup.Symbols)[0], oneLookahead, endReduceRule, &ActionCell{
+							LookaheadTerminal: oneLookahead.String,
+							Action:            REDUCE,
+							ReduceRule:        symbol.Rule,
+						})
 					} else {
 						isFound = true	
 					}
 				}
 			}
-			usedTerminalsWithSymbol[oneLookahead.String] = (*emptyTitleGroup.Symbols)[0]
-			endReduceRule = &ActionCell{
-				LookaheadTerminal: oneLookahead.String,
-				Action:            REDUCE,
-				ReduceRule:        (*emptyTitleGroup.Symbols)[0].Rule,
-				ShiftState:        0,
-			}
 			skipLookaheadReduceRule = true
 		}
 
@@ -481,13 +809,7 @@ func (analyzer *Analyzer) buildParseTableState(symbolGroups *[]*SymbolGroup, sta
 					}
 					var terminals []string
 					if symbol.Lookahead.Type == IDENTIFIER_TITLE {
-						rule := analyzer.input.Rules[symbol.Lookahead.String]
-						symbols := analyzer.expandSymbol(&rule, 0, &[]*Symbol{}, SymbolTitle{}, false)
-						for _, symbol := range *symbols {
-							if (*symbol).Title.Type == REGEX_LITERAL_TITLE {
-								terminals = append(terminals, (*symbol).Title.String)
-							}
-						}
+						analyzer.getFirstTerminals(symbol.Lookahead.String, make(map[string]bool), &terminals)
 					} else if symbol.Lookahead.Type == REGEX_LITERAL_TITLE {
 						terminals = append(terminals, symbol.Lookahead.String)
 					}
@@ -501,7 +823,11 @@ func (analyzer *Analyzer) buildParseTableState(symbolGroups *[]*SymbolGroup, sta
 								ShiftState:        0,
 							}
 						} else {
-							analyzer.Errors = append(analyzer.Errors, ErrConflict(symbol, usedTerminalsWithSymbol[terminal], len(currParseTable.States) - 1, analyzer.isDebug))
+							analyzer.reportConflict(symbol, usedTerminalsWithSymbol[terminal], SymbolTitle{String: terminal, Type: REGEX_LITERAL_TITLE}, actionTable[terminal], &ActionCell{
+								LookaheadTerminal: terminal,
+								Action:            REDUCE,
+								ReduceRule:        symbol.Rule,
+							})
 						}
 					}
 				}
@@ -516,6 +842,7 @@ func (analyzer *Analyzer) buildParseTableState(symbolGroups *[]*SymbolGroup, sta
 				outGroup = append(outGroup, group)
 				analyzer.stateTransitionMap[symbolGroupToString(*group)] = analyzer.stateNumber
 				analyzer.stateTransitionMapBool[symbolGroupToString(*group)] = true
+				analyzer.recordStatePath(analyzer.stateNumber, group.Title)
 				analyzer.stateNumber++
 			} else {
 				stateNumber = analyzer.stateTransitionMap[symbolGroupToString(*group)]
@@ -525,23 +852,35 @@ func (analyzer *Analyzer) buildParseTableState(symbolGroups *[]*SymbolGroup, sta
 				GotoState: stateNumber,
 			}
 		} else if group.Title.Type == REGEX_LITERAL_TITLE {
+			var conflictingActions []*ActionCell
 			if usedTerminalsWithSymbol[group.Title.String] != nil {
-				analyzer.Errors = append(analyzer.Errors, ErrConflict((*group.Symbols)[0], usedTerminalsWithSymbol[group.Title.String], len(currParseTable.States) - 1, analyzer.isDebug))
+				reduceCell := actionTable[group.Title.String]
+				if reduceCell == nil {
+					reduceCell = endReduceRule
+				}
+				if analyzer.input.IsGLRMode {
+					conflictingActions = append([]*ActionCell{reduceCell}, reduceCell.ConflictingActions...)
+					analyzer.conflicts = append(analyzer.conflicts, analyzer.errConflict((*group.Symbols)[0], usedTerminalsWithSymbol[group.Title.String], group.Title))
+				} else {
+					analyzer.Errors = append(analyzer.Errors, analyzer.errConflict((*group.Symbols)[0], usedTerminalsWithSymbol[group.Title.String], group.Title))
+				}
 			}
 			stateNumber := analyzer.stateNumber
 			if !analyzer.stateTransitionMapBool[symbolGroupToString(*group)] {
 				outGroup = append(outGroup, group)
 				analyzer.stateTransitionMap[symbolGroupToString(*group)] = analyzer.stateNumber
 				analyzer.stateTransitionMapBool[symbolGroupToString(*group)] = true
+				analyzer.recordStatePath(analyzer.stateNumber, group.Title)
 				analyzer.stateNumber++
 			} else {
 				stateNumber = analyzer.stateTransitionMap[symbolGroupToString(*group)]
 			}
 			actionTable[group.Title.String] = &ActionCell{
-				LookaheadTerminal: group.Title.String,
-				Action:            SHIFT,
-				ReduceRule:        nil,
-				ShiftState:        stateNumber,
+				LookaheadTerminal:  group.Title.String,
+				Action:             SHIFT,
+				ReduceRule:         nil,
+				ShiftState:         stateNumber,
+				ConflictingActions: conflictingActions,
 			}
 		}
 	}
@@ -553,6 +892,44 @@ func (analyzer *Analyzer) buildParseTableState(symbolGroups *[]*SymbolGroup, sta
 	return &outGroup
 }
 
+// the state that is currently being built is always the last state of the current parse table
+func (analyzer *Analyzer) currentState() int {
+	return len(analyzer.parseTables[len(analyzer.parseTables)-1].States)
+}
+
+// states are discovered breadth first, so the first path that reaches a state is also the shortest one
+func (analyzer *Analyzer) recordStatePath(stateNumber int, title SymbolTitle) {
+	previousPath := analyzer.statePaths[analyzer.currentState()]
+	path := make([]SymbolTitle, len(previousPath), len(previousPath)+1)
+	copy(path, previousPath)
+	analyzer.statePaths[stateNumber] = append(path, title)
+}
+
+// in GLR mode a conflict is not an error, the other action is kept inside the cell so that the
+// runtime can fork the parse stack on it
+func (analyzer *Analyzer) reportConflict(symbol1 *Symbol, symbol2 *Symbol, terminal SymbolTitle, cell *ActionCell, otherAction *ActionCell) {
+	conflictError := analyzer.errConflict(symbol1, symbol2, terminal)
+	if !analyzer.input.IsGLRMode {
+		analyzer.Errors = append(analyzer.Errors, conflictError)
+		return
+	}
+	analyzer.conflicts = append(analyzer.conflicts, conflictError)
+	if cell.Action == otherAction.Action && cell.ReduceRule == otherAction.ReduceRule {
+		return
+	}
+	for _, action := range cell.ConflictingActions {
+		if action.Action == otherAction.Action && action.ReduceRule == otherAction.ReduceRule {
+			return
+		}
+	}
+	cell.ConflictingActions = append(cell.ConflictingActions, otherAction)
+}
+
+func (analyzer *Analyzer) errConflict(symbol1 *Symbol, symbol2 *Symbol, terminal SymbolTitle) *ConflictError {
+	stateNumber := analyzer.currentState()
+	return ErrConflict(symbol1, symbol2, stateNumber, terminal, analyzer.statePaths[stateNumber], analyzer.isDebug)
+}
+
 type ByTitleAndLookahead []*Symbol
 func (s ByTitleAndLookahead) Len() int      { return len(s) }
 func (s ByTitleAndLookahead) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
@@ -597,12 +974,14 @@ func (analyzer *Analyzer) makeAugmentedGrammar(startSymbol string) *Symbol {
 	order := startRules[0].Order
 	ruleName := "S" + startSymbol
 	rule := &kuuhaku_parser.Rule{
-		Name:  ruleName,
-		Order: order,
+		Name:        ruleName,
+		Order:       order,
+		SourceOrder: startRules[0].SourceOrder,
 		MatchRules: []kuuhaku_parser.MatchRule{
 			kuuhaku_parser.Identifier{
 				Name:     startSymbol,
 				Position: startRules[0].Position,
+				Binding:  startSymbol + "1",
 			},
 		},
 		Position: startRules[0].Position,
@@ -616,6 +995,10 @@ func (analyzer *Analyzer) makeAugmentedGrammar(startSymbol string) *Symbol {
 }
 
 func (analyzer *Analyzer) analyzeLuaLiteral(source *kuuhaku_parser.LuaLiteral) {
+	if analyzer.isChecked(source.Position, source.LuaString) {
+		return
+	}
+
 	L := lua.NewState()
 	defer L.Close()
 
@@ -625,12 +1008,30 @@ func (analyzer *Analyzer) analyzeLuaLiteral(source *kuuhaku_parser.LuaLiteral) {
 	}
 }
 
+// the alternatives desugared from one written alternative share its position and its number, the
+// runtime uses the number to tell which alternative a lua error comes from
+func (analyzer *Analyzer) numberAlternatives() {
+	for _, rules := range analyzer.input.Rules {
+		numbers := make(map[kuuhaku_tokenizer.Position]int)
+		for _, rule := range rules {
+			number, ok := numbers[rule.Position]
+			if !ok {
+				number = len(numbers) + 1
+				numbers[rule.Position] = number
+			}
+			rule.Alternative = number
+		}
+	}
+}
+
 // return start symbols
 func (analyzer *Analyzer) analyzeStart() []string {
 	startSymbols := make([]string, len(analyzer.input.Rules))
 	i := 0
-	for key := range analyzer.input.Rules {
-		startSymbols[i] = key
+	for key, ruleArray := range analyzer.input.Rules {
+		if !ruleArray[0].IsImported {
+			startSymbols[i] = key
+		}
 		i++
 	}
 
@@ -639,16 +1040,22 @@ func (analyzer *Analyzer) analyzeStart() []string {
 			if rule.ReplaceRule != nil{
 				analyzer.analyzeLuaLiteral(rule.ReplaceRule)
 			}
+			if rule.CollectRule != nil {
+				analyzer.analyzeLuaLiteral(rule.CollectRule)
+			}
+			analyzer.analyzeBindings(rule)
 			for _, matchRule := range rule.MatchRules {
 				identifier, ok := matchRule.(kuuhaku_parser.Identifier)
 				if !ok {
 					continue
 				}
 
-				if len(analyzer.input.Rules[identifier.Name]) == 0 {
-					analyzer.Errors = append(analyzer.Errors, ErrUndefinedVariable(identifier.Position, identifier.Name))
-				} else if !analyzer.doesMatchingArgumentNumberRuleExist(identifier) {
-					analyzer.Errors = append(analyzer.Errors, ErrInvalidArgLength(identifier.Position, identifier.Name, len(identifier.ArgList)))
+				if !analyzer.isChecked(identifier.Position, identifier.Name) {
+					if len(analyzer.input.Rules[identifier.Name]) == 0 {
+						analyzer.Errors = append(analyzer.Errors, ErrUndefinedVariable(identifier.Position, identifier.Name))
+					} else if !analyzer.doesMatchingArgumentNumberRuleExist(identifier) {
+						analyzer.Errors = append(analyzer.Errors, ErrInvalidArgLength(identifier.Position, identifier.Name, len(identifier.ArgList)))
+					}
 				}
 
 				for _, arg := range identifier.ArgList {
@@ -674,6 +1081,56 @@ func (analyzer *Analyzer) analyzeStart() []string {
 	return outputSymbols
 }
 
+// checks that every lua variable of an alternative is bound once and doesn't shadow a parameter.
+// The list rules are skipped since their elements end up in a table instead of variables
+func (analyzer *Analyzer) analyzeBindings(rule *kuuhaku_parser.Rule) {
+	if rule.Hidden == kuuhaku_parser.HIDDEN_LIST {
+		return
+	}
+	ruleName, _, _ := strings.Cut(rule.Name, "#")
+
+	params := make(map[string]bool)
+	for _, param := range rule.ArgList {
+		params[param.Name] = true
+	}
+
+	globals := analyzer.getLuaGlobals()
+	bound := make(map[string]bool)
+	check := func(position kuuhaku_tokenizer.Position, name string) {
+		if globals[name] || strings.HasPrefix(name, "__kuuhaku_") {
+			if !analyzer.isChecked(position, "global:"+name) {
+				analyzer.Errors = append(analyzer.Errors, ErrBindingShadowsGlobal(position, name, ruleName))
+			}
+		} else if params[name] {
+			if !analyzer.isChecked(position, "param:"+name) {
+				analyzer.Errors = append(analyzer.Errors, ErrBindingClashesWithParam(position, name, ruleName))
+			}
+		} else if bound[name] {
+			if !analyzer.isChecked(position, "binding:"+name) {
+				analyzer.Errors = append(analyzer.Errors, ErrDuplicateBinding(position, name, ruleName))
+			}
+		}
+		bound[name] = true
+	}
+	//absent bindings have no position of their own, checking them first reports the clash at the present match rule
+	for _, absent := range rule.AbsentBindings {
+		check(rule.Position, absent.Name)
+	}
+	for _, matchRule := range rule.MatchRules {
+		check(matchRule.GetPosition(), kuuhaku_parser.GetBinding(matchRule))
+	}
+}
+
+// returns whether the check has been done before and marks it as done
+func (analyzer *Analyzer) isChecked(position kuuhaku_tokenizer.Position, name string) bool {
+	key := checkKey{position: position, name: name}
+	if analyzer.checked[key] {
+		return true
+	}
+	analyzer.checked[key] = true
+	return false
+}
+
 func (analyzer *Analyzer) doesMatchingArgumentNumberRuleExist(ruleName kuuhaku_parser.Identifier) bool {
 	for _, rule := range analyzer.input.Rules[ruleName.Name] {
 		if len(rule.ArgList) == len(ruleName.ArgList) {
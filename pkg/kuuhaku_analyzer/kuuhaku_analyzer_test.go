@@ -2,9 +2,11 @@ package kuuhaku_analyzer
 
 import (
 	"errors"
-	"reflect"
 	"github.com/h2so5/goback/regexp"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/ciii1/kuuhaku/internal/helper"
@@ -50,10 +52,72 @@ func TestErrorInvalidArgumentLength(t *testing.T) {
 	}
 }
 
+func TestErrorBindings(t *testing.T) {
+	ast, errs := kuuhaku_parser.Parse("test(a){a:<x> name:<y>? name:<z>}\nstart{test(`1`)}")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+
+	analyzer := initAnalyzer(&ast, false)
+	_ = analyzer.analyzeStart()
+
+	println("TestErrorBindings - Errors:")
+	helper.DisplayAllErrors(analyzer.Errors)
+
+	if len(analyzer.Errors) != 2 {
+		println("Expected analyzer Errors length to be 2, got " + strconv.Itoa(len(analyzer.Errors)))
+		t.Fatal()
+	}
+
+	var analyzeError *AnalyzeError
+	if !errors.As(analyzer.Errors[0], &analyzeError) || analyzeError.Type != BINDING_CLASHES_WITH_PARAM || analyzeError.Position.Column != 11 {
+		println("Expected the first error to be BINDING_CLASHES_WITH_PARAM at column 11")
+		t.Fail()
+	}
+	if !errors.As(analyzer.Errors[1], &analyzeError) || analyzeError.Type != DUPLICATE_BINDING || analyzeError.Position.Column != 30 {
+		println("Expected the second error to be DUPLICATE_BINDING at column 30")
+		t.Fail()
+	}
+}
+
+func TestErrorShadowingBindings(t *testing.T) {
+	ast, errs := kuuhaku_parser.Parse("``helper = function(x) return x end local limit = 1``\nstart{helper:<a> string:<b> DOC:<c> limit:<d> __kuuhaku_x:<e> name:<f>}")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+
+	analyzer := initAnalyzer(&ast, false)
+	_ = analyzer.analyzeStart()
+
+	println("TestErrorShadowingBindings - Errors:")
+	helper.DisplayAllErrors(analyzer.Errors)
+
+	if len(analyzer.Errors) != 5 {
+		println("Expected analyzer Errors length to be 5, got " + strconv.Itoa(len(analyzer.Errors)))
+		t.Fatal()
+	}
+	for _, err := range analyzer.Errors {
+		var analyzeError *AnalyzeError
+		if !errors.As(err, &analyzeError) || analyzeError.Type != BINDING_SHADOWS_GLOBAL {
+			println("Expected every error to be BINDING_SHADOWS_GLOBAL")
+			t.Fail()
+		}
+	}
+	var analyzeError *AnalyzeError
+	if errors.As(analyzer.Errors[0], &analyzeError) && (analyzeError.Position.Line != 2 || analyzeError.Position.Column != 14) {
+		println("Expected the first error to be at (2, 14)")
+		t.Fail()
+	}
+}
+
 func TestErrorUndefinedVariable(t *testing.T) {
 	ast, errs := kuuhaku_parser.Parse("identifier{test<\\.>}\ntest2{identifier}\ntest34{test4}")
-	if len(errs) != 1 {
-		println("Expected parser errors length to be 1")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
 		t.Fatal()
 	}
 
@@ -105,8 +169,8 @@ func TestErrorUndefinedVariable(t *testing.T) {
 
 func TestStartSymbols(t *testing.T) {
 	ast, errs := kuuhaku_parser.Parse("identifier{test<\\.>}\ntest{<\\.>}\nidentifier{<\\.>}\ntest3{<\\.>}")
-	if len(errs) != 1 {
-		println("Expected parser errors length to be 1")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
 		t.Fatal()
 	}
 	analyzer := initAnalyzer(&ast, false)
@@ -139,8 +203,8 @@ func TestStartSymbols(t *testing.T) {
 
 func TestExpandSymbol(t *testing.T) {
 	ast, errs := kuuhaku_parser.Parse("identifier{test<\\.>}\ntest{test3}\nidentifier{<\\.>}\ntest3{<\\.>}")
-	if len(errs) != 1 {
-		println("Expected parser errors length to be 1")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
 		t.Fatal()
 	}
 	analyzer := initAnalyzer(&ast, false)
@@ -260,8 +324,8 @@ func TestExpandSymbol(t *testing.T) {
 
 func TestExpandSymbol2(t *testing.T) {
 	ast, errs := kuuhaku_parser.Parse("identifier{<\\.>test}\ntest{<\\.>}\nidentifier{<\\.>}\ntest3{<\\.>}")
-	if len(errs) != 1 {
-		println("Expected parser errors length to be 1")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
 		t.Fatal()
 	}
 	analyzer := initAnalyzer(&ast, false)
@@ -346,8 +410,8 @@ func TestExpandSymbol2(t *testing.T) {
 
 func TestExpandSymbol3(t *testing.T) {
 	ast, errs := kuuhaku_parser.Parse("identifier{<\\.>test}\ntest{<\\.>}\nidentifier{<\\.>}\ntest3{<\\.>}")
-	if len(errs) != 1 {
-		println("Expected parser errors length to be 1")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
 		t.Fatal()
 	}
 	analyzer := initAnalyzer(&ast, false)
@@ -373,8 +437,8 @@ func TestExpandSymbol3(t *testing.T) {
 
 func TestGroupSymbols(t *testing.T) {
 	ast, errs := kuuhaku_parser.Parse("identifier{test<\\.>}\ntest{<\\.>}\nidentifier{<\\.>}\ntest3{<\\.>}")
-	if len(errs) != 1 {
-		println("Expected parser errors length to be 1")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
 		t.Fatal()
 	}
 	analyzer := initAnalyzer(&ast, false)
@@ -485,8 +549,8 @@ func TestGroupSymbols(t *testing.T) {
 
 func TestBuildParseTableStateTransition(t *testing.T) {
 	ast, errs := kuuhaku_parser.Parse("identifier{test<\\.>}\ntest{<hello>}\nidentifier{<\\.>}\ntest3{<\\.>}")
-	if len(errs) != 1 {
-		println("Expected parser errors length to be 1")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
 		t.Fatal()
 	}
 	analyzer := initAnalyzer(&ast, false)
@@ -595,8 +659,8 @@ func TestBuildParseTableStateTransition(t *testing.T) {
 
 func TestBuildParseTable(t *testing.T) {
 	ast, errs := kuuhaku_parser.Parse("identifier{test<\\.>}\ntest{<\\.>}\nidentifier{<\\.>}")
-	if len(errs) != 1 {
-		println("Expected parser errors length to be 1")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
 		t.Fatal()
 	}
 	analyzer := initAnalyzer(&ast, false)
@@ -661,8 +725,8 @@ func TestBuildParseTable(t *testing.T) {
 
 func TestBuildParseTableErrorMultiplePartialReduce(t *testing.T) {
 	ast, errs := kuuhaku_parser.Parse("E{B <1>} E{<1> B C} B{<1> <2>} B{<2>} C{<2>} C{<1>}")
-	if len(errs) != 3 {
-		println("Expected parser errors length to be 3")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
 		t.Fatal()
 	}
 	analyzer := initAnalyzer(&ast, false)
@@ -731,8 +795,8 @@ func TestBuildParseTableErrorMultiplePartialReduce(t *testing.T) {
 
 func TestBuildParseTable2(t *testing.T) {
 	ast, errs := kuuhaku_parser.Parse("E{E <*> B} E{E <+> B} E{B} B{<0>} B{<1>}")
-	if len(errs) != 2 {
-		println("Expected parser errors length to be 2")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
 		t.Fatal()
 	}
 	analyzer := initAnalyzer(&ast, false)
@@ -799,8 +863,8 @@ func TestBuildParseTable2(t *testing.T) {
 
 func TestBuildParseTableErrorPartialReduceAndShift(t *testing.T) {
 	ast, errs := kuuhaku_parser.Parse("E{B <1>} E{<1> B C} B{<2> <1>} B{<1>} C{<2>} C{<1>}")
-	if len(errs) != 3 {
-		println("Expected parser errors length to be 3")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
 		t.Fatal()
 	}
 	analyzer := initAnalyzer(&ast, false)
@@ -911,8 +975,8 @@ func TestBuildParseTableErrorMultipleEndReduce(t *testing.T) {
 
 func TestGetAllTerminalsAndLhs(t *testing.T) {
 	ast, errs := kuuhaku_parser.Parse("E{C} E{B} B{<0>} B{<1>} C{<1>} D{<3> F} F{<1>} B{<5>} B{<10>} B{<9>}")
-	if len(errs) != 1 {
-		println("Expected parser errors length to be 1")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
 		t.Fatal()
 	}
 	analyzer := initAnalyzer(&ast, false)
@@ -1037,8 +1101,8 @@ func TestGetAllTerminalsAndLhs(t *testing.T) {
 
 func TestGetAllTerminalsAndLhsRegexError(t *testing.T) {
 	ast, errs := kuuhaku_parser.Parse("E{C} E{B} B{<0>} B{<1>} C{<1>} D{<3> F} F{<1>} B{<5>} B{<10>} B{<[>}")
-	if len(errs) != 1 {
-		println("Expected parser errors length to be 1")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
 		t.Fatal()
 	}
 	analyzer := initAnalyzer(&ast, false)
@@ -1075,8 +1139,8 @@ func TestGetAllTerminalsAndLhsRegexError(t *testing.T) {
 
 func TestAnalyze(t *testing.T) {
 	ast, errs := kuuhaku_parser.Parse("E{C} E{B} B{<0>} B{<1>} C{<1>} D{<3> F} F{<1>}")
-	if len(errs) != 1 {
-		println("Expected parser errors length to be 1")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
 		t.Fatal()
 	}
 	_, errs = Analyze(&ast, false)
@@ -1102,8 +1166,8 @@ func TestAnalyze(t *testing.T) {
 
 func TestAnalyze2(t *testing.T) {
 	ast, errs := kuuhaku_parser.Parse("SEARCH_MODE ``Hello`` E{C} E{B} B{<0>} B{<1>} C{<3>} D{<3> F} F{<1>} G{<2>}")
-	if len(errs) != 1 {
-		println("Expected parser errors length to be 1")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
 		t.Fatal()
 	}
 	res, errs := Analyze(&ast, false)
@@ -1125,3 +1189,317 @@ func TestAnalyze2(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestAnalyzeStartSymbolStates(t *testing.T) {
+	// the states of X are in both parse tables
+	res := analyzeGrammar(t, "SEARCH_MODE A{<a> X} B{<b> X} X{<x>}")
+	if len(res.ParseTables) != 2 {
+		println("Expected parse tables length to be 2, got " + strconv.Itoa(len(res.ParseTables)))
+		t.Fatal()
+	}
+	for i, parseTable := range res.ParseTables {
+		for _, state := range parseTable.States {
+			for _, actionCell := range state.ActionTable {
+				if actionCell.Action == SHIFT && actionCell.ShiftState >= len(parseTable.States) {
+					println("Expected the shift states of parse table " + strconv.Itoa(i) + " to be in the table, got " + strconv.Itoa(actionCell.ShiftState))
+					t.Fail()
+				}
+			}
+			for _, gotoCell := range state.GotoTable {
+				if gotoCell.GotoState >= len(parseTable.States) {
+					println("Expected the goto states of parse table " + strconv.Itoa(i) + " to be in the table, got " + strconv.Itoa(gotoCell.GotoState))
+					t.Fail()
+				}
+			}
+		}
+	}
+}
+
+func TestConflictCounterexample(t *testing.T) {
+	ast, errs := kuuhaku_parser.Parse("E{C} E{B} B{<0>} B{<1>} C{<1>}")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
+		t.Fatal()
+	}
+	analyzer := initAnalyzer(&ast, false)
+	analyzer.parseTables = append(analyzer.parseTables, analyzer.makeEmptyParseTable("E"))
+	analyzer.buildParseTable("E")
+
+	if len(analyzer.Errors) != 1 {
+		println("Expected analyzer.Error length to be 1, got " + strconv.Itoa(len(analyzer.Errors)))
+		t.Fatal()
+	}
+
+	var conflictError *ConflictError
+	if !errors.As(analyzer.Errors[0], &conflictError) {
+		println("Expected a conflict error")
+		t.Fatal()
+	}
+	if conflictError.Terminal.Type != EMPTY_TITLE {
+		println("Expected the conflicting terminal to be <end>, got " + conflictError.Terminal.String)
+		t.Fail()
+	}
+	if len(conflictError.Example) != 1 || conflictError.Example[0].String != "1" || conflictError.Example[0].Type != REGEX_LITERAL_TITLE {
+		println("Expected the example to be <1>")
+		t.Fail()
+	}
+	derivations := []string{conflictError.Derivation1, conflictError.Derivation2}
+	sort.Strings(derivations)
+	if derivations[0] != "B -> <1> • [<end>]" || derivations[1] != "C -> <1> • [<end>]" {
+		println("Expected the derivations to be \"B -> <1> • [<end>]\" and \"C -> <1> • [<end>]\", got \"" + derivations[0] + "\" and \"" + derivations[1] + "\"")
+		t.Fail()
+	}
+}
+
+func TestConflictRuleNumbers(t *testing.T) {
+	// the alternatives and the hidden rule desugared from the first rule don't shift the numbers
+	ast, errs := kuuhaku_parser.Parse("S{<x>? <y>* B} B{<b>} B{<b>}")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
+		t.Fatal()
+	}
+	_, errs = Analyze(&ast, false)
+	if len(errs) != 1 {
+		println("Expected 1 conflict, got " + strconv.Itoa(len(errs)))
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	if !strings.Contains(errs[0].Error(), "Detected conflict at rule 3 and rule 2 ") {
+		println("Expected the conflict to be at rule 3 and rule 2, got " + errs[0].Error())
+		t.Fail()
+	}
+}
+
+func TestNullableAndFirstTerminals(t *testing.T) {
+	ast, errs := kuuhaku_parser.Parse("S{A B <c>} A{} A{<a>} B{A A} B{<b>}")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	analyzer := initAnalyzer(&ast, false)
+	analyzer.nullable = analyzer.getNullableRules()
+	if !analyzer.nullable["A"] || !analyzer.nullable["B"] || analyzer.nullable["S"] {
+		println("Expected A and B to be nullable and S to not be nullable")
+		t.Fail()
+	}
+
+	var terminals []string
+	analyzer.getFirstTerminals("S", make(map[string]bool), &terminals)
+	if strings.Join(terminals, " ") != "a b c" {
+		println("Expected the first terminals of S to be a b c, got " + strings.Join(terminals, " "))
+		t.Fail()
+	}
+}
+
+func TestErrorLexerModes(t *testing.T) {
+	ast, errs := kuuhaku_parser.Parse("MODE s { <x> } POP s { <a> } PUSH t { <a> } PUSH u { <a> } S{<a>}")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	_, errs = Analyze(&ast, false)
+	expected := []AnalyzeErrorType{MODE_NEVER_PUSHED, UNDEFINED_TERMINAL, MULTIPLE_PUSHED_MODES}
+	if len(errs) != len(expected) {
+		println("Expected " + strconv.Itoa(len(expected)) + " errors, got " + strconv.Itoa(len(errs)))
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	for i, errorType := range expected {
+		var analyzeError *AnalyzeError
+		if !errors.As(errs[i], &analyzeError) || analyzeError.Type != errorType {
+			println("Unexpected error at index " + strconv.Itoa(i))
+			helper.DisplayAllErrors(errs)
+			t.Fail()
+		}
+	}
+}
+
+func TestWarnShadowedLongerMatch(t *testing.T) {
+	rules := "S{Word+} Word{<if>} Word{<[a-z]+>} Word{<=>} Word{<==>}"
+	tests := map[string]int{
+		rules:                             2,
+		"LONGEST_MATCH " + rules:          0,
+		"LONGEST_MATCH { <if> } " + rules: 1,
+		"LONGEST_MATCH FIRST_MATCH { <=> } " + rules: 1,
+	}
+	for grammar, expected := range tests {
+		res := analyzeGrammar(t, grammar)
+		if len(res.Warnings) != expected {
+			println("Expected " + strconv.Itoa(expected) + " warnings for " + grammar + ", got " + strconv.Itoa(len(res.Warnings)))
+			helper.DisplayAllErrors(res.Warnings)
+			t.Fail()
+		}
+	}
+
+	ast, _ := kuuhaku_parser.Parse(rules)
+	res, _ := Analyze(&ast, false)
+	var warning *AnalyzeWarning
+	if !errors.As(res.Warnings[0], &warning) || warning.Type != SHADOWED_LONGER_MATCH {
+		println("Expected a SHADOWED_LONGER_MATCH warning")
+		t.Fatal()
+	}
+	if !strings.Contains(warning.Message, "\"ifa\"") || warning.Position.Column != 15 {
+		println("Expected the warning to point at <if> with the example \"ifa\", got " + warning.Error())
+		t.Fail()
+	}
+}
+
+func TestWarnOverlappingTerminals(t *testing.T) {
+	tests := []struct {
+		grammar  string
+		expected []AnalyzeWarningType
+	}{
+		{"S{Word+} Word{<if>} Word{<[a-z]+>}", []AnalyzeWarningType{SHADOWED_LONGER_MATCH}},
+		{"S{Word+} Word{<[a-z]+>} Word{<if>}", []AnalyzeWarningType{SHADOWED_TERMINAL}},
+		{"LONGEST_MATCH S{Word+} Word{<[a-z]+>} Word{<if>}", []AnalyzeWarningType{SHADOWED_TERMINAL}},
+		{"S{<a> <b>} S{<b> <a>}", nil},
+		{"S{<a> <[a-z]+>}", nil},
+		{"S{<a> Space <b>} Space{<[ ]*>}", []AnalyzeWarningType{EMPTY_MATCH}},
+	}
+	for _, test := range tests {
+		res := analyzeGrammar(t, test.grammar)
+		checkWarningTypes(t, test.grammar, res.Warnings, test.expected)
+	}
+
+	ast, _ := kuuhaku_parser.Parse("S{Word+} Word{<[a-z]+>} Word{<if>}")
+	res, _ := Analyze(&ast, false)
+	var warning *AnalyzeWarning
+	if len(res.Warnings) == 0 || !errors.As(res.Warnings[0], &warning) {
+		println("Expected a warning")
+		t.Fatal()
+	}
+	if !strings.Contains(warning.Message, "\"if\"") || warning.Position.Column != 30 {
+		println("Expected the warning to point at <if> with the example \"if\", got " + warning.Error())
+		t.Fail()
+	}
+}
+
+func TestWarnRuleUsage(t *testing.T) {
+	tests := []struct {
+		grammar  string
+		expected []AnalyzeWarningType
+	}{
+		{"S{<a> B} B{<b>}", nil},
+		{"S{<a>} A{<x> B} B{<y> A?}", []AnalyzeWarningType{UNREACHABLE_RULE, UNREACHABLE_RULE}},
+		{"GLR_MODE S{<a> B} B{<b>} B{<c>} B{<b>}", []AnalyzeWarningType{DUPLICATE_ALTERNATIVE}},
+		{"GLR_MODE S{<a> B} B{<b> = `\"x\"`} B{<b> = `\"y\"`}", nil},
+		{"GLR_MODE S{<a> B} B{} B{<b>} B{}", []AnalyzeWarningType{DUPLICATE_ALTERNATIVE}},
+		{"S{<a> B(`1`, `2`)} B(x, y){<b> C(`x`)} C(z){<c> = `z`}", []AnalyzeWarningType{UNUSED_PARAM}},
+		{"S{<a> B(`1`)} B(x){(<b> C(`x`))+} C(y){<c> = `y`}", nil},
+		{"S{<a> B(`1`)} B(x){(<b> C(`1`))+} C(y){<c> = `y`}", []AnalyzeWarningType{UNUSED_PARAM}},
+	}
+	for _, test := range tests {
+		res := analyzeGrammar(t, test.grammar)
+		checkWarningTypes(t, test.grammar, res.Warnings, test.expected)
+	}
+}
+
+func TestFilterWarnings(t *testing.T) {
+	ast, errs := kuuhaku_parser.Parse("S{Word+ B(`1`)} Word{<[a-z]+>} Word{<if>} B(x){<0>}")
+	if len(errs) != 0 {
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	res, errs := Analyze(&ast, false)
+	if len(errs) != 0 || len(res.Warnings) != 2 {
+		println("Expected no errors and 2 warnings")
+		helper.DisplayAllErrors(errs)
+		helper.DisplayAllErrors(res.Warnings)
+		t.Fatal()
+	}
+	promoted, notes := SplitPromotedWarnings(res.Warnings)
+	if len(promoted) != 1 || len(notes) != 1 {
+		println("Expected the unused param to be the only note")
+		t.Fail()
+	}
+	if !strings.HasPrefix(notes[0].Error(), "Analyze note W007") || !strings.HasPrefix(promoted[0].Error(), "Analyze warning W002") {
+		println("Unexpected warning codes: " + promoted[0].Error() + ", " + notes[0].Error())
+		t.Fail()
+	}
+	if len(FilterWarnings(res.Warnings, []string{"W002", "W007"})) != 0 || len(FilterWarnings(res.Warnings, []string{"W001"})) != 2 {
+		println("Expected FilterWarnings to only leave out the suppressed codes")
+		t.Fail()
+	}
+	if !IsWarningCode("W005") || IsWarningCode("W999") {
+		println("Unexpected result of IsWarningCode")
+		t.Fail()
+	}
+}
+
+func TestWarnUnknownLuaName(t *testing.T) {
+	tests := []struct {
+		grammar  string
+		expected []string
+	}{
+		{"S{Elements:<a>+ = `table.concat(Elements, \",\")`}", nil},
+		{"S{Elements:<a>+ = `table.concat(Elemnts, \",\")`}", []string{"Elemnts (1, 33)"}},
+		{"S{<a> B(`LITERAL1`)} B(x){<b> = `x .. LITERAL1 .. y`}", []string{"y (1, 51)"}},
+		{"S{<a> B(`LITERAL2`)} B(x){<b> = `x`}", []string{"LITERAL2 (1, 10)"}},
+		{"S{<a> <b>? = ``\nlocal out = LITERAL1 .. (LITERAL2 or \"\")\nfor i, v in ipairs({1}) do out = out .. v .. i end\nreturn out .. TRIVIA.LITERAL1.leading .. count``}",
+			[]string{"count (4, 42)"}},
+		{"``\nlocal prefix = \"p\"\nfunction wrap(s) return prefix .. s end\n``\nS{<a> = `wrap(LITERAL1) .. counter`} S{<b> = ``counter = 1\nreturn \"\"``}", nil},
+		// TRIVIA is a local of the rules, the global lua can't see it
+		{"``\nfunction lead() return TRIVIA end\n``\nS{<a> = `lead() .. TRIVIA.LITERAL1.leading`}", []string{"TRIVIA (2, 24)"}},
+		{"S{<a> = ``local function f(n) if n == 0 then return 0 end return f(n - 1) end\nlocal t = {}\nfunction t:g() return self end\nreturn f(1) .. t:g() .. self``}",
+			[]string{"self (4, 25)"}},
+	}
+	for _, test := range tests {
+		res := analyzeGrammar(t, test.grammar)
+		var got []string
+		for _, warning := range res.Warnings {
+			var analyzeWarning *AnalyzeWarning
+			if errors.As(warning, &analyzeWarning) && analyzeWarning.Type == UNKNOWN_LUA_NAME {
+				name, _, _ := strings.Cut(analyzeWarning.Message, " ")
+				got = append(got, name+" ("+analyzeWarning.Position.Location()+")")
+				// the offset points at the name like the line and the column
+				raw := analyzeWarning.Position.Raw
+				if raw < 0 || raw+len(name) > len(test.grammar) || test.grammar[raw:raw+len(name)] != name {
+					println("Expected the raw position of " + name + " to point at it in " + test.grammar)
+					t.Fail()
+				}
+			}
+		}
+		if strings.Join(got, ", ") != strings.Join(test.expected, ", ") {
+			println("Expected unknown names [" + strings.Join(test.expected, ", ") + "] for " + test.grammar + ", got [" + strings.Join(got, ", ") + "]")
+			t.Fail()
+		}
+	}
+}
+
+// parses and analyzes the grammar of a test, the test stops if there is an error
+func analyzeGrammar(t *testing.T, grammar string) AnalyzerResult {
+	t.Helper()
+	ast, errs := kuuhaku_parser.Parse(grammar)
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	res, errs := Analyze(&ast, false)
+	if len(errs) != 0 {
+		println("Expected analyzer errors length to be 0 for " + grammar)
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	return res
+}
+
+func checkWarningTypes(t *testing.T, grammar string, warnings []error, expected []AnalyzeWarningType) {
+	t.Helper()
+	if len(warnings) != len(expected) {
+		println("Expected " + strconv.Itoa(len(expected)) + " warnings for " + grammar + ", got " + strconv.Itoa(len(warnings)))
+		helper.DisplayAllErrors(warnings)
+		t.Fail()
+		return
+	}
+	for i, warningType := range expected {
+		var warning *AnalyzeWarning
+		if !errors.As(warnings[i], &warning) || warning.Type != warningType {
+			println("Unexpected warning at index " + strconv.Itoa(i) + " for " + grammar)
+			helper.DisplayAllErrors(warnings)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package kuuhaku_analyzer
+
+import (
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_parser"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+)
+
+func ErrUndefinedTerminal(position kuuhaku_tokenizer.Position, terminal string) *AnalyzeError {
+	return &AnalyzeError{
+		Message:  "The terminal <" + terminal + "> isn't used by any rule",
+		Position: position,
+		Type:     UNDEFINED_TERMINAL,
+	}
+}
+
+func ErrModeNeverPushed(position kuuhaku_tokenizer.Position, mode string) *AnalyzeError {
+	return &AnalyzeError{
+		Message:  "The lexer mode " + mode + " is never pushed",
+		Position: position,
+		Type:     MODE_NEVER_PUSHED,
+	}
+}
+
+func ErrMultiplePushedModes(position kuuhaku_tokenizer.Position, terminal string, mode1 string, mode2 string) *AnalyzeError {
+	return &AnalyzeError{
+		Message:  "The terminal <" + terminal + "> pushes both " + mode1 + " and " + mode2,
+		Position: position,
+		Type:     MULTIPLE_PUSHED_MODES,
+	}
+}
+
+// getLexerModes returns the lexer modes by name together with the mode each pushing terminal pushes
+func (analyzer *Analyzer) getLexerModes() (map[string]*LexerMode, map[string]string) {
+	if len(analyzer.input.Modes) == 0 {
+		return nil, nil
+	}
+
+	terminals := analyzer.getGrammarTerminals()
+	modes := make(map[string]*LexerMode)
+	pushes := make(map[string]string)
+	for _, inputMode := range analyzer.input.Modes {
+		if len(inputMode.Pushes) == 0 {
+			analyzer.Errors = append(analyzer.Errors, ErrModeNeverPushed(inputMode.Position, inputMode.Name))
+		}
+
+		mode := &LexerMode{
+			Pops: analyzer.getDeclaredTerminals(inputMode.Pops, terminals),
+		}
+		if inputMode.IsRestricted {
+			mode.Terminals = analyzer.getDeclaredTerminals(inputMode.Terminals, terminals)
+		}
+		modes[inputMode.Name] = mode
+
+		for _, push := range inputMode.Pushes {
+			if !terminals[push.RegexString] {
+				analyzer.Errors = append(analyzer.Errors, ErrUndefinedTerminal(push.Position, push.RegexString))
+				continue
+			}
+			if pushes[push.RegexString] != "" {
+				analyzer.Errors = append(analyzer.Errors, ErrMultiplePushedModes(push.Position, push.RegexString, pushes[push.RegexString], inputMode.Name))
+				continue
+			}
+			pushes[push.RegexString] = inputMode.Name
+		}
+	}
+	return modes, pushes
+}
+
+func (analyzer *Analyzer) getDeclaredTerminals(regexLiterals []kuuhaku_parser.RegexLiteral, terminals map[string]bool) map[string]bool {
+	out := make(map[string]bool)
+	for _, regexLiteral := range regexLiterals {
+		if !terminals[regexLiteral.RegexString] {
+			analyzer.Errors = append(analyzer.Errors, ErrUndefinedTerminal(regexLiteral.Position, regexLiteral.RegexString))
+			continue
+		}
+		out[regexLiteral.RegexString] = true
+	}
+	return out
+}
+
+func (analyzer *Analyzer) getGrammarTerminals() map[string]bool {
+	terminals := make(map[string]bool)
+	for _, rules := range analyzer.input.Rules {
+		for _, rule := range rules {
+			for _, matchRule := range rule.MatchRules {
+				regexLiteral, ok := matchRule.(kuuhaku_parser.RegexLiteral)
+				if ok {
+					terminals[regexLiteral.RegexString] = true
+				}
+			}
+		}
+	}
+	return terminals
+}
@@ -0,0 +1,165 @@
+package kuuhaku_analyzer
+
+import (
+	"regexp/syntax"
+	"strconv"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+)
+
+func WarnShadowedLongerMatch(position kuuhaku_tokenizer.Position, first string, longer string, example string) *AnalyzeWarning {
+	return &AnalyzeWarning{
+		Message:  "<" + first + "> is matched before <" + longer + "> even though <" + longer + "> matches the longer " + strconv.Quote(example) + ". Use LONGEST_MATCH if the longer match should win",
+		Position: position,
+		Type:     SHADOWED_LONGER_MATCH,
+		Severity: SEVERITY_WARNING,
+	}
+}
+
+// the terminals listed in LONGEST_MATCH { } and FIRST_MATCH { }, a terminal in both uses the first match
+func (analyzer *Analyzer) getMatchOverrides() map[string]bool {
+	if len(analyzer.input.LongestMatchTerminals) == 0 && len(analyzer.input.FirstMatchTerminals) == 0 {
+		return nil
+	}
+	terminals := analyzer.getGrammarTerminals()
+	out := make(map[string]bool)
+	for terminal := range analyzer.getDeclaredTerminals(analyzer.input.LongestMatchTerminals, terminals) {
+		out[terminal] = true
+	}
+	for terminal := range analyzer.getDeclaredTerminals(analyzer.input.FirstMatchTerminals, terminals) {
+		out[terminal] = false
+	}
+	return out
+}
+
+func (analyzer *Analyzer) isLongestMatch(terminal string) bool {
+	isLongestMatch, ok := analyzer.matchOverrides[terminal]
+	if ok {
+		return isLongestMatch
+	}
+	return analyzer.input.IsLongestMatch
+}
+
+// checkShadowedMatches warns about first match terminals that are tried before a terminal that can
+// match a longer prefix of the same input in the same state. The inputs are built from an example of
+// each regex, regexes that use features the Go regexp/syntax package can't parse are skipped
+func (analyzer *Analyzer) checkShadowedMatches(parseTable *ParseTable) {
+	isChecked := make(map[[2]string]bool)
+	for _, state := range parseTable.States {
+		candidates := getCandidateTerminals(parseTable, state)
+		for i, first := range candidates {
+			if first.IsLongestMatch {
+				continue
+			}
+			for _, later := range candidates[i+1:] {
+				pair := [2]string{first.Terminal, later.Terminal}
+				if isChecked[pair] {
+					continue
+				}
+				isChecked[pair] = true
+				example, ok := findLongerMatch(first, later)
+				if ok {
+					position := analyzer.getTerminalPosition(first)
+					analyzer.Warnings = append(analyzer.Warnings, WarnShadowedLongerMatch(position, first.Terminal, later.Terminal, example))
+				}
+			}
+		}
+	}
+}
+
+// the position of the terminal in the rule that gives it its precedence
+func (analyzer *Analyzer) getTerminalPosition(terminal TerminalList) kuuhaku_tokenizer.Position {
+	for _, rules := range analyzer.input.Rules {
+		for _, rule := range rules {
+			if rule.Order != terminal.Precedence {
+				continue
+			}
+			for _, matchRule := range rule.MatchRules {
+				if matchRule.GetString() == terminal.Terminal {
+					return matchRule.GetPosition()
+				}
+			}
+		}
+	}
+	return analyzer.input.Position
+}
+
+// returns an input where first matches and later matches a longer prefix
+func findLongerMatch(first TerminalList, later TerminalList) (string, bool) {
+	firstExample, ok := getRegexExample(first.Terminal)
+	if !ok {
+		return "", false
+	}
+	laterExample, ok := getRegexExample(later.Terminal)
+	if !ok {
+		return "", false
+	}
+
+	inputs := []string{laterExample}
+	for _, char := range "aA0_" + laterExample {
+		inputs = append(inputs, firstExample+string(char))
+	}
+	for _, input := range inputs {
+		firstLoc := first.Regexp.FindStringIndex(input)
+		laterLoc := later.Regexp.FindStringIndex(input)
+		if firstLoc == nil || laterLoc == nil || firstLoc[1] == 0 {
+			continue
+		}
+		if laterLoc[1] > firstLoc[1] {
+			return input[:laterLoc[1]], true
+		}
+	}
+	return "", false
+}
+
+// returns a short string that the regex matches
+func getRegexExample(regex string) (string, bool) {
+	parsed, err := syntax.Parse(regex, syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	return syntaxToExample(parsed.Simplify()), true
+}
+
+func syntaxToExample(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return string(re.Rune)
+	case syntax.OpCharClass:
+		return string(getCharClassExample(re.Rune))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return "a"
+	case syntax.OpCapture, syntax.OpPlus:
+		return syntaxToExample(re.Sub[0])
+	case syntax.OpRepeat:
+		out := ""
+		for i := 0; i < re.Min; i++ {
+			out += syntaxToExample(re.Sub[0])
+		}
+		return out
+	case syntax.OpConcat:
+		out := ""
+		for _, sub := range re.Sub {
+			out += syntaxToExample(sub)
+		}
+		return out
+	case syntax.OpAlternate:
+		return syntaxToExample(re.Sub[0])
+	}
+	return ""
+}
+
+// prefers a printable ascii character so the example reads well in the warning
+func getCharClassExample(ranges []rune) rune {
+	for i := 0; i+1 < len(ranges); i += 2 {
+		for char := ranges[i]; char <= ranges[i+1] && char <= '~'; char++ {
+			if char > ' ' {
+				return char
+			}
+		}
+	}
+	if len(ranges) == 0 {
+		return 'a'
+	}
+	return ranges[0]
+}
@@ -0,0 +1,358 @@
+package kuuhaku_analyzer
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_parser"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+func WarnUnknownLuaName(position kuuhaku_tokenizer.Position, name string, ruleName string) *AnalyzeWarning {
+	return &AnalyzeWarning{
+		Message:  name + " is not a binding, param or global available in the rule " + ruleName,
+		Position: position,
+		Type:     UNKNOWN_LUA_NAME,
+		Severity: SEVERITY_WARNING,
+	}
+}
+
+// the globals the runtime sets before the global lua block runs
+var luaRuntimeGlobals = []string{"DOC", "COLLECTED"}
+
+// the locals the runtime declares for the lua of the rules, the global lua block can't see them
+var luaRuleNames = []string{"TRIVIA", "NODE"}
+
+// the names the bindings must not shadow: the lua standard library, the names the runtime defines and
+// the locals and globals of the global lua block. The names starting with __kuuhaku_ are reserved too
+func (analyzer *Analyzer) getLuaGlobals() map[string]bool {
+	if analyzer.luaGlobals != nil {
+		return analyzer.luaGlobals
+	}
+	analyzer.luaGlobals = getLuaStdlibNames()
+	for _, name := range luaRuntimeGlobals {
+		analyzer.luaGlobals[name] = true
+	}
+	for _, name := range luaRuleNames {
+		analyzer.luaGlobals[name] = true
+	}
+	if analyzer.input.GlobalLua != nil {
+		resolver := &luaResolver{assigned: make(map[string]bool)}
+		for name := range resolver.resolve(analyzer.input.GlobalLua, "", nil) {
+			analyzer.luaGlobals[name] = true
+		}
+		for name := range resolver.assigned {
+			analyzer.luaGlobals[name] = true
+		}
+	}
+	return analyzer.luaGlobals
+}
+
+// a read of a name that isn't declared in any enclosing lua scope
+type luaGlobalRead struct {
+	name     string
+	line     int
+	literal  *kuuhaku_parser.LuaLiteral
+	ruleName string
+}
+
+type luaResolver struct {
+	scopes   []map[string]bool
+	reads    []luaGlobalRead
+	assigned map[string]bool //globals assigned anywhere, they may be read from any lua literal
+	literal  *kuuhaku_parser.LuaLiteral
+	ruleName string
+}
+
+// checkLuaNames resolves the names read by the replace rules, collect rules and arguments against the
+// names the runtime defines for them. A replace rule sees the params, the bindings of its alternative
+// and NODE, a collect rule sees the same without the params and an argument sees the params and the
+// bindings of the match rules before it. All of them see the lua standard library, the locals of the
+// global lua block and every global assigned in any lua literal
+func (analyzer *Analyzer) checkLuaNames() {
+	resolver := &luaResolver{assigned: make(map[string]bool)}
+	known := getLuaStdlibNames()
+	for _, name := range luaRuntimeGlobals {
+		known[name] = true
+	}
+	if analyzer.input.GlobalLua != nil {
+		for name := range resolver.resolve(analyzer.input.GlobalLua, "", nil) {
+			known[name] = true
+		}
+	}
+
+	for _, name := range analyzer.getSortedRuleNames(nil) {
+		ruleName, _, _ := strings.Cut(name, "#")
+		for _, rule := range analyzer.input.Rules[name] {
+			var bindings []string
+			for _, param := range rule.ArgList {
+				bindings = append(bindings, param.Name)
+			}
+			for _, matchRule := range rule.MatchRules {
+				identifier, ok := matchRule.(kuuhaku_parser.Identifier)
+				if ok {
+					for i := range identifier.ArgList {
+						resolver.resolveOnce(analyzer, &identifier.ArgList[i], ruleName, bindings)
+					}
+				}
+				bindings = append(bindings, kuuhaku_parser.GetBinding(matchRule))
+			}
+			if rule.ReplaceRule == nil && rule.CollectRule == nil {
+				continue
+			}
+			for _, absent := range rule.AbsentBindings {
+				bindings = append(bindings, absent.Name)
+			}
+			bindings = append(bindings, luaRuleNames...)
+			if rule.ReplaceRule != nil {
+				resolver.resolveOnce(analyzer, rule.ReplaceRule, ruleName, bindings)
+			}
+			if rule.CollectRule != nil {
+				resolver.resolveOnce(analyzer, rule.CollectRule, ruleName, bindings[len(rule.ArgList):])
+			}
+		}
+	}
+
+	for _, read := range resolver.reads {
+		if known[read.name] || resolver.assigned[read.name] || strings.HasPrefix(read.name, "__kuuhaku_") {
+			continue
+		}
+		position := getLuaNamePosition(read.literal, read.line, read.name)
+		analyzer.Warnings = append(analyzer.Warnings, WarnUnknownLuaName(position, read.name, read.ruleName))
+	}
+}
+
+// the alternatives desugared from one written alternative share their lua literals, the first one
+// has every optional match rule present
+func (resolver *luaResolver) resolveOnce(analyzer *Analyzer, literal *kuuhaku_parser.LuaLiteral, ruleName string, bindings []string) {
+	if analyzer.isChecked(literal.Position, "names:"+literal.LuaString) {
+		return
+	}
+	resolver.resolve(literal, ruleName, bindings)
+}
+
+// returns the names declared at the top level of the literal. Literals that don't parse are already
+// reported by analyzeLuaLiteral
+func (resolver *luaResolver) resolve(literal *kuuhaku_parser.LuaLiteral, ruleName string, bindings []string) map[string]bool {
+	chunk, err := parse.Parse(strings.NewReader(literal.LuaString), "")
+	if err != nil {
+		return nil
+	}
+	resolver.literal = literal
+	resolver.ruleName = ruleName
+	top := make(map[string]bool)
+	for _, binding := range bindings {
+		top[binding] = true
+	}
+	resolver.scopes = []map[string]bool{top}
+	resolver.walkStmts(chunk)
+	return top
+}
+
+func (resolver *luaResolver) push(names ...string) {
+	scope := make(map[string]bool)
+	for _, name := range names {
+		scope[name] = true
+	}
+	resolver.scopes = append(resolver.scopes, scope)
+}
+
+func (resolver *luaResolver) pop() {
+	resolver.scopes = resolver.scopes[:len(resolver.scopes)-1]
+}
+
+func (resolver *luaResolver) declare(names ...string) {
+	for _, name := range names {
+		resolver.scopes[len(resolver.scopes)-1][name] = true
+	}
+}
+
+func (resolver *luaResolver) isDeclared(name string) bool {
+	for _, scope := range resolver.scopes {
+		if scope[name] {
+			return true
+		}
+	}
+	return false
+}
+
+func (resolver *luaResolver) walkBlock(stmts []ast.Stmt, names ...string) {
+	resolver.push(names...)
+	resolver.walkStmts(stmts)
+	resolver.pop()
+}
+
+func (resolver *luaResolver) walkStmts(stmts []ast.Stmt) {
+	for _, stmt := range stmts {
+		resolver.walkStmt(stmt)
+	}
+}
+
+func (resolver *luaResolver) walkStmt(stmt ast.Stmt) {
+	switch s := stmt.(type) {
+	case *ast.AssignStmt:
+		resolver.walkExprs(s.Rhs)
+		for _, lhs := range s.Lhs {
+			resolver.walkAssigned(lhs)
+		}
+	case *ast.LocalAssignStmt:
+		//local function f is parsed as local f = function, declaring f first lets it call itself
+		if len(s.Exprs) == 1 && len(s.Names) == 1 {
+			if _, ok := s.Exprs[0].(*ast.FunctionExpr); ok {
+				resolver.declare(s.Names...)
+			}
+		}
+		resolver.walkExprs(s.Exprs)
+		resolver.declare(s.Names...)
+	case *ast.FuncCallStmt:
+		resolver.walkExpr(s.Expr)
+	case *ast.DoBlockStmt:
+		resolver.walkBlock(s.Stmts)
+	case *ast.WhileStmt:
+		resolver.walkExpr(s.Condition)
+		resolver.walkBlock(s.Stmts)
+	case *ast.RepeatStmt:
+		//the condition of repeat until sees the locals of the block
+		resolver.push()
+		resolver.walkStmts(s.Stmts)
+		resolver.walkExpr(s.Condition)
+		resolver.pop()
+	case *ast.IfStmt:
+		resolver.walkExpr(s.Condition)
+		resolver.walkBlock(s.Then)
+		resolver.walkBlock(s.Else)
+	case *ast.NumberForStmt:
+		resolver.walkExprs([]ast.Expr{s.Init, s.Limit, s.Step})
+		resolver.walkBlock(s.Stmts, s.Name)
+	case *ast.GenericForStmt:
+		resolver.walkExprs(s.Exprs)
+		resolver.walkBlock(s.Stmts, s.Names...)
+	case *ast.FuncDefStmt:
+		if s.Name.Func != nil {
+			resolver.walkAssigned(s.Name.Func)
+			resolver.walkFunction(s.Func)
+		} else {
+			resolver.walkExpr(s.Name.Receiver)
+			resolver.push("self")
+			resolver.walkFunction(s.Func)
+			resolver.pop()
+		}
+	case *ast.ReturnStmt:
+		resolver.walkExprs(s.Exprs)
+	}
+}
+
+// an assigned name that isn't a local is a global, assigning it isn't a read
+func (resolver *luaResolver) walkAssigned(expr ast.Expr) {
+	ident, ok := expr.(*ast.IdentExpr)
+	if !ok {
+		resolver.walkExpr(expr)
+		return
+	}
+	if !resolver.isDeclared(ident.Value) {
+		resolver.assigned[ident.Value] = true
+	}
+}
+
+func (resolver *luaResolver) walkFunction(function *ast.FunctionExpr) {
+	var params []string
+	if function.ParList != nil {
+		params = function.ParList.Names
+	}
+	resolver.walkBlock(function.Stmts, params...)
+}
+
+func (resolver *luaResolver) walkExprs(exprs []ast.Expr) {
+	for _, expr := range exprs {
+		resolver.walkExpr(expr)
+	}
+}
+
+func (resolver *luaResolver) walkExpr(expr ast.Expr) {
+	switch e := expr.(type) {
+	case *ast.IdentExpr:
+		if !resolver.isDeclared(e.Value) {
+			resolver.reads = append(resolver.reads, luaGlobalRead{
+				name:     e.Value,
+				line:     e.Line(),
+				literal:  resolver.literal,
+				ruleName: resolver.ruleName,
+			})
+		}
+	case *ast.AttrGetExpr:
+		resolver.walkExprs([]ast.Expr{e.Object, e.Key})
+	case *ast.TableExpr:
+		for _, field := range e.Fields {
+			resolver.walkExprs([]ast.Expr{field.Key, field.Value})
+		}
+	case *ast.FuncCallExpr:
+		resolver.walkExprs([]ast.Expr{e.Func, e.Receiver})
+		resolver.walkExprs(e.Args)
+	case *ast.LogicalOpExpr:
+		resolver.walkExprs([]ast.Expr{e.Lhs, e.Rhs})
+	case *ast.RelationalOpExpr:
+		resolver.walkExprs([]ast.Expr{e.Lhs, e.Rhs})
+	case *ast.StringConcatOpExpr:
+		resolver.walkExprs([]ast.Expr{e.Lhs, e.Rhs})
+	case *ast.ArithmeticOpExpr:
+		resolver.walkExprs([]ast.Expr{e.Lhs, e.Rhs})
+	case *ast.UnaryMinusOpExpr:
+		resolver.walkExpr(e.Expr)
+	case *ast.UnaryNotOpExpr:
+		resolver.walkExpr(e.Expr)
+	case *ast.UnaryLenOpExpr:
+		resolver.walkExpr(e.Expr)
+	case *ast.FunctionExpr:
+		resolver.walkFunction(e)
+	}
+}
+
+func getLuaStdlibNames() map[string]bool {
+	L := lua.NewState()
+	defer L.Close()
+	names := make(map[string]bool)
+	L.G.Global.ForEach(func(key lua.LValue, _ lua.LValue) {
+		names[key.String()] = true
+	})
+	return names
+}
+
+// maps a line of the lua source to the position of the name in the grammar file. The source of a
+// multi statement literal starts after “, the one of a return literal after ` and the return that
+// the parser prepends
+func getLuaNamePosition(literal *kuuhaku_parser.LuaLiteral, line int, name string) kuuhaku_tokenizer.Position {
+	lines := strings.Split(literal.LuaString, "\n")
+	if line < 1 || line > len(lines) {
+		return literal.Position
+	}
+	column := 0
+	loc := regexp.MustCompile(`(^|[^A-Za-z0-9_])` + regexp.QuoteMeta(name)).FindStringIndex(lines[line-1])
+	if loc != nil {
+		column = loc[1] - len(name)
+	}
+
+	//the offset of the name in the lua string, the lines before it end with a newline
+	offset := column
+	for _, before := range lines[:line-1] {
+		offset += len(before) + 1
+	}
+
+	position := literal.Position
+	position.Line += line - 1
+	if line != 1 {
+		position.Column = column + 1
+	} else if literal.Type == kuuhaku_parser.LUA_LITERAL_TYPE_RETURN {
+		position.Column += 1 + column - len("return ")
+	} else {
+		position.Column += 2 + column
+	}
+	if literal.Type == kuuhaku_parser.LUA_LITERAL_TYPE_RETURN {
+		position.Raw += 1 + offset - len("return ")
+	} else {
+		position.Raw += 2 + offset
+	}
+	return position
+}
@@ -0,0 +1,92 @@
+package kuuhaku_analyzer
+
+import (
+	"strconv"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+)
+
+func WarnShadowedTerminal(position kuuhaku_tokenizer.Position, shadowed string, first string, example string) *AnalyzeWarning {
+	return &AnalyzeWarning{
+		Message:  "<" + shadowed + "> is shadowed by <" + first + ">, which is tried first and matches at least as much of " + strconv.Quote(example) + ". Declare <" + shadowed + "> earlier if it should win",
+		Position: position,
+		Type:     SHADOWED_TERMINAL,
+		Severity: SEVERITY_WARNING,
+	}
+}
+
+func WarnEmptyMatch(position kuuhaku_tokenizer.Position, terminal string) *AnalyzeWarning {
+	return &AnalyzeWarning{
+		Message:  "<" + terminal + "> can match the empty string, which stops the input from advancing",
+		Position: position,
+		Type:     EMPTY_MATCH,
+		Severity: SEVERITY_WARNING,
+	}
+}
+
+// checkOverlappingTerminals warns about terminals that can match the empty string and about terminals
+// that never win against an earlier terminal valid in the same state. Each terminal and pair is only
+// reported once across the parse tables
+func (analyzer *Analyzer) checkOverlappingTerminals() {
+	isEmptyChecked := make(map[string]bool)
+	isPairChecked := make(map[[2]string]bool)
+	for i := range analyzer.parseTables {
+		parseTable := &analyzer.parseTables[i]
+		for _, terminal := range parseTable.Terminals {
+			if terminal.Regexp == nil || isEmptyChecked[terminal.Terminal] {
+				continue
+			}
+			isEmptyChecked[terminal.Terminal] = true
+			if terminal.Regexp.MatchString("") {
+				analyzer.Warnings = append(analyzer.Warnings, WarnEmptyMatch(analyzer.getTerminalPosition(terminal), terminal.Terminal))
+			}
+		}
+		for _, state := range parseTable.States {
+			candidates := getCandidateTerminals(parseTable, state)
+			for j, first := range candidates {
+				for _, later := range candidates[j+1:] {
+					pair := [2]string{first.Terminal, later.Terminal}
+					if isPairChecked[pair] {
+						continue
+					}
+					isPairChecked[pair] = true
+					example, ok := findShadowedMatch(first, later)
+					if ok {
+						position := analyzer.getTerminalPosition(later)
+						analyzer.Warnings = append(analyzer.Warnings, WarnShadowedTerminal(position, later.Terminal, first.Terminal, example))
+					}
+				}
+			}
+		}
+	}
+}
+
+// the terminals that can be matched in the state, in the order the runtime tries them
+func getCandidateTerminals(parseTable *ParseTable, state ParseTableState) []TerminalList {
+	var candidates []TerminalList
+	for _, terminal := range parseTable.Terminals {
+		if state.ActionTable[terminal.Terminal] != nil && terminal.Regexp != nil {
+			candidates = append(candidates, terminal)
+		}
+	}
+	return candidates
+}
+
+// returns an example of later where first matches a non-empty prefix at least as long as later does.
+// The earlier terminal wins such an input with both first and longest match, empty matches are
+// reported by WarnEmptyMatch instead
+func findShadowedMatch(first TerminalList, later TerminalList) (string, bool) {
+	example, ok := getRegexExample(later.Terminal)
+	if !ok {
+		return "", false
+	}
+	firstLoc := first.Regexp.FindStringIndex(example)
+	laterLoc := later.Regexp.FindStringIndex(example)
+	if firstLoc == nil || laterLoc == nil || firstLoc[1] == 0 {
+		return "", false
+	}
+	if firstLoc[1] < laterLoc[1] {
+		return "", false
+	}
+	return example[:laterLoc[1]], true
+}
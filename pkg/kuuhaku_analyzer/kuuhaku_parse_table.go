@@ -37,6 +37,11 @@ type SymbolTitle struct {
 type AnalyzerResult struct {
 	ParseTables  []ParseTable
 	IsSearchMode bool
+	IsGLRMode    bool
+	IsNodeMode   bool
+	Preferences  []string         //see kuuhaku_parser.Ast.Preferences
+	Conflicts    []*ConflictError //conflicts that were turned into multi-action cells in GLR mode
+	Warnings     []error
 	GlobalLua 	 *kuuhaku_parser.LuaLiteral
 }
 
@@ -44,12 +49,21 @@ type ParseTable struct {
 	States    []ParseTableState
 	Terminals []TerminalList
 	Lhss      []string
+	Trivia    []TerminalList //terminals skipped between tokens, in the order they are declared
+	Modes     map[string]*LexerMode
+	Pushes    map[string]string //terminal - the lexer mode that is pushed when the terminal is shifted
+}
+
+type LexerMode struct {
+	Terminals map[string]bool //the eligible terminals while the mode is on top, nil if every terminal is
+	Pops      map[string]bool //the terminals that pop the mode when they're shifted while it's on top
 }
 
 type TerminalList struct {
-	Terminal   string 
-	Precedence int
-	Regexp     regexp.Regexp
+	Terminal       string 
+	Precedence     int
+	Regexp         regexp.Regexp
+	IsLongestMatch bool //a longer match of a later terminal wins over this one if it's the first match
 }
 
 type ParseTableState struct {
@@ -74,6 +88,10 @@ type ActionCell struct {
 	Action            Action
 	ReduceRule        *kuuhaku_parser.Rule
 	ShiftState        int
+
+	//Only filled in GLR mode, the actions that conflict with this one. The runtime forks the parse
+	//stack for each of them
+	ConflictingActions []*ActionCell
 }
 
 type GotoCell struct {
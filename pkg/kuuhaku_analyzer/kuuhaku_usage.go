@@ -0,0 +1,198 @@
+package kuuhaku_analyzer
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_parser"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+)
+
+func WarnUnusedRule(position kuuhaku_tokenizer.Position, name string) *AnalyzeWarning {
+	return &AnalyzeWarning{
+		Message:  "Rule " + name + " is imported but never used",
+		Position: position,
+		Type:     UNUSED_RULE,
+		Severity: SEVERITY_WARNING,
+	}
+}
+
+func WarnUnreachableRule(position kuuhaku_tokenizer.Position, name string) *AnalyzeWarning {
+	return &AnalyzeWarning{
+		Message:  "Rule " + name + " is not reachable from any start symbol",
+		Position: position,
+		Type:     UNREACHABLE_RULE,
+		Severity: SEVERITY_WARNING,
+	}
+}
+
+func WarnDuplicateAlternative(position kuuhaku_tokenizer.Position, name string, original kuuhaku_tokenizer.Position) *AnalyzeWarning {
+	return &AnalyzeWarning{
+		Message:  "This alternative of " + name + " is identical to the one at (" + original.Location() + ")",
+		Position: position,
+		Type:     DUPLICATE_ALTERNATIVE,
+		Severity: SEVERITY_WARNING,
+	}
+}
+
+func WarnUnusedParam(position kuuhaku_tokenizer.Position, param string, name string) *AnalyzeWarning {
+	return &AnalyzeWarning{
+		Message:  "Param " + param + " of " + name + " is never used",
+		Position: position,
+		Type:     UNUSED_PARAM,
+		Severity: SEVERITY_NOTE,
+	}
+}
+
+// the rule names in a stable order so the warnings are reported in the same order on every run
+func (analyzer *Analyzer) getSortedRuleNames(augmented map[string]bool) []string {
+	var names []string
+	for name := range analyzer.input.Rules {
+		if !augmented[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkRuleUsage warns about imported rules that no rule refers to and about rules that can't be
+// reached from a start symbol, like two rules that only refer to each other. Non-imported rules that
+// no rule refers to are start symbols themselves
+func (analyzer *Analyzer) checkRuleUsage(startSymbols []string) {
+	augmented := make(map[string]bool)
+	reachable := make(map[string]bool)
+	var stack []string
+	for _, startSymbol := range startSymbols {
+		augmented[startSymbol] = true
+		stack = append(stack, strings.TrimPrefix(startSymbol, "S"))
+	}
+	for len(stack) != 0 {
+		name := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if reachable[name] {
+			continue
+		}
+		reachable[name] = true
+		for _, rule := range analyzer.input.Rules[name] {
+			for _, matchRule := range rule.MatchRules {
+				identifier, ok := matchRule.(kuuhaku_parser.Identifier)
+				if ok && !reachable[identifier.Name] {
+					stack = append(stack, identifier.Name)
+				}
+			}
+		}
+	}
+
+	referenced := make(map[string]bool)
+	for name, rules := range analyzer.input.Rules {
+		for _, rule := range rules {
+			for _, matchRule := range rule.MatchRules {
+				identifier, ok := matchRule.(kuuhaku_parser.Identifier)
+				if ok && identifier.Name != name {
+					referenced[identifier.Name] = true
+				}
+			}
+		}
+	}
+
+	for _, name := range analyzer.getSortedRuleNames(augmented) {
+		rule := analyzer.input.Rules[name][0]
+		if reachable[name] || rule.Hidden != kuuhaku_parser.NOT_HIDDEN {
+			continue
+		}
+		if !referenced[name] {
+			analyzer.Warnings = append(analyzer.Warnings, WarnUnusedRule(rule.Position, name))
+		} else {
+			analyzer.Warnings = append(analyzer.Warnings, WarnUnreachableRule(rule.Position, name))
+		}
+	}
+}
+
+// checkDuplicateAlternatives warns about alternatives written twice with the same match rules, params
+// and replace rule. The alternatives that desugaring makes out of one written alternative are
+// compared as one
+func (analyzer *Analyzer) checkDuplicateAlternatives() {
+	for _, name := range analyzer.getSortedRuleNames(nil) {
+		seen := make(map[string]kuuhaku_tokenizer.Position)
+		isWritten := make(map[kuuhaku_tokenizer.Position]bool)
+		for _, rule := range analyzer.input.Rules[name] {
+			if rule.Hidden != kuuhaku_parser.NOT_HIDDEN || isWritten[rule.Position] {
+				continue
+			}
+			isWritten[rule.Position] = true
+			key := rule.Pattern + "\x00" + strconv.Itoa(len(rule.ArgList))
+			if rule.ReplaceRule != nil {
+				key += "\x00" + rule.ReplaceRule.LuaString
+			}
+			if rule.CollectRule != nil {
+				key += "\x00" + rule.CollectRule.LuaString
+			}
+			original, ok := seen[key]
+			if ok {
+				analyzer.Warnings = append(analyzer.Warnings, WarnDuplicateAlternative(rule.Position, name, original))
+				continue
+			}
+			seen[key] = rule.Position
+		}
+	}
+}
+
+// checkUnusedParams warns about params that appear in neither the replace rule nor the arguments of
+// the alternative. The hidden rules of a rule get its params too so their lua is searched as well,
+// the arguments that only forward the params to a hidden rule don't count. Any identifier with the
+// name of the param counts as a use
+func (analyzer *Analyzer) checkUnusedParams() {
+	for _, name := range analyzer.getSortedRuleNames(nil) {
+		var hiddenLua []string
+		for hiddenName, rules := range analyzer.input.Rules {
+			if !strings.HasPrefix(hiddenName, name+"#") {
+				continue
+			}
+			for _, rule := range rules {
+				hiddenLua = append(hiddenLua, getRuleLua(rule)...)
+			}
+		}
+		for _, rule := range analyzer.input.Rules[name] {
+			if rule.Hidden != kuuhaku_parser.NOT_HIDDEN {
+				continue
+			}
+			lua := append(getRuleLua(rule), hiddenLua...)
+			for _, param := range rule.ArgList {
+				if analyzer.isChecked(param.Position, "unused:"+param.Name) || isIdentifierUsed(param.Name, lua) {
+					continue
+				}
+				analyzer.Warnings = append(analyzer.Warnings, WarnUnusedParam(param.Position, param.Name, name))
+			}
+		}
+	}
+}
+
+func getRuleLua(rule *kuuhaku_parser.Rule) []string {
+	var out []string
+	if rule.ReplaceRule != nil {
+		out = append(out, rule.ReplaceRule.LuaString)
+	}
+	for _, matchRule := range rule.MatchRules {
+		identifier, ok := matchRule.(kuuhaku_parser.Identifier)
+		if !ok || strings.Contains(identifier.Name, "#") {
+			continue
+		}
+		for _, arg := range identifier.ArgList {
+			out = append(out, arg.LuaString)
+		}
+	}
+	return out
+}
+
+func isIdentifierUsed(name string, sources []string) bool {
+	identifierRegex := regexp.MustCompile(`(^|[^A-Za-z0-9_])` + regexp.QuoteMeta(name) + `($|[^A-Za-z0-9_])`)
+	for _, source := range sources {
+		if identifierRegex.MatchString(source) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package kuuhaku_diagnostic
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_analyzer"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_parser"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_runtime"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+)
+
+type Severity int
+
+const (
+	SEVERITY_ERROR Severity = iota
+	SEVERITY_WARNING
+	SEVERITY_NOTE
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SEVERITY_WARNING:
+		return "warning"
+	case SEVERITY_NOTE:
+		return "note"
+	}
+	return "error"
+}
+
+// a span with an End at or before its Start covers a single character
+type Span struct {
+	Start kuuhaku_tokenizer.Position
+	End   kuuhaku_tokenizer.Position
+	Label string
+}
+
+// the parts of an error that the renderer shows. Diagnostics without a primary span only have a
+// message, like the errors of reading a file
+type Diagnostic struct {
+	Severity  Severity
+	Code      string //the stable code of a warning, empty for errors
+	Message   string
+	Notes     []string //the lines of the message after the first one
+	Primary   *Span
+	Secondary []Span
+}
+
+func makeDiagnostic(severity Severity, message string, position *kuuhaku_tokenizer.Position) Diagnostic {
+	lines := strings.Split(message, "\n")
+	diagnostic := Diagnostic{
+		Severity: severity,
+		Message:  lines[0],
+	}
+	for _, line := range lines[1:] {
+		line = strings.TrimPrefix(strings.TrimSpace(line), "--- ")
+		if line != "" {
+			diagnostic.Notes = append(diagnostic.Notes, line)
+		}
+	}
+	if position != nil {
+		diagnostic.Primary = &Span{Start: *position, End: *position}
+	}
+	return diagnostic
+}
+
+// FromError turns the errors and warnings of every kuuhaku package into a diagnostic, other errors
+// only keep their message
+func FromError(err error) Diagnostic {
+	var tokenizeError *kuuhaku_tokenizer.TokenizeError
+	var parseError *kuuhaku_parser.ParseError
+	var analyzeError *kuuhaku_analyzer.AnalyzeError
+	var conflictError *kuuhaku_analyzer.ConflictError
+	var analyzeWarning *kuuhaku_analyzer.AnalyzeWarning
+	var syntaxError *kuuhaku_runtime.RuntimeSyntaxError
+	var runtimeError *kuuhaku_runtime.RuntimeError
+	var luaError *kuuhaku_runtime.LuaError
+	var evalError *kuuhaku_runtime.EvalError
+
+	switch {
+	case errors.As(err, &tokenizeError):
+		return makeDiagnostic(SEVERITY_ERROR, tokenizeError.Message, &tokenizeError.Position)
+	case errors.As(err, &parseError):
+		return makeDiagnostic(SEVERITY_ERROR, parseError.Message, &parseError.Position)
+	case errors.As(err, &analyzeError):
+		return makeDiagnostic(SEVERITY_ERROR, analyzeError.Message, &analyzeError.Position)
+	case errors.As(err, &conflictError):
+		diagnostic := makeDiagnostic(SEVERITY_ERROR, conflictError.Message, &conflictError.Position1)
+		diagnostic.Primary.Label = "this rule"
+		if conflictError.Position2 != conflictError.Position1 {
+			diagnostic.Secondary = append(diagnostic.Secondary, Span{
+				Start: conflictError.Position2,
+				End:   conflictError.Position2,
+				Label: "conflicts with this rule",
+			})
+		}
+		return diagnostic
+	case errors.As(err, &analyzeWarning):
+		severity := SEVERITY_WARNING
+		if analyzeWarning.Severity == kuuhaku_analyzer.SEVERITY_NOTE {
+			severity = SEVERITY_NOTE
+		}
+		diagnostic := makeDiagnostic(severity, analyzeWarning.Message, &analyzeWarning.Position)
+		diagnostic.Code = analyzeWarning.Code()
+		return diagnostic
+	case errors.As(err, &syntaxError):
+		return makeDiagnostic(SEVERITY_ERROR, syntaxError.Message, &syntaxError.Position)
+	case errors.As(err, &runtimeError):
+		return makeDiagnostic(SEVERITY_ERROR, runtimeError.Message, &runtimeError.Position)
+	case errors.As(err, &luaError):
+		diagnostic := makeDiagnostic(SEVERITY_ERROR, luaError.Message, &luaError.Position)
+		if luaError.RuleName == "" {
+			diagnostic.Primary.Label = "in the global lua"
+			return diagnostic
+		}
+		diagnostic.Primary.Label = "in rule " + luaError.RuleName + " (alternative " + strconv.Itoa(luaError.Alternative) + ")"
+		if luaError.Start.Line != 0 {
+			diagnostic.Secondary = append(diagnostic.Secondary, Span{
+				Start: luaError.Start,
+				End:   luaError.End,
+				Label: "while evaluating this input",
+			})
+		}
+		return diagnostic
+	case errors.As(err, &evalError):
+		return makeDiagnostic(SEVERITY_ERROR, evalError.Message, nil)
+	}
+	return makeDiagnostic(SEVERITY_ERROR, err.Error(), nil)
+}
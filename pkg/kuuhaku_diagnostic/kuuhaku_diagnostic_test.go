@@ -0,0 +1,213 @@
+package kuuhaku_diagnostic
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ciii1/kuuhaku/internal/helper"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_analyzer"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_parser"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_runtime"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+)
+
+func expectRender(t *testing.T, got string, expected string) {
+	if got != expected {
+		println("Expected the rendered diagnostics to be:\n" + expected + "got:\n" + got)
+		t.Fail()
+	}
+}
+
+func TestRenderConflict(t *testing.T) {
+	grammar := "S{<a> B}\nB{<b>}\nB{<b>}"
+	ast, errs := kuuhaku_parser.Parse(grammar)
+	if len(errs) != 0 {
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	_, errs = kuuhaku_analyzer.Analyze(&ast, false)
+	if len(errs) != 1 {
+		println("Expected 1 conflict")
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	expectRender(t, InitRenderer("", grammar, false).RenderAll(errs), ""+
+		"error: Detected conflict at rule 3 and rule 2 with position (2, 3)\n"+
+		" --> 3:3\n"+
+		"  |\n"+
+		"3 | B{<b>}\n"+
+		"  |   ^ this rule\n"+
+		" ::: 2:3\n"+
+		"  |\n"+
+		"2 | B{<b>}\n"+
+		"  |   - conflicts with this rule\n"+
+		"  = Lookaheads are: <end> and <end>\n"+
+		"  = Counterexample on <end>:\n"+
+		"  = Example: <a> <b> • <end>\n"+
+		"  = Reduce derivation: B -> <b> • [<end>]\n"+
+		"  = Reduce derivation: B -> <b> • [<end>]\n")
+}
+
+func TestRenderParseErrorWithTabs(t *testing.T) {
+	grammar := "S{<a> B}\n\tB{<b> <c>\n"
+	_, errs := kuuhaku_parser.Parse(grammar)
+	if len(errs) == 0 {
+		println("Expected a parse error")
+		t.Fatal()
+	}
+	expectRender(t, InitRenderer("grammar.khk", grammar, false).Render(FromError(errs[0])), ""+
+		"error: Expected equal sign\n"+
+		" --> grammar.khk:2:8\n"+
+		"  |\n"+
+		"2 |     B{<b> <c>\n"+
+		"  |           ^\n")
+
+	expectRender(t, InitRenderer("", grammar, false).Render(Diagnostic{
+		Message: "A message",
+		Primary: &Span{Start: kuuhaku_tokenizer.Position{Line: 2, Column: 2}, End: kuuhaku_tokenizer.Position{Line: 2, Column: 5}, Label: "label"},
+	}), ""+
+		"error: A message\n"+
+		" --> 2:2\n"+
+		"  |\n"+
+		"2 |     B{<b> <c>\n"+
+		"  |     ^^^ label\n")
+}
+
+func TestRenderLuaErrorAndWarnings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "grammar.khk")
+	grammar := "S{A B}\nA{<a> = `\"a\"`}\nB{<b>+ = ``\nlocal x = nil\nreturn x .. LITERAL1``}\nB(x){<c>}"
+	err := os.WriteFile(path, []byte(grammar), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ast, errs := kuuhaku_parser.ParseFile(path, nil)
+	if len(errs) != 0 {
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	res, errs := kuuhaku_analyzer.Analyze(&ast, false)
+	if len(errs) != 0 {
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	renderer := InitRenderer("input.txt", "abbb", false)
+	expectRender(t, renderer.RenderAll(res.Warnings), ""+
+		"note[W007]: Param x of B is never used\n"+
+		" --> "+path+":6:3\n"+
+		"  |\n"+
+		"6 | B(x){<c>}\n"+
+		"  |   ^\n")
+
+	_, err = kuuhaku_runtime.Format("abbb", &res, true, false)
+	if err == nil {
+		println("Expected a lua error")
+		t.Fatal()
+	}
+	expectRender(t, renderer.RenderAll([]error{err}), ""+
+		"error: cannot perform concat operation between nil and table\n"+
+		" --> "+path+":5:1\n"+
+		"  |\n"+
+		"5 | return x .. LITERAL1``}\n"+
+		"  | ^ in rule B (alternative 1)\n"+
+		" ::: input.txt:1:2\n"+
+		"  |\n"+
+		"1 | abbb\n"+
+		"  |  --- while evaluating this input\n")
+
+	colored := InitRenderer("input.txt", "abbb", true).RenderAll([]error{err})
+	if !strings.Contains(colored, colorRed+"error"+colorReset) || !strings.Contains(colored, colorBlue+"--- while evaluating this input"+colorReset) {
+		println("Expected the colored output to use the severity and secondary colors, got:\n" + colored)
+		t.Fail()
+	}
+}
+
+func TestReport(t *testing.T) {
+	grammar := "S{<a> B}\nB{<b>}\nB{<b>}"
+	ast, errs := kuuhaku_parser.Parse(grammar)
+	if len(errs) != 0 {
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	_, errs = kuuhaku_analyzer.Analyze(&ast, false)
+	if len(errs) != 1 {
+		println("Expected 1 conflict")
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+
+	var report Report
+	report.Add(errs, "grammar.khk")
+	report.Add(errs, "grammar.khk")
+	report.AddFile("input.txt", FILE_FAILED)
+	if len(report.Diagnostics) != 1 {
+		println("Expected the same conflict to be recorded once")
+		t.Fatal()
+	}
+	record := report.Diagnostics[0]
+	if record.Severity != "error" || record.Span == nil || record.Span.File != "grammar.khk" || len(record.Secondary) != 1 || len(record.Notes) == 0 {
+		println("Expected an error with a primary span, a secondary span and notes")
+		t.Fatal()
+	}
+	if record.Span.Start != (RecordPosition{Line: 3, Column: 3, Offset: 18}) || record.Span.End != (RecordPosition{Line: 3, Column: 4, Offset: 19}) {
+		println("Expected the span to cover the character at 3:3")
+		t.Fail()
+	}
+
+	res, err := report.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	err = json.Unmarshal(res, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Diagnostics) != 1 || decoded.Diagnostics[0].Message != record.Message || len(decoded.Files) != 1 || decoded.Files[0].Status != FILE_FAILED {
+		println("Expected the JSON to hold the diagnostic and the file result, got:\n" + string(res))
+		t.Fail()
+	}
+
+	report.AddDiagnostic(Diagnostic{Severity: SEVERITY_WARNING, Code: "W004", Message: "A warning"}, "grammar.khk")
+	res, err = report.ToSARIF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sarif struct {
+		Version string
+		Runs    []struct {
+			Results []struct {
+				RuleId           string
+				Level            string
+				Locations        []any
+				RelatedLocations []any
+			}
+			Artifacts []struct {
+				Properties map[string]string
+			}
+		}
+	}
+	err = json.Unmarshal(res, &sarif)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sarif.Version != "2.1.0" || len(sarif.Runs) != 1 || len(sarif.Runs[0].Results) != 2 || len(sarif.Runs[0].Artifacts) != 1 {
+		println("Expected a SARIF run with 2 results and 1 artifact, got:\n" + string(res))
+		t.Fatal()
+	}
+	results := sarif.Runs[0].Results
+	if results[0].Level != "error" || len(results[0].Locations) != 1 || len(results[0].RelatedLocations) != 1 {
+		println("Expected the conflict to have a location and a related location")
+		t.Fail()
+	}
+	if results[1].Level != "warning" || results[1].RuleId != "W004" || len(results[1].Locations) != 0 {
+		println("Expected the warning to have the rule id W004 and no location")
+		t.Fail()
+	}
+	if sarif.Runs[0].Artifacts[0].Properties["status"] != "failed" {
+		println("Expected the status of the file to be failed")
+		t.Fail()
+	}
+}
@@ -0,0 +1,181 @@
+package kuuhaku_diagnostic
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+)
+
+const (
+	colorReset  = "\033[0m"
+	colorBold   = "\033[1m"
+	colorRed    = "\033[1;31m"
+	colorYellow = "\033[1;33m"
+	colorCyan   = "\033[1;36m"
+	colorBlue   = "\033[1;34m"
+)
+
+const tabWidth = 4
+
+// renders diagnostics with the source lines they point at. Positions with a file are read from that
+// file, the positions without one are in Input, which is the text being formatted or a grammar that
+// didn't come from a file
+type Renderer struct {
+	InputName string
+	Input     string
+	IsColored bool
+	sources   map[string][]string
+}
+
+func InitRenderer(inputName string, input string, isColored bool) *Renderer {
+	return &Renderer{
+		InputName: inputName,
+		Input:     input,
+		IsColored: isColored,
+		sources:   make(map[string][]string),
+	}
+}
+
+// true if the file is a terminal that can show colors. Setting NO_COLOR turns colors off
+func IsColorTerminal(file *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func (renderer *Renderer) RenderAll(errs []error) string {
+	out := ""
+	for _, err := range errs {
+		out += renderer.Render(FromError(err))
+	}
+	return out
+}
+
+// a header with the severity and the message, then the location and the source line of each span
+// with the span underlined and labeled, then the notes
+func (renderer *Renderer) Render(diagnostic Diagnostic) string {
+	severityColor := colorRed
+	switch diagnostic.Severity {
+	case SEVERITY_WARNING:
+		severityColor = colorYellow
+	case SEVERITY_NOTE:
+		severityColor = colorCyan
+	}
+
+	header := diagnostic.Severity.String()
+	if diagnostic.Code != "" {
+		header += "[" + diagnostic.Code + "]"
+	}
+	out := renderer.color(severityColor, header) + renderer.color(colorBold, ": "+diagnostic.Message) + "\n"
+	if diagnostic.Primary == nil {
+		for _, note := range diagnostic.Notes {
+			out += "  = " + note + "\n"
+		}
+		return out
+	}
+
+	spans := append([]Span{*diagnostic.Primary}, diagnostic.Secondary...)
+	gutterWidth := 0
+	for _, span := range spans {
+		gutterWidth = max(gutterWidth, len(strconv.Itoa(span.Start.Line)))
+	}
+	gutter := strings.Repeat(" ", gutterWidth)
+
+	for i, span := range spans {
+		arrow := "-->"
+		underlineColor := severityColor
+		underline := "^"
+		if i != 0 {
+			arrow = ":::"
+			underlineColor = colorBlue
+			underline = "-"
+		}
+		out += gutter + renderer.color(colorBlue, arrow) + " " + renderer.getLocation(span.Start) + "\n"
+		line, ok := renderer.getSourceLine(span.Start)
+		if !ok {
+			continue
+		}
+		lineNumber := strconv.Itoa(span.Start.Line)
+		out += gutter + " " + renderer.color(colorBlue, "|") + "\n"
+		out += renderer.color(colorBlue, strings.Repeat(" ", gutterWidth-len(lineNumber))+lineNumber+" |") + " " + expandTabs(line) + "\n"
+		offset, width := getUnderline(line, span)
+		marker := strings.Repeat(underline, width)
+		if span.Label != "" {
+			marker += " " + span.Label
+		}
+		out += gutter + " " + renderer.color(colorBlue, "|") + " " + strings.Repeat(" ", offset) + renderer.color(underlineColor, marker) + "\n"
+	}
+	for _, note := range diagnostic.Notes {
+		out += gutter + " " + renderer.color(colorBlue, "=") + " " + note + "\n"
+	}
+	return out
+}
+
+func (renderer *Renderer) color(color string, text string) string {
+	if !renderer.IsColored {
+		return text
+	}
+	return color + text + colorReset
+}
+
+func (renderer *Renderer) getLocation(position kuuhaku_tokenizer.Position) string {
+	file := position.File
+	if file == "" {
+		file = renderer.InputName
+	}
+	location := strconv.Itoa(position.Line) + ":" + strconv.Itoa(position.Column)
+	if file == "" {
+		return location
+	}
+	return file + ":" + location
+}
+
+func (renderer *Renderer) getSourceLine(position kuuhaku_tokenizer.Position) (string, bool) {
+	lines, ok := renderer.sources[position.File]
+	if !ok {
+		source := renderer.Input
+		if position.File != "" {
+			content, err := os.ReadFile(position.File)
+			if err != nil {
+				renderer.sources[position.File] = nil
+				return "", false
+			}
+			source = string(content)
+		}
+		lines = strings.Split(source, "\n")
+		renderer.sources[position.File] = lines
+	}
+	if position.Line < 1 || position.Line > len(lines) {
+		return "", false
+	}
+	return strings.TrimSuffix(lines[position.Line-1], "\r"), true
+}
+
+// the offset and width of the underline in the line with its tabs expanded. A span that ends on a
+// later line is underlined up to the end of its first line
+func getUnderline(line string, span Span) (int, int) {
+	start := min(max(span.Start.Column-1, 0), len(line))
+	end := start + 1
+	if span.End.Line > span.Start.Line {
+		end = max(len(line), end)
+	} else if span.End.Line == span.Start.Line && span.End.Column > span.Start.Column {
+		end = span.End.Column - 1
+	}
+	end = min(end, max(len(line), start+1))
+	offset := len(expandTabs(line[:start]))
+	if end > len(line) {
+		return offset, 1
+	}
+	return offset, max(len(expandTabs(line[:end]))-offset, 1)
+}
+
+func expandTabs(line string) string {
+	return strings.ReplaceAll(line, "\t", strings.Repeat(" ", tabWidth))
+}
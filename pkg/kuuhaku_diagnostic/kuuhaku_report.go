@@ -0,0 +1,272 @@
+package kuuhaku_diagnostic
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+)
+
+type FileStatus string
+
+const (
+	FILE_CHANGED   FileStatus = "changed"
+	FILE_UNCHANGED FileStatus = "unchanged"
+	FILE_FAILED    FileStatus = "failed"
+	FILE_CHECKED   FileStatus = "checked" //only the configuration was analyzed, see -static
+)
+
+// columns and lines are 1-based, offset is the 0-based byte offset
+type RecordPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Offset int `json:"offset"`
+}
+
+// the end of a span is exclusive, a span that points at a single character ends one column after it
+type RecordSpan struct {
+	File  string         `json:"file"`
+	Start RecordPosition `json:"start"`
+	End   RecordPosition `json:"end"`
+	Label string         `json:"label,omitempty"`
+}
+
+// a diagnostic in the form that is written as JSON and SARIF
+type Record struct {
+	Severity  string       `json:"severity"`
+	Code      string       `json:"code,omitempty"`
+	Message   string       `json:"message"`
+	Notes     []string     `json:"notes,omitempty"`
+	Span      *RecordSpan  `json:"span,omitempty"`
+	Secondary []RecordSpan `json:"secondary,omitempty"`
+}
+
+type FileResult struct {
+	File   string     `json:"file"`
+	Status FileStatus `json:"status"`
+}
+
+// collects the diagnostics and the formatting results of a run
+type Report struct {
+	Files       []FileResult `json:"files"`
+	Diagnostics []Record     `json:"diagnostics"`
+	isAdded     map[string]bool
+}
+
+// ToRecord converts a diagnostic, positions without a file are in the file named inputName
+func ToRecord(diagnostic Diagnostic, inputName string) Record {
+	record := Record{
+		Severity: diagnostic.Severity.String(),
+		Code:     diagnostic.Code,
+		Message:  diagnostic.Message,
+		Notes:    diagnostic.Notes,
+	}
+	if diagnostic.Primary != nil {
+		span := toRecordSpan(*diagnostic.Primary, inputName)
+		record.Span = &span
+	}
+	for _, secondary := range diagnostic.Secondary {
+		record.Secondary = append(record.Secondary, toRecordSpan(secondary, inputName))
+	}
+	return record
+}
+
+func toRecordSpan(span Span, inputName string) RecordSpan {
+	file := span.Start.File
+	if file == "" {
+		file = inputName
+	}
+	end := span.End
+	if end.Line < span.Start.Line || (end.Line == span.Start.Line && end.Column <= span.Start.Column) {
+		end = span.Start
+		end.Column++
+		end.Raw++
+	}
+	return RecordSpan{
+		File:  file,
+		Start: toRecordPosition(span.Start),
+		End:   toRecordPosition(end),
+		Label: span.Label,
+	}
+}
+
+func toRecordPosition(position kuuhaku_tokenizer.Position) RecordPosition {
+	return RecordPosition{
+		Line:   position.Line,
+		Column: position.Column,
+		Offset: position.Raw,
+	}
+}
+
+// Add records the errors and warnings of the file named inputName. The same diagnostic is only
+// recorded once, like the warnings of a grammar that is used for several files
+func (report *Report) Add(errs []error, inputName string) {
+	for _, err := range errs {
+		report.AddDiagnostic(FromError(err), inputName)
+	}
+}
+
+func (report *Report) AddDiagnostic(diagnostic Diagnostic, inputName string) {
+	if report.isAdded == nil {
+		report.isAdded = make(map[string]bool)
+	}
+	record := ToRecord(diagnostic, inputName)
+	key, _ := json.Marshal(record)
+	if report.isAdded[string(key)] {
+		return
+	}
+	report.isAdded[string(key)] = true
+	report.Diagnostics = append(report.Diagnostics, record)
+}
+
+func (report *Report) AddFile(file string, status FileStatus) {
+	report.Files = append(report.Files, FileResult{File: file, Status: status})
+}
+
+func (report *Report) ToJSON() ([]byte, error) {
+	out := *report
+	if out.Files == nil {
+		out.Files = []FileResult{}
+	}
+	if out.Diagnostics == nil {
+		out.Diagnostics = []Record{}
+	}
+	return marshal(out)
+}
+
+// the messages quote terminals like <[a-z]+>, which json.Marshal would escape
+func marshal(value any) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(value)
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), err
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool      sarifTool       `json:"tool"`
+	Artifacts []sarifArtifact `json:"artifacts,omitempty"`
+	Results   []sarifResult   `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules,omitempty"`
+}
+
+type sarifRule struct {
+	Id string `json:"id"`
+}
+
+type sarifArtifact struct {
+	Location   sarifArtifactLocation `json:"location"`
+	Properties map[string]string     `json:"properties"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleId           string          `json:"ruleId,omitempty"`
+	Level            string          `json:"level"`
+	Message          sarifMessage    `json:"message"`
+	Locations        []sarifLocation `json:"locations,omitempty"`
+	RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+}
+
+type sarifLocation struct {
+	Id               int                   `json:"id,omitempty"`
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	Message          *sarifMessage         `json:"message,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	Uri string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine"`
+	EndColumn   int `json:"endColumn"`
+}
+
+// ToSARIF writes the report as a SARIF 2.1.0 log. The notes are appended to the message and the status
+// of each formatted file is kept in the properties of its artifact
+func (report *Report) ToSARIF() ([]byte, error) {
+	run := sarifRun{
+		Tool:    sarifTool{Driver: sarifDriver{Name: "kuuhaku"}},
+		Results: []sarifResult{},
+	}
+	isRuleAdded := make(map[string]bool)
+	for _, record := range report.Diagnostics {
+		text := record.Message
+		for _, note := range record.Notes {
+			text += "\n" + note
+		}
+		result := sarifResult{
+			RuleId:  record.Code,
+			Level:   record.Severity,
+			Message: sarifMessage{Text: text},
+		}
+		if record.Code != "" && !isRuleAdded[record.Code] {
+			isRuleAdded[record.Code] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{Id: record.Code})
+		}
+		if record.Span != nil {
+			result.Locations = append(result.Locations, toSarifLocation(*record.Span, 0))
+		}
+		for i, secondary := range record.Secondary {
+			result.RelatedLocations = append(result.RelatedLocations, toSarifLocation(secondary, i+1))
+		}
+		run.Results = append(run.Results, result)
+	}
+	for _, file := range report.Files {
+		run.Artifacts = append(run.Artifacts, sarifArtifact{
+			Location:   sarifArtifactLocation{Uri: filepath.ToSlash(file.File)},
+			Properties: map[string]string{"status": string(file.Status)},
+		})
+	}
+	return marshal(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	})
+}
+
+func toSarifLocation(span RecordSpan, id int) sarifLocation {
+	location := sarifLocation{
+		Id: id,
+		PhysicalLocation: sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifactLocation{Uri: filepath.ToSlash(span.File)},
+			Region: sarifRegion{
+				StartLine:   span.Start.Line,
+				StartColumn: span.Start.Column,
+				EndLine:     span.End.Line,
+				EndColumn:   span.End.Column,
+			},
+		},
+	}
+	if span.Label != "" {
+		location.Message = &sarifMessage{Text: span.Label}
+	}
+	return location
+}
@@ -9,15 +9,58 @@ type Ast struct {
 	Position     kuuhaku_tokenizer.Position
 	GlobalLua	 *LuaLiteral
 	IsSearchMode bool
+	IsGLRMode    bool
+	IsNodeMode   bool           //the bindings of the lua literals are nodes instead of formatted strings
+	Preferences  []Identifier //rule names that win an ambiguity in GLR mode, the earlier the stronger
+	Trivia       []RegexLiteral //terminals declared with IGNORE, skipped by the runtime between tokens
+	Modes        []*LexerMode   //lexer modes in the order they are first declared
+
+	IsLongestMatch        bool           //the longest matching terminal wins instead of the first one by rule order
+	LongestMatchTerminals []RegexLiteral //terminals that use the longest match regardless of IsLongestMatch
+	FirstMatchTerminals   []RegexLiteral //terminals that use the first match regardless of IsLongestMatch
+}
+
+// a lexer mode is declared by MODE, PUSH and POP directives with the same name. While a mode is on top
+// of the mode stack only its terminals are eligible, unless it has no MODE directive
+type LexerMode struct {
+	Name         string
+	Terminals    []RegexLiteral //declared with MODE
+	Pushes       []RegexLiteral //shifting one of these pushes the mode, declared with PUSH
+	Pops         []RegexLiteral //shifting one of these while in the mode pops it, declared with POP
+	IsRestricted bool           //true if there's a MODE directive for the mode
+	Position     kuuhaku_tokenizer.Position
 }
 
 type Rule struct {
-	Name        string
-	Order       int
-	MatchRules  []MatchRule
-	ReplaceRule *LuaLiteral
-	Position    kuuhaku_tokenizer.Position
-	ArgList     []Identifier
+	Name             string
+	Order            int
+	SourceOrder      int //the index of the rule definition as written, the rules desugared from it share it
+	MatchRules       []MatchRule
+	SourceMatchRules []MatchRule //the match rules as written with the EBNF operators and the names given to them
+	ReplaceRule      *LuaLiteral
+	CollectRule      *LuaLiteral //runs in the collect pass, before any replace rule
+	Position         kuuhaku_tokenizer.Position
+	ArgList          []Identifier
+	AbsentBindings   []AbsentBinding //bindings of optional match rules that are left out in this alternative
+	Hidden           HiddenRuleType
+	IsImported       bool   //imported rules are never start symbols
+	Pattern          string //the match rules as written before desugaring, used to find the alternative to override
+	Alternative      int    //the 1-based number of the written alternative among the rules with the same name, set by the analyzer
+}
+
+// rules generated by the parser when desugaring EBNF operators
+type HiddenRuleType int
+
+const (
+	NOT_HIDDEN HiddenRuleType = iota
+	HIDDEN_LIST                       //evaluates to a lua table of its elements
+	HIDDEN_GROUP                      //evaluates to a lua table keyed by the bindings inside the group
+)
+
+// an absent optional is bound to nil, an absent repetition is bound to an empty table
+type AbsentBinding struct {
+	Name   string
+	IsList bool
 }
 
 type MatchRule interface {
@@ -30,6 +73,7 @@ type Identifier struct {
 	Name     string
 	ArgList  []LuaLiteral
 	Position kuuhaku_tokenizer.Position
+	Binding  string //the lua variable that holds the value of this match rule
 }
 
 func (i Identifier) matchRule() {}
@@ -43,6 +87,7 @@ func (i Identifier) GetPosition() kuuhaku_tokenizer.Position {
 type RegexLiteral struct {
 	RegexString string
 	Position    kuuhaku_tokenizer.Position
+	Binding     string
 }
 
 func (r RegexLiteral) matchRule() {}
@@ -53,6 +98,23 @@ func (r RegexLiteral) GetPosition() kuuhaku_tokenizer.Position {
 	return r.Position
 }
 
+// only exists while parsing, desugarRule turns it into plain match rules and hidden rules
+type ebnfMatchRule struct {
+	Items      []MatchRule //a single operand or the content of a group
+	IsGroup    bool
+	Quantifier kuuhaku_tokenizer.TokenType //QUESTION_MARK, ASTERISK, PLUS_SIGN or EOF for none
+	Position   kuuhaku_tokenizer.Position
+	Binding    string
+}
+
+func (e ebnfMatchRule) matchRule() {}
+func (e ebnfMatchRule) GetString() string {
+	return "(...)"
+}
+func (e ebnfMatchRule) GetPosition() kuuhaku_tokenizer.Position {
+	return e.Position
+}
+
 type LuaLiteral struct {
 	LuaString string
 	Position  kuuhaku_tokenizer.Position
@@ -0,0 +1,281 @@
+package kuuhaku_parser
+
+import (
+	"strconv"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+)
+
+// one way a sequence of match rules can be spelled out once the EBNF operators are expanded
+type alternative struct {
+	matchRules []MatchRule
+	absent     []AbsentBinding
+}
+
+// keeps track of the lua variable names inside one namespace. Identifiers are bound to their name
+// without the import namespace followed by their occurrence count, regex literals to LITERAL followed by theirs and repeated groups
+// to GROUP followed by theirs. Named match rules keep their name but are still counted, so naming one
+// match rule doesn't rename the others. The content of a repeated group gets its own namespace
+type bindingCounter struct {
+	identifiers map[string]int
+	literals    int
+	groups      int
+}
+
+func newBindingCounter() *bindingCounter {
+	return &bindingCounter{
+		identifiers: make(map[string]int),
+	}
+}
+
+func (counter *bindingCounter) assign(matchRules []MatchRule) []MatchRule {
+	out := make([]MatchRule, len(matchRules))
+	for i, matchRule := range matchRules {
+		switch m := matchRule.(type) {
+		case Identifier:
+			base := bindingBase(m.Name)
+			counter.identifiers[base] += 1
+			if m.Binding == "" {
+				m.Binding = base + strconv.Itoa(counter.identifiers[base])
+			}
+			out[i] = m
+		case RegexLiteral:
+			counter.literals += 1
+			if m.Binding == "" {
+				m.Binding = "LITERAL" + strconv.Itoa(counter.literals)
+			}
+			out[i] = m
+		case ebnfMatchRule:
+			if m.IsGroup && isRepetition(m.Quantifier) {
+				counter.groups += 1
+				if m.Binding == "" {
+					m.Binding = "GROUP" + strconv.Itoa(counter.groups)
+				}
+				m.Items = newBindingCounter().assign(m.Items)
+			} else {
+				m.Items = counter.assign(m.Items)
+				if !m.IsGroup {
+					m.Binding = GetBinding(m.Items[0])
+				}
+			}
+			out[i] = m
+		default:
+			out[i] = matchRule
+		}
+	}
+	return out
+}
+
+func isRepetition(quantifier kuuhaku_tokenizer.TokenType) bool {
+	return quantifier == kuuhaku_tokenizer.ASTERISK || quantifier == kuuhaku_tokenizer.PLUS_SIGN
+}
+
+// returns the lua variable that holds the value of the match rule
+func GetBinding(matchRule MatchRule) string {
+	switch m := matchRule.(type) {
+	case Identifier:
+		return m.Binding
+	case RegexLiteral:
+		return m.Binding
+	case ebnfMatchRule:
+		return m.Binding
+	}
+	return ""
+}
+
+// desugarRule expands the EBNF operators of a rule into plain alternatives. An optional becomes one
+// alternative with and one without its operand, so a rule like A { B? } gets an empty alternative.
+// A repetition becomes a reference to a hidden left recursive list rule and a repeated group becomes
+// a hidden group rule. The alternatives are returned first, followed by the hidden rules.
+// Since every optional and every * doubles the alternatives, a rule with n of them gets 2^n
+// alternatives. They are spelled out only inside the rule, the content of a repetition is in its own
+// hidden rule
+func (parser *Parser) desugarRule(rule *Rule) []*Rule {
+	if len(rule.MatchRules) == 0 {
+		return []*Rule{rule}
+	}
+
+	var hiddenRules []*Rule
+	pattern := matchRulesToPattern(rule.MatchRules)
+	matchRules := newBindingCounter().assign(rule.MatchRules)
+	alternatives := parser.expandMatchRules(rule, matchRules, &hiddenRules)
+
+	var out []*Rule
+	for _, alt := range alternatives {
+		newRule := *rule
+		newRule.MatchRules = alt.matchRules
+		newRule.SourceMatchRules = rule.MatchRules
+		newRule.AbsentBindings = alt.absent
+		newRule.Pattern = pattern
+		out = append(out, &newRule)
+	}
+	return append(out, hiddenRules...)
+}
+
+func (parser *Parser) expandMatchRules(rule *Rule, matchRules []MatchRule, hiddenRules *[]*Rule) []alternative {
+	alternatives := []alternative{{}}
+	for _, matchRule := range matchRules {
+		m, ok := matchRule.(ebnfMatchRule)
+		if !ok {
+			alternatives = combineAlternatives(alternatives, []alternative{{matchRules: []MatchRule{matchRule}}})
+			continue
+		}
+
+		var variants []alternative
+		switch m.Quantifier {
+		case kuuhaku_tokenizer.QUESTION_MARK:
+			variants = parser.expandMatchRules(rule, m.Items, hiddenRules)
+			variants = append(variants, alternative{absent: getAbsentBindings(m.Items)})
+		case kuuhaku_tokenizer.ASTERISK:
+			reference := parser.makeListRule(rule, m, hiddenRules)
+			variants = []alternative{
+				{matchRules: []MatchRule{reference}},
+				{absent: []AbsentBinding{{Name: m.Binding, IsList: true}}},
+			}
+		case kuuhaku_tokenizer.PLUS_SIGN:
+			reference := parser.makeListRule(rule, m, hiddenRules)
+			variants = []alternative{{matchRules: []MatchRule{reference}}}
+		default:
+			variants = parser.expandMatchRules(rule, m.Items, hiddenRules)
+		}
+		alternatives = combineAlternatives(alternatives, variants)
+	}
+	return alternatives
+}
+
+// names and arguments are left out, they don't change what the alternative matches
+func matchRulesToPattern(matchRules []MatchRule) string {
+	out := ""
+	for i, matchRule := range matchRules {
+		if i != 0 {
+			out += " "
+		}
+		switch m := matchRule.(type) {
+		case Identifier:
+			out += m.Name
+		case RegexLiteral:
+			out += "<" + m.RegexString + ">"
+		case ebnfMatchRule:
+			if m.IsGroup {
+				out += "(" + matchRulesToPattern(m.Items) + ")"
+			} else {
+				out += matchRulesToPattern(m.Items)
+			}
+			switch m.Quantifier {
+			case kuuhaku_tokenizer.QUESTION_MARK:
+				out += "?"
+			case kuuhaku_tokenizer.ASTERISK:
+				out += "*"
+			case kuuhaku_tokenizer.PLUS_SIGN:
+				out += "+"
+			}
+		}
+	}
+	return out
+}
+
+func combineAlternatives(prefixes []alternative, suffixes []alternative) []alternative {
+	var out []alternative
+	for _, prefix := range prefixes {
+		for _, suffix := range suffixes {
+			var combined alternative
+			combined.matchRules = append(combined.matchRules, prefix.matchRules...)
+			combined.matchRules = append(combined.matchRules, suffix.matchRules...)
+			combined.absent = append(combined.absent, prefix.absent...)
+			combined.absent = append(combined.absent, suffix.absent...)
+			out = append(out, combined)
+		}
+	}
+	return out
+}
+
+func getAbsentBindings(matchRules []MatchRule) []AbsentBinding {
+	var out []AbsentBinding
+	for _, matchRule := range matchRules {
+		m, ok := matchRule.(ebnfMatchRule)
+		if !ok {
+			out = append(out, AbsentBinding{Name: GetBinding(matchRule)})
+		} else if isRepetition(m.Quantifier) {
+			out = append(out, AbsentBinding{Name: m.Binding, IsList: true})
+		} else {
+			out = append(out, getAbsentBindings(m.Items)...)
+		}
+	}
+	return out
+}
+
+// makes the rules L { L element } and L { element }, returns the reference to L
+func (parser *Parser) makeListRule(rule *Rule, m ebnfMatchRule, hiddenRules *[]*Rule) Identifier {
+	var element MatchRule
+	if m.IsGroup {
+		element = parser.makeGroupRule(rule, m, hiddenRules)
+	} else {
+		element = m.Items[0]
+	}
+
+	name := parser.makeHiddenRuleName(rule.Name)
+	reference := Identifier{
+		Name:     name,
+		ArgList:  makePassingArgs(rule.ArgList),
+		Position: m.Position,
+		Binding:  m.Binding,
+	}
+	*hiddenRules = append(*hiddenRules, &Rule{
+		Name:        name,
+		SourceOrder: rule.SourceOrder,
+		MatchRules:  []MatchRule{reference, element},
+		Position:    m.Position,
+		ArgList:     rule.ArgList,
+		Hidden:      HIDDEN_LIST,
+	}, &Rule{
+		Name:        name,
+		SourceOrder: rule.SourceOrder,
+		MatchRules:  []MatchRule{element},
+		Position:    m.Position,
+		ArgList:     rule.ArgList,
+		Hidden:      HIDDEN_LIST,
+	})
+	return reference
+}
+
+func (parser *Parser) makeGroupRule(rule *Rule, m ebnfMatchRule, hiddenRules *[]*Rule) Identifier {
+	name := parser.makeHiddenRuleName(rule.Name)
+	var groupRules []*Rule
+	for _, alt := range parser.expandMatchRules(rule, m.Items, hiddenRules) {
+		groupRules = append(groupRules, &Rule{
+			Name:           name,
+			SourceOrder:    rule.SourceOrder,
+			MatchRules:     alt.matchRules,
+			Position:       m.Position,
+			ArgList:        rule.ArgList,
+			AbsentBindings: alt.absent,
+			Hidden:         HIDDEN_GROUP,
+		})
+	}
+	*hiddenRules = append(*hiddenRules, groupRules...)
+	return Identifier{
+		Name:     name,
+		ArgList:  makePassingArgs(rule.ArgList),
+		Position: m.Position,
+		Binding:  m.Binding,
+	}
+}
+
+// the '#' can't be typed inside an identifier so hidden rules never clash with the user's rules
+func (parser *Parser) makeHiddenRuleName(parent string) string {
+	parser.hiddenRuleCount += 1
+	return parent + "#" + strconv.Itoa(parser.hiddenRuleCount)
+}
+
+// hidden rules take the same parameters as the rule they come from and just pass them along
+func makePassingArgs(params []Identifier) []LuaLiteral {
+	var out []LuaLiteral
+	for _, param := range params {
+		out = append(out, LuaLiteral{
+			LuaString: "return " + param.Name,
+			Position:  param.Position,
+			Type:      LUA_LITERAL_TYPE_RETURN,
+		})
+	}
+	return out
+}
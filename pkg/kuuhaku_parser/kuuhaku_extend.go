@@ -0,0 +1,170 @@
+package kuuhaku_parser
+
+import (
+	"strings"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+)
+
+func ErrMultipleExtends(position kuuhaku_tokenizer.Position) *ParseError {
+	return &ParseError{
+		Message:  "A grammar can only extend one base grammar",
+		Position: position,
+		Type:     MULTIPLE_EXTENDS,
+	}
+}
+
+func ErrExtendsAfterRules(position kuuhaku_tokenizer.Position) *ParseError {
+	return &ParseError{
+		Message:  "EXTENDS must come before the rule definitions",
+		Position: position,
+		Type:     EXTENDS_AFTER_RULES,
+	}
+}
+
+func ErrExpectedOverriddenRule(tokenizer *kuuhaku_tokenizer.Tokenizer) *ParseError {
+	return &ParseError{
+		Message:  "Expected a rule definition after OVERRIDE",
+		Position: tokenizer.PrevPosition,
+		Type:     EXPECTED_OVERRIDDEN_RULE,
+	}
+}
+
+func ErrOverriddenRuleNotFound(position kuuhaku_tokenizer.Position, ruleName string, pattern string) *ParseError {
+	return &ParseError{
+		Message:  "There is no alternative " + ruleName + " { " + pattern + " } to override",
+		Position: position,
+		Type:     OVERRIDDEN_RULE_NOT_FOUND,
+	}
+}
+
+// EXTENDS base merges the rules of the base grammar into this one. A rule defined again in the
+// extending grammar replaces every alternative of the base rule, so EXTENDS must come before the rules
+func (parser *Parser) consumeExtends(output *Ast, orderCounter *int) {
+	path, position, ok := parser.consumeImportPath()
+	if !ok {
+		return
+	}
+	if parser.baseRules != nil {
+		parser.Errors = append(parser.Errors, ErrMultipleExtends(position))
+		return
+	}
+	if parser.isRuleDefined {
+		parser.Errors = append(parser.Errors, ErrExtendsAfterRules(position))
+		return
+	}
+	parser.baseRules = make(map[string]bool)
+	parser.replacedOrders = make(map[string]int)
+
+	base := parser.importFile(path, "", position)
+	if base == nil {
+		return
+	}
+
+	startSymbols := getStartCandidates(base)
+	parser.mergeImport(output, base, "", orderCounter)
+	for name, ruleArray := range base.Rules {
+		for _, rule := range ruleArray {
+			rule.IsImported = !startSymbols[name]
+		}
+		if ruleArray[0].Hidden == NOT_HIDDEN {
+			parser.baseRules[name] = true
+		}
+	}
+
+	output.IsSearchMode = output.IsSearchMode || base.IsSearchMode
+	output.IsGLRMode = output.IsGLRMode || base.IsGLRMode
+	output.IsNodeMode = output.IsNodeMode || base.IsNodeMode
+	output.IsLongestMatch = output.IsLongestMatch || base.IsLongestMatch
+}
+
+// the rules the base grammar would start from, they stay start symbols in the extending grammar while
+// the rest of the base rules are treated like imported rules
+func getStartCandidates(ast *Ast) map[string]bool {
+	out := make(map[string]bool)
+	for name, ruleArray := range ast.Rules {
+		if !ruleArray[0].IsImported && ruleArray[0].Hidden == NOT_HIDDEN {
+			out[name] = true
+		}
+	}
+	for name, ruleArray := range ast.Rules {
+		for _, rule := range ruleArray {
+			for _, matchRule := range rule.MatchRules {
+				identifier, ok := matchRule.(Identifier)
+				if ok && identifier.Name != name {
+					delete(out, identifier.Name)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// removes the base rule before the extending grammar defines it again, along with its hidden rules.
+// The rules defining it again take its place in the order, which decides the precedence of the
+// terminals, so the returned ok is true when the rules must be inserted at the returned order
+func (parser *Parser) replaceBaseRule(output *Ast, name string) (int, bool) {
+	order, ok := parser.replacedOrders[name]
+	if ok || !parser.baseRules[name] {
+		return order, ok
+	}
+	delete(parser.baseRules, name)
+	order = -1
+	for ruleName, ruleArray := range output.Rules {
+		if ruleName != name && !strings.HasPrefix(ruleName, name+"#") {
+			continue
+		}
+		for _, rule := range ruleArray {
+			if order == -1 || rule.Order < order {
+				order = rule.Order
+			}
+		}
+		delete(output.Rules, ruleName)
+	}
+	return order, true
+}
+
+// gives the rules the orders starting from order, the rules after it are moved back to make room
+func (parser *Parser) insertRules(output *Ast, name string, rules []*Rule, order int, orderCounter *int) {
+	for _, ruleArray := range output.Rules {
+		for _, rule := range ruleArray {
+			if rule.Order >= order {
+				rule.Order += len(rules)
+			}
+		}
+	}
+	for replacedName, replacedOrder := range parser.replacedOrders {
+		if replacedOrder > order {
+			parser.replacedOrders[replacedName] += len(rules)
+		}
+	}
+	for i, rule := range rules {
+		rule.Order = order + i
+		output.Rules[rule.Name] = append(output.Rules[rule.Name], rule)
+	}
+	parser.replacedOrders[name] = order + len(rules)
+	*orderCounter += len(rules)
+}
+
+// OVERRIDE Name { match rules = replace rule } only swaps the replace rule and the collect rule of the
+// alternative with the same match rules, the match rules keep their names from the original definition
+func (parser *Parser) consumeOverride(output *Ast) {
+	rule := parser.consumeRule()
+	if rule == nil {
+		parser.Errors = append(parser.Errors, ErrExpectedOverriddenRule(&parser.tokenizer))
+		return
+	}
+
+	pattern := matchRulesToPattern(rule.MatchRules)
+	isFound := false
+	for _, alternative := range output.Rules[rule.Name] {
+		if alternative.Pattern == pattern {
+			alternative.ReplaceRule = rule.ReplaceRule
+			alternative.CollectRule = rule.CollectRule
+			isFound = true
+		}
+	}
+	if !isFound {
+		parser.Errors = append(parser.Errors, ErrOverriddenRuleNotFound(rule.Position, rule.Name, pattern))
+	}
+}
@@ -0,0 +1,257 @@
+package kuuhaku_parser
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+)
+
+func ErrExpectedImportPath(tokenizer *kuuhaku_tokenizer.Tokenizer) *ParseError {
+	return &ParseError{
+		Message:  "Expected a string literal or a name of a grammar",
+		Position: tokenizer.PrevPosition,
+		Type:     EXPECTED_IMPORT_PATH,
+	}
+}
+
+func ErrExpectedImportAlias(tokenizer *kuuhaku_tokenizer.Tokenizer) *ParseError {
+	return &ParseError{
+		Message:  "Expected a namespace name after AS",
+		Position: tokenizer.PrevPosition,
+		Type:     EXPECTED_IMPORT_ALIAS,
+	}
+}
+
+func ErrImportNotFound(position kuuhaku_tokenizer.Position, path string) *ParseError {
+	return &ParseError{
+		Message:  "Can't find the imported grammar " + path,
+		Position: position,
+		Type:     IMPORT_NOT_FOUND,
+	}
+}
+
+func ErrImportCycle(position kuuhaku_tokenizer.Position, path string) *ParseError {
+	return &ParseError{
+		Message:  "Importing " + path + " results in an import cycle",
+		Position: position,
+		Type:     IMPORT_CYCLE,
+	}
+}
+
+// shared between a grammar and all of the grammars it imports
+type importState struct {
+	stack    []string        //absolute paths of the grammars that are being parsed, used to detect cycles
+	imported map[string]bool //absolute path and namespace pairs that are already merged
+}
+
+func newImportState() *importState {
+	return &importState{
+		imported: make(map[string]bool),
+	}
+}
+
+// ParseFile parses the grammar at path together with the grammars it imports. Imports are resolved
+// relative to the importing file first, then relative to each of the search paths
+func ParseFile(path string, searchPaths []string) (Ast, []error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Ast{}, []error{err}
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return Ast{}, []error{err}
+	}
+
+	imports := newImportState()
+	imports.stack = append(imports.stack, absPath)
+	parser := initFileParser(string(content), path, searchPaths, imports)
+	ast := parser.consumeInput()
+	return *ast, parser.Errors
+}
+
+func initFileParser(input string, file string, searchPaths []string, imports *importState) Parser {
+	return Parser{
+		tokenizer:   kuuhaku_tokenizer.InitFile(input, file),
+		Errors:      []error{},
+		file:        file,
+		searchPaths: searchPaths,
+		imports:     imports,
+	}
+}
+
+// IMPORT "path/to/grammar.khk" or IMPORT grammar, both optionally followed by AS namespace
+func (parser *Parser) consumeImport(output *Ast, orderCounter *int) {
+	path, position, ok := parser.consumeImportPath()
+	if !ok {
+		return
+	}
+
+	namespace := ""
+	token, err := parser.tokenizer.Peek()
+	if err == nil && token.Type == kuuhaku_tokenizer.IDENTIFIER && token.Content == "AS" {
+		token, err = parser.tokenizer.Next()
+		if err != nil || token.Type != kuuhaku_tokenizer.IDENTIFIER {
+			parser.Errors = append(parser.Errors, ErrExpectedImportAlias(&parser.tokenizer))
+			return
+		}
+		namespace = token.Content
+		parser.tokenizer.Next()
+	}
+
+	imported := parser.importFile(path, namespace, position)
+	if imported != nil {
+		parser.mergeImport(output, imported, namespace, orderCounter)
+	}
+}
+
+// a string literal is used as is, a name refers to the .khk file with that name
+func (parser *Parser) consumeImportPath() (string, kuuhaku_tokenizer.Position, bool) {
+	token, err := parser.tokenizer.Peek()
+	if err != nil {
+		parser.tokenizer.Next()
+		parser.Errors = append(parser.Errors, err)
+		return "", kuuhaku_tokenizer.Position{}, false
+	}
+
+	var path string
+	switch token.Type {
+	case kuuhaku_tokenizer.STRING_LITERAL:
+		path = token.Content
+	case kuuhaku_tokenizer.IDENTIFIER:
+		path = token.Content + ".khk"
+	default:
+		parser.Errors = append(parser.Errors, ErrExpectedImportPath(&parser.tokenizer))
+		return "", kuuhaku_tokenizer.Position{}, false
+	}
+	parser.tokenizer.Next()
+	return path, token.Position, true
+}
+
+// returns nil if the grammar can't be imported or is already imported into the same namespace
+func (parser *Parser) importFile(path string, namespace string, position kuuhaku_tokenizer.Position) *Ast {
+	resolved := parser.resolveImport(path)
+	if resolved == "" {
+		parser.Errors = append(parser.Errors, ErrImportNotFound(position, path))
+		return nil
+	}
+	absPath, err := filepath.Abs(resolved)
+	if err != nil {
+		parser.Errors = append(parser.Errors, ErrImportNotFound(position, path))
+		return nil
+	}
+	for _, importing := range parser.imports.stack {
+		if importing == absPath {
+			parser.Errors = append(parser.Errors, ErrImportCycle(position, path))
+			return nil
+		}
+	}
+	key := absPath + " AS " + namespace
+	if parser.imports.imported[key] {
+		return nil
+	}
+	parser.imports.imported[key] = true
+
+	content, err := os.ReadFile(resolved)
+	if err != nil {
+		parser.Errors = append(parser.Errors, ErrImportNotFound(position, path))
+		return nil
+	}
+
+	importParser := initFileParser(string(content), resolved, parser.searchPaths, parser.imports)
+	importParser.hiddenRuleCount = parser.hiddenRuleCount
+	parser.imports.stack = append(parser.imports.stack, absPath)
+	ast := importParser.consumeInput()
+	parser.imports.stack = parser.imports.stack[:len(parser.imports.stack)-1]
+	parser.hiddenRuleCount = importParser.hiddenRuleCount
+	parser.Errors = append(parser.Errors, importParser.Errors...)
+	return ast
+}
+
+func (parser *Parser) resolveImport(path string) string {
+	var candidates []string
+	if filepath.IsAbs(path) {
+		candidates = append(candidates, path)
+	} else {
+		candidates = append(candidates, filepath.Join(filepath.Dir(parser.file), path))
+		for _, searchPath := range parser.searchPaths {
+			candidates = append(candidates, filepath.Join(searchPath, path))
+		}
+	}
+	for _, candidate := range candidates {
+		info, err := os.Stat(candidate)
+		if err == nil && !info.IsDir() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// adds the rules of an imported grammar in their original order, prefixed with the namespace
+func (parser *Parser) mergeImport(output *Ast, imported *Ast, namespace string, orderCounter *int) {
+	var rules []*Rule
+	for _, ruleArray := range imported.Rules {
+		rules = append(rules, ruleArray...)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Order < rules[j].Order
+	})
+
+	for _, rule := range rules {
+		matchRules := make([]MatchRule, len(rule.MatchRules))
+		for i, matchRule := range rule.MatchRules {
+			identifier, ok := matchRule.(Identifier)
+			if ok && len(imported.Rules[identifier.Name]) > 0 {
+				identifier.Name = namespaced(namespace, identifier.Name)
+				matchRule = identifier
+			}
+			matchRules[i] = matchRule
+		}
+		rule.MatchRules = matchRules
+		rule.Name = namespaced(namespace, rule.Name)
+		rule.Order = *orderCounter
+		rule.IsImported = true
+		*orderCounter += 1
+		output.Rules[rule.Name] = append(output.Rules[rule.Name], rule)
+	}
+
+	if imported.GlobalLua != nil {
+		appendGlobalLua(output, imported.GlobalLua)
+	}
+	for _, preference := range imported.Preferences {
+		preference.Name = namespaced(namespace, preference.Name)
+		output.Preferences = append(output.Preferences, preference)
+	}
+	output.Trivia = appendTerminals(output.Trivia, imported.Trivia)
+	output.LongestMatchTerminals = appendTerminals(output.LongestMatchTerminals, imported.LongestMatchTerminals)
+	output.FirstMatchTerminals = appendTerminals(output.FirstMatchTerminals, imported.FirstMatchTerminals)
+	mergeModes(output, imported)
+}
+
+func namespaced(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// the lua variable of a namespaced rule is named after the rule without its namespace
+func bindingBase(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// the global lua literals of a grammar and its imports are joined in the order they appear
+func appendGlobalLua(output *Ast, globalLua *LuaLiteral) {
+	if output.GlobalLua == nil {
+		copied := *globalLua
+		output.GlobalLua = &copied
+		return
+	}
+	output.GlobalLua = &LuaLiteral{
+		LuaString: output.GlobalLua.LuaString + "\n" + globalLua.LuaString,
+		Position:  output.GlobalLua.Position,
+		Type:      output.GlobalLua.Type,
+	}
+}
@@ -0,0 +1,112 @@
+package kuuhaku_parser
+
+import (
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+)
+
+func ErrExpectedModeName(tokenizer *kuuhaku_tokenizer.Tokenizer) *ParseError {
+	return &ParseError{
+		Message:  "Expected the name of a lexer mode",
+		Position: tokenizer.PrevPosition,
+		Type:     EXPECTED_MODE_NAME,
+	}
+}
+
+// IGNORE { <regex> <regex> ... } declares the terminals that are skipped between tokens, such as
+// whitespace and comments
+func (parser *Parser) consumeIgnore(output *Ast) {
+	terminals, ok := parser.consumeTerminalList()
+	if ok {
+		output.Trivia = appendTerminals(output.Trivia, terminals)
+	}
+}
+
+// LONGEST_MATCH on its own makes the longest matching terminal win in the whole grammar, followed by
+// { terminals } it only applies to those terminals. FIRST_MATCH { terminals } opts terminals out of it
+func (parser *Parser) consumeLongestMatch(output *Ast) {
+	token, err := parser.tokenizer.Peek()
+	if err != nil || token.Type != kuuhaku_tokenizer.OPENING_CURLY_BRACKET {
+		output.IsLongestMatch = true
+		return
+	}
+	terminals, ok := parser.consumeTerminalList()
+	if ok {
+		output.LongestMatchTerminals = appendTerminals(output.LongestMatchTerminals, terminals)
+	}
+}
+
+// MODE name { terminals } restricts the eligible terminals while the mode is on top of the mode stack,
+// PUSH name { terminals } and POP name { terminals } declare the terminals that enter and leave it
+func (parser *Parser) consumeMode(output *Ast, directive kuuhaku_tokenizer.TokenType) {
+	token, err := parser.tokenizer.Peek()
+	if err != nil {
+		parser.tokenizer.Next()
+		parser.Errors = append(parser.Errors, err)
+		return
+	}
+	if token.Type != kuuhaku_tokenizer.IDENTIFIER {
+		parser.Errors = append(parser.Errors, ErrExpectedModeName(&parser.tokenizer))
+		return
+	}
+	parser.tokenizer.Next()
+
+	terminals, ok := parser.consumeTerminalList()
+	if !ok {
+		return
+	}
+
+	mode := getMode(output, token.Content, token.Position)
+	switch directive {
+	case kuuhaku_tokenizer.MODE_KEYWORD:
+		mode.Terminals = appendTerminals(mode.Terminals, terminals)
+		mode.IsRestricted = true
+	case kuuhaku_tokenizer.PUSH_KEYWORD:
+		mode.Pushes = appendTerminals(mode.Pushes, terminals)
+	case kuuhaku_tokenizer.POP_KEYWORD:
+		mode.Pops = appendTerminals(mode.Pops, terminals)
+	}
+}
+
+// returns the mode with the name, the mode is added if it isn't declared yet
+func getMode(output *Ast, name string, position kuuhaku_tokenizer.Position) *LexerMode {
+	for _, mode := range output.Modes {
+		if mode.Name == name {
+			return mode
+		}
+	}
+	mode := &LexerMode{
+		Name:     name,
+		Position: position,
+	}
+	output.Modes = append(output.Modes, mode)
+	return mode
+}
+
+// a terminal that is declared more than once, e.g. by two imported grammars, is only kept once
+func appendTerminals(terminals []RegexLiteral, newTerminals []RegexLiteral) []RegexLiteral {
+	for _, newTerminal := range newTerminals {
+		isFound := false
+		for _, terminal := range terminals {
+			if terminal.RegexString == newTerminal.RegexString {
+				isFound = true
+				break
+			}
+		}
+		if !isFound {
+			terminals = append(terminals, newTerminal)
+		}
+	}
+	return terminals
+}
+
+// the modes of an imported grammar are merged with the modes of the same name, modes aren't namespaced
+// since the terminals they refer to aren't either
+func mergeModes(output *Ast, imported *Ast) {
+	for _, importedMode := range imported.Modes {
+		mode := getMode(output, importedMode.Name, importedMode.Position)
+		mode.Terminals = appendTerminals(mode.Terminals, importedMode.Terminals)
+		mode.Pushes = appendTerminals(mode.Pushes, importedMode.Pushes)
+		mode.Pops = appendTerminals(mode.Pops, importedMode.Pops)
+		mode.IsRestricted = mode.IsRestricted || importedMode.IsRestricted
+	}
+}
@@ -10,6 +10,7 @@ type ParseErrorType int
 const (
 	EXPECTED_OPENING_CURLY_BRACKET ParseErrorType = iota
 	EXPECTED_CLOSING_CURLY_BRACKET
+	EXPECTED_CLOSING_BRACKET
 	EXPECTED_CLOSING_BRACKET_OR_COMMA
 	EXPECTED_ARG
 	EXPECTED_ARG_LIST
@@ -17,8 +18,19 @@ const (
 	EXPECTED_REPLACE_RULE
 	EXPECTED_MATCH_RULE
 	EXPECTED_RULE
-	MIXED_TYPE_MATCH_RULE
 	MULTIPLE_GLOBAL_LUA
+	NAMED_GROUP
+	EXPECTED_IMPORT_PATH
+	EXPECTED_IMPORT_ALIAS
+	IMPORT_NOT_FOUND
+	IMPORT_CYCLE
+	MULTIPLE_EXTENDS
+	EXPECTED_OVERRIDDEN_RULE
+	OVERRIDDEN_RULE_NOT_FOUND
+	EXPECTED_TERMINAL
+	EXPECTED_MODE_NAME
+	EXPECTED_COLLECT_RULE
+	EXTENDS_AFTER_RULES
 )
 
 type ParseError struct {
@@ -28,15 +40,7 @@ type ParseError struct {
 }
 
 func (e ParseError) Error() string {
-	return fmt.Sprintf("Parse error (%d, %d): %s", e.Position.Line, e.Position.Column, e.Message)
-}
-
-func ErrMixedTypeMatchRule(tokenizer *kuuhaku_tokenizer.Tokenizer) *ParseError {
-	return &ParseError{
-		Message:  "Mixing regex literals and variables inside one rule is not allowed",
-		Position: tokenizer.PrevPosition,
-		Type:     MIXED_TYPE_MATCH_RULE,
-	}
+	return fmt.Sprintf("Parse error (%s): %s", e.Position.Location(), e.Message)
 }
 
 func ErrExpectedOpeningCurlyBracket(tokenizer *kuuhaku_tokenizer.Tokenizer) *ParseError {
@@ -55,6 +59,14 @@ func ErrExpectedClosingBracketOrComma(tokenizer *kuuhaku_tokenizer.Tokenizer) *P
 	}
 }
 
+func ErrExpectedClosingBracket(tokenizer *kuuhaku_tokenizer.Tokenizer) *ParseError {
+	return &ParseError{
+		Message:  "Expected a closing bracket",
+		Position: tokenizer.PrevPosition,
+		Type:     EXPECTED_CLOSING_BRACKET,
+	}
+}
+
 func ErrExpectedClosingCurlyBracket(tokenizer *kuuhaku_tokenizer.Tokenizer) *ParseError {
 	return &ParseError{
 		Message:  "Expected a closing curly bracket",
@@ -103,9 +115,17 @@ func ErrExpectedReplaceRule(tokenizer *kuuhaku_tokenizer.Tokenizer) *ParseError
 	}
 }
 
+func ErrExpectedCollectRule(tokenizer *kuuhaku_tokenizer.Tokenizer) *ParseError {
+	return &ParseError{
+		Message:  "Expected a lua literal after COLLECT",
+		Position: tokenizer.PrevPosition,
+		Type:     EXPECTED_COLLECT_RULE,
+	}
+}
+
 func ErrExpectedGlobal(tokenizer *kuuhaku_tokenizer.Tokenizer) *ParseError {
 	return &ParseError{
-		Message:  "Expected a rule definition or a global lua literal",
+		Message:  "Expected a rule definition, a global lua literal or a directive",
 		Position: tokenizer.PrevPosition,
 		Type:     EXPECTED_RULE,
 	}
@@ -119,10 +139,34 @@ func ErrMultipleGlobalLua(tokenizer *kuuhaku_tokenizer.Tokenizer) *ParseError {
 	}
 }
 
+func ErrExpectedTerminal(tokenizer *kuuhaku_tokenizer.Tokenizer) *ParseError {
+	return &ParseError{
+		Message:  "Expected a regex literal or a closing curly bracket",
+		Position: tokenizer.PrevPosition,
+		Type:     EXPECTED_TERMINAL,
+	}
+}
+
+func ErrNamedGroup(position kuuhaku_tokenizer.Position) *ParseError {
+	return &ParseError{
+		Message:  "Only repeated groups can be named, name the match rules inside the group instead",
+		Position: position,
+		Type:     NAMED_GROUP,
+	}
+}
+
 type Parser struct {
-	tokenizer kuuhaku_tokenizer.Tokenizer
-	GlobalLua *LuaLiteral
-	Errors    []error
+	tokenizer       kuuhaku_tokenizer.Tokenizer
+	GlobalLua       *LuaLiteral
+	Errors          []error
+	hiddenRuleCount int
+	ruleCount       int //the rule definitions consumed so far, unlike the order it doesn't count desugared rules
+	file            string //the path of the grammar, empty if it's not from a file
+	searchPaths     []string
+	imports         *importState
+	baseRules       map[string]bool //rules of the base grammar that haven't been defined again, nil without EXTENDS
+	replacedOrders  map[string]int  //the order the next alternative of a base rule defined again is inserted at
+	isRuleDefined   bool
 }
 
 func Parse(input string) (Ast, []error) {
@@ -135,6 +179,7 @@ func initParser(input string) Parser {
 	return Parser{
 		tokenizer: kuuhaku_tokenizer.Init(input),
 		Errors:    []error{},
+		imports:   newImportState(),
 	}
 }
 
@@ -148,20 +193,7 @@ func (parser *Parser) consumeInput() *Ast {
 	output.IsSearchMode = parser.consumeSearchMode()
 	orderCounter := 0
 
-	rule := parser.consumeRule()
-	if rule != nil {
-		rule.Order = orderCounter
-		output.Rules[rule.Name] = append(output.Rules[rule.Name], rule)
-	} else {
-		globalLua := parser.consumeGlobalLua()
-		if globalLua != nil {
-			orderCounter -= 1
-			output.GlobalLua = globalLua
-		} else {
-			parser.Errors = append(parser.Errors, ErrExpectedGlobal(&parser.tokenizer))
-			parser.tokenizer.Next()
-		}
-	}
+	parser.consumeGlobal(&output, &orderCounter)
 
 	token, err := parser.tokenizer.Peek()
 	if err != nil {
@@ -169,21 +201,7 @@ func (parser *Parser) consumeInput() *Ast {
 		parser.tokenizer.Next()
 	}
 	for token == nil || token.Type != kuuhaku_tokenizer.EOF {
-		orderCounter += 1
-		rule := parser.consumeRule()
-		if rule != nil {
-			rule.Order = orderCounter
-			output.Rules[rule.Name] = append(output.Rules[rule.Name], rule)
-		} else {
-			globalLua := parser.consumeGlobalLua()
-			if globalLua != nil {
-				orderCounter -= 1
-				output.GlobalLua = globalLua
-			} else {
-				parser.Errors = append(parser.Errors, ErrExpectedGlobal(&parser.tokenizer))
-				parser.tokenizer.Next()
-			}
-		}
+		parser.consumeGlobal(&output, &orderCounter)
 		token, err = parser.tokenizer.Peek()
 		if err != nil {
 			parser.Errors = append(parser.Errors, err)
@@ -194,6 +212,128 @@ func (parser *Parser) consumeInput() *Ast {
 	return &output
 }
 
+// consumes one top level construct: a rule definition, the global lua literal or a directive
+func (parser *Parser) consumeGlobal(output *Ast, orderCounter *int) {
+	if parser.consumeDirective(output, orderCounter) {
+		return
+	}
+
+	rule := parser.consumeRule()
+	if rule != nil {
+		parser.isRuleDefined = true
+		rule.SourceOrder = parser.ruleCount
+		parser.ruleCount += 1
+		order, ok := parser.replaceBaseRule(output, rule.Name)
+		if ok {
+			parser.insertRules(output, rule.Name, parser.desugarRule(rule), order, orderCounter)
+			return
+		}
+		for _, desugared := range parser.desugarRule(rule) {
+			desugared.Order = *orderCounter
+			*orderCounter += 1
+			output.Rules[desugared.Name] = append(output.Rules[desugared.Name], desugared)
+		}
+		return
+	}
+
+	globalLua := parser.consumeGlobalLua()
+	if globalLua != nil {
+		appendGlobalLua(output, globalLua)
+		return
+	}
+
+	parser.Errors = append(parser.Errors, ErrExpectedGlobal(&parser.tokenizer))
+	parser.tokenizer.Next()
+}
+
+func (parser *Parser) consumeDirective(output *Ast, orderCounter *int) bool {
+	directive := parser.peekDirective()
+	switch directive {
+	case kuuhaku_tokenizer.GLR_MODE_KEYWORD:
+		parser.tokenizer.Next()
+		output.IsGLRMode = true
+		return true
+	case kuuhaku_tokenizer.NODE_MODE_KEYWORD:
+		parser.tokenizer.Next()
+		output.IsNodeMode = true
+		return true
+	case kuuhaku_tokenizer.PREFER_KEYWORD:
+		parser.tokenizer.Next()
+		preferences := parser.consumeParamList()
+		if preferences == nil {
+			parser.Errors = append(parser.Errors, ErrExpectedArgList(&parser.tokenizer))
+			return true
+		}
+		output.Preferences = append(output.Preferences, *preferences...)
+		return true
+	case kuuhaku_tokenizer.IMPORT_KEYWORD:
+		parser.tokenizer.Next()
+		parser.consumeImport(output, orderCounter)
+		return true
+	case kuuhaku_tokenizer.EXTENDS_KEYWORD:
+		parser.tokenizer.Next()
+		parser.consumeExtends(output, orderCounter)
+		return true
+	case kuuhaku_tokenizer.OVERRIDE_KEYWORD:
+		parser.tokenizer.Next()
+		parser.consumeOverride(output)
+		return true
+	case kuuhaku_tokenizer.IGNORE_KEYWORD:
+		parser.tokenizer.Next()
+		parser.consumeIgnore(output)
+		return true
+	case kuuhaku_tokenizer.LONGEST_MATCH_KEYWORD:
+		parser.tokenizer.Next()
+		parser.consumeLongestMatch(output)
+		return true
+	case kuuhaku_tokenizer.FIRST_MATCH_KEYWORD:
+		parser.tokenizer.Next()
+		terminals, ok := parser.consumeTerminalList()
+		if ok {
+			output.FirstMatchTerminals = appendTerminals(output.FirstMatchTerminals, terminals)
+		}
+		return true
+	case kuuhaku_tokenizer.MODE_KEYWORD, kuuhaku_tokenizer.PUSH_KEYWORD, kuuhaku_tokenizer.POP_KEYWORD:
+		parser.tokenizer.Next()
+		parser.consumeMode(output, directive)
+		return true
+	}
+	return false
+}
+
+// the type of the directive that starts at the current token, IDENTIFIER if there is none. A directive
+// keyword followed by the { or the parameter list of a rule definition is the name of the rule instead,
+// except in IGNORE {, LONGEST_MATCH {, FIRST_MATCH { and PREFER ( which always start the directive
+func (parser *Parser) peekDirective() kuuhaku_tokenizer.TokenType {
+	token, err := parser.tokenizer.Peek()
+	if err != nil || token.Type != kuuhaku_tokenizer.IDENTIFIER {
+		return kuuhaku_tokenizer.IDENTIFIER
+	}
+	directive, ok := kuuhaku_tokenizer.DirectiveKeywords[token.Content]
+	if !ok {
+		return kuuhaku_tokenizer.IDENTIFIER
+	}
+
+	tokenizerState := parser.tokenizer
+	next, err := parser.tokenizer.Next()
+	parser.tokenizer = tokenizerState
+	if err != nil {
+		return directive
+	}
+	switch next.Type {
+	case kuuhaku_tokenizer.OPENING_CURLY_BRACKET:
+		if directive == kuuhaku_tokenizer.IGNORE_KEYWORD || directive == kuuhaku_tokenizer.LONGEST_MATCH_KEYWORD || directive == kuuhaku_tokenizer.FIRST_MATCH_KEYWORD {
+			return directive
+		}
+		return kuuhaku_tokenizer.IDENTIFIER
+	case kuuhaku_tokenizer.OPENING_BRACKET:
+		if directive == kuuhaku_tokenizer.PREFER_KEYWORD {
+			return directive
+		}
+		return kuuhaku_tokenizer.IDENTIFIER
+	}
+	return directive
+}
 
 func (parser *Parser) consumeSearchMode() bool {
 	token, err := parser.tokenizer.Peek()
@@ -255,6 +395,9 @@ func (parser *Parser) consumeRule() *Rule {
 	parser.tokenizer.Next()
 
 	matchRules := parser.consumeMatchRules()
+	if matchRules == nil && parser.isEmptyRuleAhead() {
+		matchRules = &[]MatchRule{}
+	}
 	if matchRules == nil {
 		parser.Errors = append(parser.Errors, ErrExpectedMatchRules(&parser.tokenizer))
 		parser.panicTillToken(kuuhaku_tokenizer.CLOSING_CURLY_BRACKET)
@@ -265,80 +408,85 @@ func (parser *Parser) consumeRule() *Rule {
 		}
 	}
 
+	rule := &Rule{
+		Name:       name,
+		MatchRules: *matchRules,
+		Position:   position,
+		ArgList:    paramList,
+	}
 	token, err = parser.tokenizer.Peek()
 	if err != nil {
 		parser.panicTillToken(kuuhaku_tokenizer.CLOSING_CURLY_BRACKET)
-		return &Rule{
-			Name:       name,
-			MatchRules: *matchRules,
-			Position:   position,
-			ArgList:    paramList,
-		}
+		return rule
 	}
-	if token.Type == kuuhaku_tokenizer.CLOSING_CURLY_BRACKET {
+	if token.Type == kuuhaku_tokenizer.EQUAL_SIGN {
 		parser.tokenizer.Next()
-		return &Rule{
-			Name:       name,
-			MatchRules: *matchRules,
-			Position:   position,
-			ArgList:    paramList,
+		rule.ReplaceRule = parser.consumeLuaLiteral()
+		if rule.ReplaceRule == nil {
+			parser.Errors = append(parser.Errors, ErrExpectedReplaceRule(&parser.tokenizer))
+			parser.panicTillToken(kuuhaku_tokenizer.CLOSING_CURLY_BRACKET)
+			return rule
 		}
-	}
-	if token.Type != kuuhaku_tokenizer.EQUAL_SIGN {
+		token, err = parser.tokenizer.Peek()
+		if err != nil {
+			parser.panicTillToken(kuuhaku_tokenizer.CLOSING_CURLY_BRACKET)
+			return rule
+		}
+	} else if token.Type != kuuhaku_tokenizer.CLOSING_CURLY_BRACKET && !isCollectKeyword(token) {
 		parser.Errors = append(parser.Errors, ErrExpectedEqualSign(&parser.tokenizer))
 		parser.panicTillToken(kuuhaku_tokenizer.CLOSING_CURLY_BRACKET)
-		return &Rule{
-			Name:       name,
-			MatchRules: *matchRules,
-			Position:   position,
-			ArgList:    paramList,
-		}
+		return rule
 	}
-	parser.tokenizer.Next()
 
-	replaceRule := parser.consumeLuaLiteral()
-	if replaceRule == nil {
-		parser.Errors = append(parser.Errors, ErrExpectedReplaceRule(&parser.tokenizer))
-		parser.panicTillToken(kuuhaku_tokenizer.CLOSING_CURLY_BRACKET)
-		return &Rule{
-			Name:       name,
-			MatchRules: *matchRules,
-			Position:   position,
-			ArgList:    paramList,
+	if isCollectKeyword(token) {
+		parser.tokenizer.Next()
+		rule.CollectRule = parser.consumeLuaLiteral()
+		if rule.CollectRule == nil {
+			parser.Errors = append(parser.Errors, ErrExpectedCollectRule(&parser.tokenizer))
+			parser.panicTillToken(kuuhaku_tokenizer.CLOSING_CURLY_BRACKET)
+			return rule
 		}
-	}
-
-	token, err = parser.tokenizer.Peek()
-	if err != nil {
-		parser.panicTillToken(kuuhaku_tokenizer.CLOSING_CURLY_BRACKET)
-		return &Rule{
-			Name:        name,
-			MatchRules:  *matchRules,
-			ReplaceRule: replaceRule,
-			Position:    position,
-			ArgList:     paramList,
+		token, err = parser.tokenizer.Peek()
+		if err != nil {
+			parser.panicTillToken(kuuhaku_tokenizer.CLOSING_CURLY_BRACKET)
+			return rule
 		}
 	}
+
 	if token.Type != kuuhaku_tokenizer.CLOSING_CURLY_BRACKET {
 		parser.Errors = append(parser.Errors, ErrExpectedClosingCurlyBracket(&parser.tokenizer))
 		parser.panicTillToken(kuuhaku_tokenizer.CLOSING_CURLY_BRACKET)
-		return &Rule{
-			Name:        name,
-			MatchRules:  *matchRules,
-			ReplaceRule: replaceRule,
-			Position:    position,
-			ArgList:     paramList,
-		}
+		return rule
 	}
 	parser.tokenizer.Next()
+	return rule
+}
 
-	return &Rule{
-		Name:        name,
-		MatchRules:  *matchRules,
-		ReplaceRule: replaceRule,
-		Position:    position,
-		ArgList:     paramList,
+// a rule without match rules matches the empty string, e.g. Opt { }, Opt { = `"default"` } or
+// Opt { COLLECT ``...`` }
+func (parser *Parser) isEmptyRuleAhead() bool {
+	token, err := parser.tokenizer.Peek()
+	if err != nil {
+		return false
 	}
+	return token.Type == kuuhaku_tokenizer.CLOSING_CURLY_BRACKET || token.Type == kuuhaku_tokenizer.EQUAL_SIGN || parser.isCollectAhead()
+}
+
+func isCollectKeyword(token *kuuhaku_tokenizer.Token) bool {
+	return token.Type == kuuhaku_tokenizer.IDENTIFIER && token.Content == "COLLECT"
+}
+
+// among the match rules COLLECT is only a keyword when a lua literal or the end of the rule follows it,
+// otherwise it's the name of a rule
+func (parser *Parser) isCollectAhead() bool {
+	token, err := parser.tokenizer.Peek()
+	if err != nil || !isCollectKeyword(token) {
+		return false
+	}
+	tokenizerState := parser.tokenizer
+	next, err := parser.tokenizer.Next()
+	parser.tokenizer = tokenizerState
+	return err == nil && (next.Type == kuuhaku_tokenizer.LUA_LITERAL || next.Type == kuuhaku_tokenizer.LUA_RETURN_LITERAL || next.Type == kuuhaku_tokenizer.CLOSING_CURLY_BRACKET)
 }
 
 func (parser *Parser) panicTillToken(tokenType kuuhaku_tokenizer.TokenType) {
@@ -357,43 +505,175 @@ func (parser *Parser) panicTillToken(tokenType kuuhaku_tokenizer.TokenType) {
 
 func (parser *Parser) consumeMatchRules() *[]MatchRule {
 	var output []MatchRule
-	doesRegexLiteralExist := false
 
-	ok, isRegexLiteral := parser.consumeToMatchRuleArray(&output)
+	ok := parser.consumeToMatchRuleArray(&output)
 	if !ok {
 		return nil
 	}
-	if isRegexLiteral {
-		doesRegexLiteralExist = true
+	for ok {
+		ok = parser.consumeToMatchRuleArray(&output)
 	}
 
-	for ok {
-		//TODO: Debug why this happens
-		tokenizer := parser.tokenizer
-		ok, isRegexLiteral = parser.consumeToMatchRuleArray(&output)
-		if ok {
-			if (!isRegexLiteral && doesRegexLiteralExist) || (isRegexLiteral && !doesRegexLiteralExist) {
-				parser.Errors = append(parser.Errors, ErrMixedTypeMatchRule(&tokenizer))
+	return &output
+}
+
+func (parser *Parser) consumeToMatchRuleArray(matchRuleArray *[]MatchRule) bool {
+	if parser.isCollectAhead() {
+		return false
+	}
+	name := parser.consumeName()
+	matchRule := parser.consumeMatchRuleOperand()
+	if matchRule == nil {
+		if name != nil {
+			parser.Errors = append(parser.Errors, ErrExpectedMatchRules(&parser.tokenizer))
+		}
+		return false
+	}
+
+	quantifier := parser.consumeQuantifier()
+	if quantifier != kuuhaku_tokenizer.EOF {
+		group, isGroup := matchRule.(ebnfMatchRule)
+		if isGroup {
+			group.Quantifier = quantifier
+			matchRule = group
+		} else {
+			matchRule = ebnfMatchRule{
+				Items:      []MatchRule{matchRule},
+				Quantifier: quantifier,
+				Position:   matchRule.GetPosition(),
 			}
 		}
 	}
 
-	return &output
+	if name != nil {
+		matchRule = parser.nameMatchRule(matchRule, *name)
+	}
+
+	*matchRuleArray = append(*matchRuleArray, matchRule)
+	return true
+}
+
+// a name followed by a colon names the next match rule
+func (parser *Parser) consumeName() *Identifier {
+	tokenizer := parser.tokenizer
+	token, err := tokenizer.Peek()
+	if err != nil || token.Type != kuuhaku_tokenizer.IDENTIFIER {
+		return nil
+	}
+	colon, err := tokenizer.Next()
+	if err != nil || colon.Type != kuuhaku_tokenizer.COLON {
+		return nil
+	}
+	parser.tokenizer = tokenizer
+	parser.tokenizer.Next()
+	return &Identifier{
+		Name:     token.Content,
+		Position: token.Position,
+	}
 }
 
-// returns (ok bool, isRegexLiteral bool) 
-func (parser *Parser) consumeToMatchRuleArray(matchRuleArray *[]MatchRule) (bool, bool) {
+// sets the binding of the match rule, the binding counter leaves bindings that are already set alone
+func (parser *Parser) nameMatchRule(matchRule MatchRule, name Identifier) MatchRule {
+	switch m := matchRule.(type) {
+	case Identifier:
+		m.Binding = name.Name
+		return m
+	case RegexLiteral:
+		m.Binding = name.Name
+		return m
+	case ebnfMatchRule:
+		if m.IsGroup && !isRepetition(m.Quantifier) {
+			parser.Errors = append(parser.Errors, ErrNamedGroup(name.Position))
+			return m
+		}
+		m.Binding = name.Name
+		if !m.IsGroup {
+			m.Items = []MatchRule{parser.nameMatchRule(m.Items[0], name)}
+		}
+		return m
+	}
+	return matchRule
+}
+
+func (parser *Parser) consumeMatchRuleOperand() MatchRule {
 	identifier := parser.consumeIdentifier()
 	if identifier != nil {
-		*matchRuleArray = append(*matchRuleArray, *identifier)
-		return true, false
+		return *identifier
 	}
 	regexLit := parser.consumeRegexLiteral()
 	if regexLit != nil {
-		*matchRuleArray = append(*matchRuleArray, *regexLit)
-		return true, true
+		return *regexLit
+	}
+	group := parser.consumeGroup()
+	if group != nil {
+		return *group
+	}
+	return nil
+}
+
+func (parser *Parser) consumeGroup() *ebnfMatchRule {
+	token, err := parser.tokenizer.Peek()
+	if err != nil {
+		parser.tokenizer.Next()
+		parser.Errors = append(parser.Errors, err)
+		return nil
+	}
+	if token.Type != kuuhaku_tokenizer.OPENING_BRACKET {
+		return nil
+	}
+	position := token.Position
+	parser.tokenizer.Next()
+
+	matchRules := parser.consumeMatchRules()
+	if matchRules == nil {
+		parser.Errors = append(parser.Errors, ErrExpectedMatchRules(&parser.tokenizer))
+		return nil
+	}
+
+	token, err = parser.tokenizer.Peek()
+	if err != nil {
+		parser.tokenizer.Next()
+		parser.Errors = append(parser.Errors, err)
+		return nil
+	}
+	if token.Type != kuuhaku_tokenizer.CLOSING_BRACKET {
+		parser.Errors = append(parser.Errors, ErrExpectedClosingBracket(&parser.tokenizer))
+		return nil
+	}
+	parser.tokenizer.Next()
+
+	return &ebnfMatchRule{
+		Items:      *matchRules,
+		IsGroup:    true,
+		Quantifier: kuuhaku_tokenizer.EOF,
+		Position:   position,
 	}
-	return false, false
+}
+
+// returns EOF if there's no quantifier
+func (parser *Parser) consumeQuantifier() kuuhaku_tokenizer.TokenType {
+	token, err := parser.tokenizer.Peek()
+	if err != nil {
+		return kuuhaku_tokenizer.EOF
+	}
+	switch token.Type {
+	case kuuhaku_tokenizer.QUESTION_MARK, kuuhaku_tokenizer.ASTERISK, kuuhaku_tokenizer.PLUS_SIGN:
+		parser.tokenizer.Next()
+		return token.Type
+	}
+	return kuuhaku_tokenizer.EOF
+}
+
+// an opening bracket after an identifier either starts its argument list or a group, arguments are
+// always lua literals
+func (parser *Parser) isArgListAhead() bool {
+	tokenizer := parser.tokenizer
+	token, err := tokenizer.Peek()
+	if err != nil || token.Type != kuuhaku_tokenizer.OPENING_BRACKET {
+		return true
+	}
+	token, err = tokenizer.Next()
+	return err != nil || token.Type == kuuhaku_tokenizer.LUA_LITERAL || token.Type == kuuhaku_tokenizer.LUA_RETURN_LITERAL
 }
 
 func (parser *Parser) consumeIdentifier() *Identifier {
@@ -407,9 +687,11 @@ func (parser *Parser) consumeIdentifier() *Identifier {
 		parser.tokenizer.Next()
 
 		var argList []LuaLiteral
-		argListP := parser.consumeArgList()
-		if argListP != nil {
-			argList = *argListP
+		if parser.isArgListAhead() {
+			argListP := parser.consumeArgList()
+			if argListP != nil {
+				argList = *argListP
+			}
 		}
 
 		return &Identifier{
@@ -459,6 +741,38 @@ func (parser *Parser) consumeRegexLiteral() *RegexLiteral {
 	}
 }
 
+// { <regex> <regex> ... }, the list of terminals taken by IGNORE and the lexer mode directives
+func (parser *Parser) consumeTerminalList() ([]RegexLiteral, bool) {
+	token, err := parser.tokenizer.Peek()
+	if err != nil {
+		parser.tokenizer.Next()
+		parser.Errors = append(parser.Errors, err)
+		return nil, false
+	}
+	if token.Type != kuuhaku_tokenizer.OPENING_CURLY_BRACKET {
+		parser.Errors = append(parser.Errors, ErrExpectedOpeningCurlyBracket(&parser.tokenizer))
+		return nil, false
+	}
+	parser.tokenizer.Next()
+
+	var terminals []RegexLiteral
+	for {
+		regexLiteral := parser.consumeRegexLiteral()
+		if regexLiteral != nil {
+			terminals = append(terminals, *regexLiteral)
+			continue
+		}
+		token, err := parser.tokenizer.Peek()
+		if err == nil && token.Type == kuuhaku_tokenizer.CLOSING_CURLY_BRACKET {
+			parser.tokenizer.Next()
+			return terminals, true
+		}
+		parser.Errors = append(parser.Errors, ErrExpectedTerminal(&parser.tokenizer))
+		parser.panicTillToken(kuuhaku_tokenizer.CLOSING_CURLY_BRACKET)
+		return nil, false
+	}
+}
+
 //Params are the parameters in the function declaration
 func (parser *Parser) consumeParamList() *[]Identifier {
 	var argList []Identifier
@@ -2,7 +2,10 @@ package kuuhaku_parser
 
 import (
 	"errors"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/ciii1/kuuhaku/internal/helper"
@@ -223,48 +226,33 @@ func TestConsumeMatchRules2(t *testing.T) {
 	}
 }
 
-func TestConsumeMatchRulesError(t *testing.T) {
-	parser := initParser("hello hello2 <2> hello2=")
-	parser.consumeMatchRules()
-	if len(parser.Errors) != 1 {
-		println("Expected parser errors length to be 1")
+func TestConsumeMixedMatchRules(t *testing.T) {
+	parser := initParser("hello <\\(> hello2 <\\)>=")
+	matchRulesP := parser.consumeMatchRules()
+	if len(parser.Errors) != 0 {
+		println("Expected parser errors length to be 0")
+		helper.DisplayAllErrors(parser.Errors)
+		t.Fatal()
+	}
+	matchRules := *matchRulesP
+	if len(matchRules) != 4 {
+		println("Expected matchRules length to be 4, got " + strconv.Itoa(len(matchRules)))
+		t.Fatal()
+	}
+	if _, ok := matchRules[0].(Identifier); !ok {
+		println("Expected matchRules[0] to be an identifier")
 		t.Fail()
 	}
-	var parseError *ParseError
-	if errors.As(parser.Errors[0], &parseError) {
-		if parseError.Type != MIXED_TYPE_MATCH_RULE {
-			println("Expected ErrMixedTypeMatchRule error")
-			t.Fail()
-		}
-		if parseError.Position.Column != 14 || parseError.Position.Line != 1 {
-			println("Expected error position to be (1, 14), got (" + strconv.Itoa(parseError.Position.Line) + "," + strconv.Itoa(parseError.Position.Column))
-			t.Fail()
-		}
-	} else {
-		println("Expected ParseError")
+	if node, ok := matchRules[1].(RegexLiteral); !ok || node.RegexString != "\\(" {
+		println("Expected matchRules[1] to be the regex literal \\(")
 		t.Fail()
 	}
-}
-
-func TestConsumeMatchRulesError2(t *testing.T) {
-	parser := initParser("<2>hello=")
-	parser.consumeMatchRules()
-	if len(parser.Errors) != 1 {
-		println("Expected parser errors length to be 1")
+	if _, ok := matchRules[2].(Identifier); !ok {
+		println("Expected matchRules[2] to be an identifier")
 		t.Fail()
 	}
-	var parseError *ParseError
-	if errors.As(parser.Errors[0], &parseError) {
-		if parseError.Type != MIXED_TYPE_MATCH_RULE {
-			println("Expected ErrMixedTypeMatchRule error")
-			t.Fail()
-		}
-		if parseError.Position.Column != 4 || parseError.Position.Line != 1 {
-			println("Expected error position to be (1, 4), got (" + strconv.Itoa(parseError.Position.Line) + "," + strconv.Itoa(parseError.Position.Column))
-			t.Fail()
-		}
-	} else {
-		println("Expected ParseError")
+	if node, ok := matchRules[3].(RegexLiteral); !ok || node.RegexString != "\\)" {
+		println("Expected matchRules[3] to be the regex literal \\)")
 		t.Fail()
 	}
 }
@@ -321,7 +309,7 @@ func TestConsumeRule(t *testing.T) {
 func TestErrorConsumeRule(t *testing.T) {
 	parser := initParser("test{``test2``=<test>}\nanotherTest{}")
 	parser.consumeRule()
-	parser.consumeRule()
+	emptyRule := parser.consumeRule()
 	token, _ := parser.tokenizer.Next()
 	if token.Type != kuuhaku_tokenizer.EOF {
 		println("Expected the parser to reach EOF, got token with content " + token.Content)
@@ -344,13 +332,13 @@ func TestErrorConsumeRule(t *testing.T) {
 		t.Fail()
 	}
 
-	if errors.As(parser.Errors[1], &parseError) {
-		if parseError.Type != EXPECTED_MATCH_RULE {
-			println("Expected ExpectedMatchRuleError error")
-			t.Fail()
-		}
-	} else {
-		println("Expected ParseError")
+	// a rule without match rules is an empty rule, not an error
+	if len(parser.Errors) != 1 {
+		println("Expected 1 error, got " + strconv.Itoa(len(parser.Errors)))
+		t.Fail()
+	}
+	if emptyRule == nil || emptyRule.Name != "anotherTest" || len(emptyRule.MatchRules) != 0 {
+		println("Expected anotherTest to be an empty rule")
 		t.Fail()
 	}
 }
@@ -727,3 +715,526 @@ func TestErrorTokenizeError(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestConsumeGLRModeAndPreferences(t *testing.T) {
+	parser := initParser("GLR_MODE\nPREFER(Call, Decl)\ntest{Call}\nCall{<a>}\nDecl{<a>}")
+	ast := parser.consumeInput()
+
+	if len(parser.Errors) != 0 {
+		println("Expected len(parser.Errors) to be 0")
+		helper.DisplayAllErrors(parser.Errors)
+		t.Fatal()
+	}
+
+	if ast.IsGLRMode != true {
+		println("Expected ast.IsGLRMode to be true")
+		t.Fail()
+	}
+
+	if len(ast.Preferences) != 2 || ast.Preferences[0].Name != "Call" || ast.Preferences[1].Name != "Decl" {
+		println("Expected the preferences to be Call, Decl")
+		t.Fail()
+	}
+
+	if ast.Rules["Call"][0].Order != 1 {
+		println("Expected directives to not take a rule order, got " + strconv.Itoa(ast.Rules["Call"][0].Order))
+		t.Fail()
+	}
+}
+
+func TestConsumeNodeMode(t *testing.T) {
+	parser := initParser("NODE_MODE\ntest{<a>}")
+	ast := parser.consumeInput()
+
+	if len(parser.Errors) != 0 {
+		println("Expected len(parser.Errors) to be 0")
+		helper.DisplayAllErrors(parser.Errors)
+		t.Fatal()
+	}
+
+	if ast.IsNodeMode != true {
+		println("Expected ast.IsNodeMode to be true")
+		t.Fail()
+	}
+
+	if ast.IsGLRMode != false {
+		println("Expected ast.IsGLRMode to be false")
+		t.Fail()
+	}
+}
+
+func TestConsumeDirectiveNames(t *testing.T) {
+	parser := initParser("S{AS MODE COLLECT GLR_MODE}\nAS{<a>}\nMODE{<b>}\nCOLLECT{<c>}\nGLR_MODE(x){<d>}\nNODE_MODE S2{<e>}")
+	ast := parser.consumeInput()
+
+	if len(parser.Errors) != 0 {
+		println("Expected len(parser.Errors) to be 0")
+		helper.DisplayAllErrors(parser.Errors)
+		t.Fatal()
+	}
+
+	for _, name := range []string{"AS", "MODE", "COLLECT", "GLR_MODE"} {
+		if len(ast.Rules[name]) != 1 {
+			println("Expected " + name + " to be a rule")
+			t.Fail()
+		}
+	}
+	if len(ast.Rules["S"][0].MatchRules) != 4 {
+		println("Expected S to have 4 match rules, got " + strconv.Itoa(len(ast.Rules["S"][0].MatchRules)))
+		t.Fail()
+	}
+	if ast.IsGLRMode || !ast.IsNodeMode {
+		println("Expected only NODE_MODE to be a directive")
+		t.Fail()
+	}
+}
+
+func TestConsumeCollectRule(t *testing.T) {
+	parser := initParser("test{A = `A1` COLLECT ``COLLECTED.a = A1``}\nA{<a> COLLECT `nil`}\nB{<b>}")
+	ast := parser.consumeInput()
+
+	if len(parser.Errors) != 0 {
+		println("Expected len(parser.Errors) to be 0")
+		helper.DisplayAllErrors(parser.Errors)
+		t.Fatal()
+	}
+
+	rule := ast.Rules["test"][0]
+	if rule.ReplaceRule == nil || rule.ReplaceRule.LuaString != "return A1" {
+		println("Expected the replace rule of test to be kept")
+		t.Fail()
+	}
+	if rule.CollectRule == nil || rule.CollectRule.LuaString != "COLLECTED.a = A1" {
+		println("Expected the collect rule of test to be COLLECTED.a = A1")
+		t.Fail()
+	}
+
+	rule = ast.Rules["A"][0]
+	if rule.ReplaceRule != nil || rule.CollectRule == nil || rule.CollectRule.LuaString != "return nil" {
+		println("Expected A to have only a collect rule")
+		t.Fail()
+	}
+
+	if ast.Rules["B"][0].CollectRule != nil {
+		println("Expected B to have no collect rule")
+		t.Fail()
+	}
+
+	parser = initParser("test{ COLLECT ``COLLECTED.empty = true`` }")
+	ast = parser.consumeInput()
+	if len(parser.Errors) != 0 {
+		println("Expected len(parser.Errors) to be 0")
+		helper.DisplayAllErrors(parser.Errors)
+		t.Fatal()
+	}
+	rule = ast.Rules["test"][0]
+	if len(rule.MatchRules) != 0 || rule.CollectRule == nil {
+		println("Expected an empty alternative with a collect rule")
+		t.Fail()
+	}
+
+	parser = initParser("test{<a> = `1` COLLECT}")
+	parser.consumeInput()
+	var parseError *ParseError
+	if len(parser.Errors) == 0 || !errors.As(parser.Errors[0], &parseError) || parseError.Type != EXPECTED_COLLECT_RULE {
+		println("Expected ExpectedCollectRuleError error")
+		t.Fail()
+	}
+
+	parser = initParser("test{<a> COLLECT}")
+	parser.consumeInput()
+	if len(parser.Errors) == 0 || !errors.As(parser.Errors[0], &parseError) || parseError.Type != EXPECTED_COLLECT_RULE {
+		println("Expected ExpectedCollectRuleError error for a bare COLLECT")
+		t.Fail()
+	}
+}
+
+func TestConsumeEBNF(t *testing.T) {
+	parser := initParser("test{B(`1`)? (C D)* = `x`}")
+	ast := parser.consumeInput()
+
+	if len(parser.Errors) != 0 {
+		println("Expected len(parser.Errors) to be 0")
+		helper.DisplayAllErrors(parser.Errors)
+		t.Fatal()
+	}
+
+	rules := ast.Rules["test"]
+	if len(rules) != 4 {
+		println("Expected test to be expanded into 4 alternatives, got " + strconv.Itoa(len(rules)))
+		t.Fatal()
+	}
+	if len(rules[0].MatchRules) != 2 || len(rules[1].MatchRules) != 1 || len(rules[2].MatchRules) != 1 || len(rules[3].MatchRules) != 0 {
+		println("Expected the alternatives to be (B list), (B), (list) and ()")
+		t.Fatal()
+	}
+	identifier, ok := rules[0].MatchRules[0].(Identifier)
+	if !ok || identifier.Name != "B" || identifier.Binding != "B1" || len(identifier.ArgList) != 1 {
+		println("Expected test[0].MatchRules[0] to be B(`1`) bound to B1")
+		t.Fail()
+	}
+	list, ok := rules[0].MatchRules[1].(Identifier)
+	if !ok || list.Name != "test#2" || list.Binding != "GROUP1" {
+		println("Expected test[0].MatchRules[1] to be test#2 bound to GROUP1")
+		t.Fail()
+	}
+	if len(rules[1].AbsentBindings) != 1 || rules[1].AbsentBindings[0].Name != "GROUP1" || !rules[1].AbsentBindings[0].IsList {
+		println("Expected test[1] to bind GROUP1 to an empty list")
+		t.Fail()
+	}
+	if len(rules[2].AbsentBindings) != 1 || rules[2].AbsentBindings[0].Name != "B1" || rules[2].AbsentBindings[0].IsList {
+		println("Expected test[2] to bind B1 to nil")
+		t.Fail()
+	}
+	if len(rules[3].AbsentBindings) != 2 {
+		println("Expected test[3] to bind both B1 and GROUP1")
+		t.Fail()
+	}
+	if rules[0].ReplaceRule != rules[2].ReplaceRule || rules[0].Order >= rules[1].Order {
+		println("Expected the alternatives to share the replace rule and to get their own order")
+		t.Fail()
+	}
+
+	listRules := ast.Rules["test#2"]
+	if len(listRules) != 2 || listRules[0].Hidden != HIDDEN_LIST || len(listRules[0].MatchRules) != 2 {
+		println("Expected test#2 to be a left recursive hidden list rule")
+		t.Fail()
+	}
+	groupRules := ast.Rules["test#1"]
+	if len(groupRules) != 1 || groupRules[0].Hidden != HIDDEN_GROUP {
+		println("Expected test#1 to be a hidden group rule")
+		t.Fatal()
+	}
+	if groupRules[0].MatchRules[0].(Identifier).Binding != "C1" || groupRules[0].MatchRules[1].(Identifier).Binding != "D1" {
+		println("Expected the group content to be bound to C1 and D1")
+		t.Fail()
+	}
+}
+
+func TestConsumeEBNFError(t *testing.T) {
+	parser := initParser("test{(A B = `x`}")
+	parser.consumeInput()
+
+	if len(parser.Errors) == 0 {
+		println("Expected an error for the unclosed group")
+		t.Fatal()
+	}
+	parseError, ok := parser.Errors[0].(*ParseError)
+	if !ok || parseError.Type != EXPECTED_CLOSING_BRACKET {
+		println("Expected the first error to be EXPECTED_CLOSING_BRACKET")
+		helper.DisplayAllErrors(parser.Errors)
+		t.Fail()
+	}
+}
+
+func TestConsumeIgnore(t *testing.T) {
+	parser := initParser("IGNORE { <\\s+> <#[^\\n]*> }\nIGNORE { <\\s+> }\ntest{<a>}")
+	ast := parser.consumeInput()
+
+	if len(parser.Errors) != 0 {
+		println("Expected len(parser.Errors) to be 0")
+		helper.DisplayAllErrors(parser.Errors)
+		t.Fatal()
+	}
+	if len(ast.Trivia) != 2 || ast.Trivia[0].RegexString != "\\s+" || ast.Trivia[1].RegexString != "#[^\\n]*" {
+		println("Expected the trivia to be <\\s+> and <#[^\\n]*>")
+		t.Fail()
+	}
+	if ast.Rules["test"][0].Order != 0 {
+		println("Expected IGNORE to not take a rule order")
+		t.Fail()
+	}
+
+	parser = initParser("IGNORE { test }\nIGNORE <a>\ntest{<a>}")
+	parser.consumeInput()
+	expected := []ParseErrorType{EXPECTED_TERMINAL, EXPECTED_OPENING_CURLY_BRACKET}
+	if len(parser.Errors) < len(expected) {
+		println("Expected at least " + strconv.Itoa(len(expected)) + " errors")
+		helper.DisplayAllErrors(parser.Errors)
+		t.Fatal()
+	}
+	for i, errorType := range expected {
+		parseError, ok := parser.Errors[i].(*ParseError)
+		if !ok || parseError.Type != errorType {
+			println("Unexpected error at index " + strconv.Itoa(i))
+			helper.DisplayAllErrors(parser.Errors)
+			t.Fail()
+		}
+	}
+}
+
+func TestConsumeLexerModes(t *testing.T) {
+	parser := initParser("MODE string { <[^\"]+> <\"> }\nPUSH string { <\"> }\nPOP string { <\"> }\nPUSH code { <\\$\\{> }\ntest{<a>}")
+	ast := parser.consumeInput()
+
+	if len(parser.Errors) != 0 {
+		println("Expected len(parser.Errors) to be 0")
+		helper.DisplayAllErrors(parser.Errors)
+		t.Fatal()
+	}
+	if len(ast.Modes) != 2 {
+		println("Expected 2 lexer modes, got " + strconv.Itoa(len(ast.Modes)))
+		t.Fatal()
+	}
+	str := ast.Modes[0]
+	if str.Name != "string" || !str.IsRestricted || len(str.Terminals) != 2 || len(str.Pushes) != 1 || len(str.Pops) != 1 {
+		println("Expected the string mode to have 2 terminals, 1 push and 1 pop")
+		t.Fail()
+	}
+	code := ast.Modes[1]
+	if code.Name != "code" || code.IsRestricted || len(code.Pushes) != 1 || code.Pushes[0].RegexString != "\\$\\{" {
+		println("Expected the code mode to be unrestricted and pushed by <\\$\\{>")
+		t.Fail()
+	}
+
+	parser = initParser("MODE <a> { <a> }\ntest{<a>}")
+	parser.consumeInput()
+	parseError, ok := parser.Errors[0].(*ParseError)
+	if !ok || parseError.Type != EXPECTED_MODE_NAME {
+		println("Expected EXPECTED_MODE_NAME")
+		helper.DisplayAllErrors(parser.Errors)
+		t.Fail()
+	}
+}
+
+func TestConsumeNamedMatchRules(t *testing.T) {
+	parser := initParser("test{key:IDENTIFIER w IDENTIFIER sep:<,>? items:(A B)*}")
+	ast := parser.consumeInput()
+
+	if len(parser.Errors) != 0 {
+		println("Expected len(parser.Errors) to be 0")
+		helper.DisplayAllErrors(parser.Errors)
+		t.Fatal()
+	}
+
+	matchRules := ast.Rules["test"][0].MatchRules
+	expected := []string{"key", "w1", "IDENTIFIER2", "sep", "items"}
+	if len(matchRules) != len(expected) {
+		println("Expected test[0] to have " + strconv.Itoa(len(expected)) + " match rules, got " + strconv.Itoa(len(matchRules)))
+		t.Fatal()
+	}
+	for i, binding := range expected {
+		if GetBinding(matchRules[i]) != binding {
+			println("Expected match rule " + strconv.Itoa(i) + " to be bound to " + binding + ", got " + GetBinding(matchRules[i]))
+			t.Fail()
+		}
+	}
+
+	parser = initParser("test{name:(A B)}")
+	parser.consumeInput()
+	if len(parser.Errors) != 1 {
+		println("Expected len(parser.Errors) to be 1")
+		t.Fatal()
+	}
+	parseError, ok := parser.Errors[0].(*ParseError)
+	if !ok || parseError.Type != NAMED_GROUP {
+		println("Expected a NAMED_GROUP error")
+		t.Fail()
+	}
+}
+
+func writeGrammars(t *testing.T, grammars map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range grammars {
+		path := filepath.Join(dir, name)
+		helper.Check(os.MkdirAll(filepath.Dir(path), 0755))
+		helper.Check(os.WriteFile(path, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestParseFileImport(t *testing.T) {
+	dir := writeGrammars(t, map[string]string{
+		"main.khk":       "IMPORT \"common/ws.khk\"\nIMPORT json AS j\nStart{j.Value w j.Value}",
+		"common/ws.khk":  "w{<\\s*>}",
+		"lib/json.khk":   "Value{<[0-9]+>}\nValue{<\\[> Value <\\]>}\n``function helper() end``",
+	})
+	ast, errs := ParseFile(filepath.Join(dir, "main.khk"), []string{filepath.Join(dir, "lib")})
+	if len(errs) != 0 {
+		println("Expected len(errs) to be 0")
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+
+	if len(ast.Rules["w"]) != 1 || !ast.Rules["w"][0].IsImported {
+		println("Expected w to be imported without a namespace")
+		t.Fail()
+	}
+	values := ast.Rules["j.Value"]
+	if len(values) != 2 || !values[0].IsImported {
+		println("Expected j.Value to be imported with 2 alternatives")
+		t.Fatal()
+	}
+	if values[1].MatchRules[1].(Identifier).Name != "j.Value" {
+		println("Expected the references inside the imported grammar to be namespaced")
+		t.Fail()
+	}
+	if len(ast.Rules["Value"]) != 0 {
+		println("Expected Value to only exist inside the namespace")
+		t.Fail()
+	}
+	if values[0].Position.File != filepath.Join(dir, "lib", "json.khk") {
+		println("Expected the imported positions to be in lib/json.khk, got " + values[0].Position.File)
+		t.Fail()
+	}
+
+	start := ast.Rules["Start"][0]
+	if start.IsImported || start.Order <= values[1].Order {
+		println("Expected Start to come after the imported rules")
+		t.Fail()
+	}
+	if GetBinding(start.MatchRules[0]) != "Value1" || GetBinding(start.MatchRules[2]) != "Value2" {
+		println("Expected namespaced identifiers to be bound without the namespace")
+		t.Fail()
+	}
+	if ast.GlobalLua == nil || ast.GlobalLua.LuaString != "function helper() end" {
+		println("Expected the global lua of the import to be kept")
+		t.Fail()
+	}
+}
+
+func TestParseFileImportErrors(t *testing.T) {
+	dir := writeGrammars(t, map[string]string{
+		"a.khk": "IMPORT \"b.khk\"\nA{<a>}",
+		"b.khk": "IMPORT \"a.khk\"\nB{<b> C{}\nIMPORT \"missing.khk\"",
+	})
+	_, errs := ParseFile(filepath.Join(dir, "a.khk"), nil)
+
+	var types []ParseErrorType
+	for _, err := range errs {
+		var parseError *ParseError
+		if !errors.As(err, &parseError) {
+			continue
+		}
+		types = append(types, parseError.Type)
+		if parseError.Position.File != filepath.Join(dir, "b.khk") {
+			println("Expected the error to be in b.khk, got " + parseError.Error())
+			t.Fail()
+		}
+	}
+	if len(types) != 3 || types[0] != IMPORT_CYCLE || types[2] != IMPORT_NOT_FOUND {
+		println("Expected an import cycle, a syntax error and a missing import error")
+		helper.DisplayAllErrors(errs)
+		t.Fail()
+	}
+}
+
+func TestParseFileExtends(t *testing.T) {
+	dir := writeGrammars(t, map[string]string{
+		"base.khk": "Start{Item+ = `table.concat(Item1, \" \")`}\n" +
+			"Item{Letter}\n" +
+			"Item{Digit}\n" +
+			"Letter{<[a-z]>}\n" +
+			"Digit{<[0-9]>}",
+		"child.khk": "EXTENDS base\n" +
+			"OVERRIDE Start{Item+ = `table.concat(Item1, \"-\")`}\n" +
+			"Item{Letter = `string.upper(Letter1)`}",
+	})
+	ast, errs := ParseFile(filepath.Join(dir, "child.khk"), nil)
+	if len(errs) != 0 {
+		println("Expected len(errs) to be 0")
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+
+	if ast.Rules["Start"][0].IsImported || !ast.Rules["Digit"][0].IsImported {
+		println("Expected Start to stay a start symbol and Digit to be treated as imported")
+		t.Fail()
+	}
+	if ast.Rules["Start"][0].ReplaceRule.LuaString != "return table.concat(Item1, \"-\")" {
+		println("Expected the replace rule of Start to be overridden, got " + ast.Rules["Start"][0].ReplaceRule.LuaString)
+		t.Fail()
+	}
+
+	grammar := GrammarToString(&ast)
+	if !strings.Contains(grammar, "Item { Letter = `string.upper(Letter1)` }") || strings.Contains(grammar, "Item { Digit }") {
+		println("Expected Item to be replaced in the merged grammar, got:")
+		println(grammar)
+		t.Fail()
+	}
+
+	parser := initParser("Start{<a>}\nOVERRIDE Start{<b> = `1`}")
+	parser.consumeInput()
+	if len(parser.Errors) != 1 {
+		println("Expected len(parser.Errors) to be 1")
+		t.Fatal()
+	}
+	parseError, ok := parser.Errors[0].(*ParseError)
+	if !ok || parseError.Type != OVERRIDDEN_RULE_NOT_FOUND {
+		println("Expected an OVERRIDDEN_RULE_NOT_FOUND error")
+		t.Fail()
+	}
+
+	if ast.Rules["Item"][0].Order > ast.Rules["Letter"][0].Order {
+		println("Expected Item to keep its place before Letter")
+		t.Fail()
+	}
+
+	dir = writeGrammars(t, map[string]string{
+		"base.khk":  "Start{Letter}",
+		"child.khk": "Letter{<[a-z]>}\nEXTENDS base",
+	})
+	_, errs = ParseFile(filepath.Join(dir, "child.khk"), nil)
+	if len(errs) != 1 {
+		println("Expected len(errs) to be 1")
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	parseError, ok = errs[0].(*ParseError)
+	if !ok || parseError.Type != EXTENDS_AFTER_RULES {
+		println("Expected an EXTENDS_AFTER_RULES error")
+		t.Fail()
+	}
+}
+
+func TestGrammarToString(t *testing.T) {
+	ast, errs := Parse("S{Items:<a>+ B? c:C(`1`) Rest:(C(`2`) <d>)* = `3`}\nB{<b>}\nC(x){<c>}")
+	if len(errs) != 0 {
+		println("Expected len(errs) to be 0")
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	grammar := GrammarToString(&ast)
+	expected := "\nS { Items:<a>+ B? c:C(`1`) Rest:(C(`2`) <d>)* = `3` }\n\nB { <b> }\n\nC(x) { <c> }\n"
+	if grammar != expected {
+		println("Expected the rules to be printed as written, got:")
+		println(grammar)
+		t.Fail()
+	}
+}
+
+func TestConsumeLongestMatch(t *testing.T) {
+	parser := initParser("LONGEST_MATCH\nLONGEST_MATCH { <a> <b> }\nFIRST_MATCH { <c> }\ntest{<a>}")
+	ast := parser.consumeInput()
+
+	if len(parser.Errors) != 0 {
+		println("Expected len(parser.Errors) to be 0")
+		helper.DisplayAllErrors(parser.Errors)
+		t.Fatal()
+	}
+	if !ast.IsLongestMatch {
+		println("Expected ast.IsLongestMatch to be true")
+		t.Fail()
+	}
+	if len(ast.LongestMatchTerminals) != 2 || ast.LongestMatchTerminals[1].RegexString != "b" {
+		println("Expected the longest match terminals to be <a> and <b>")
+		t.Fail()
+	}
+	if len(ast.FirstMatchTerminals) != 1 || ast.FirstMatchTerminals[0].RegexString != "c" {
+		println("Expected the first match terminals to be <c>")
+		t.Fail()
+	}
+
+	parser = initParser("FIRST_MATCH <a>\ntest{<a>}")
+	parser.consumeInput()
+	if len(parser.Errors) == 0 {
+		println("Expected an error for FIRST_MATCH without a terminal list")
+		t.Fatal()
+	}
+	parseError, ok := parser.Errors[0].(*ParseError)
+	if !ok || parseError.Type != EXPECTED_OPENING_CURLY_BRACKET {
+		println("Expected EXPECTED_OPENING_CURLY_BRACKET")
+		helper.DisplayAllErrors(parser.Errors)
+		t.Fail()
+	}
+}
@@ -0,0 +1,171 @@
+package kuuhaku_parser
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+)
+
+// GrammarToString prints the grammar after the imports and the base grammar are merged, in rule order.
+// The rules are printed as written, the alternatives desugared from a rule definition are printed once
+// and the hidden rules are left out. Used for debugging
+func GrammarToString(ast *Ast) string {
+	out := ""
+	if ast.IsSearchMode {
+		out += "SEARCH_MODE\n"
+	}
+	if ast.IsGLRMode {
+		out += "GLR_MODE\n"
+	}
+	if ast.IsNodeMode {
+		out += "NODE_MODE\n"
+	}
+	if ast.IsLongestMatch {
+		out += "LONGEST_MATCH\n"
+	}
+	if len(ast.LongestMatchTerminals) > 0 {
+		out += terminalsToString("LONGEST_MATCH", ast.LongestMatchTerminals)
+	}
+	if len(ast.FirstMatchTerminals) > 0 {
+		out += terminalsToString("FIRST_MATCH", ast.FirstMatchTerminals)
+	}
+	if len(ast.Preferences) > 0 {
+		var names []string
+		for _, preference := range ast.Preferences {
+			names = append(names, preference.Name)
+		}
+		out += "PREFER(" + strings.Join(names, ", ") + ")\n"
+	}
+	if len(ast.Trivia) > 0 {
+		out += terminalsToString("IGNORE", ast.Trivia)
+	}
+	for _, mode := range ast.Modes {
+		if mode.IsRestricted {
+			out += terminalsToString("MODE "+mode.Name, mode.Terminals)
+		}
+		if len(mode.Pushes) > 0 {
+			out += terminalsToString("PUSH "+mode.Name, mode.Pushes)
+		}
+		if len(mode.Pops) > 0 {
+			out += terminalsToString("POP "+mode.Name, mode.Pops)
+		}
+	}
+	if ast.GlobalLua != nil {
+		out += luaLiteralToString(*ast.GlobalLua) + "\n"
+	}
+
+	var rules []*Rule
+	for _, ruleArray := range ast.Rules {
+		rules = append(rules, ruleArray...)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Order < rules[j].Order
+	})
+
+	//the alternatives desugared from a rule definition share its position
+	isPrinted := make(map[kuuhaku_tokenizer.Position]bool)
+	for _, rule := range rules {
+		if rule.Hidden != NOT_HIDDEN || isPrinted[rule.Position] {
+			continue
+		}
+		isPrinted[rule.Position] = true
+		out += "\n"
+		if rule.Position.File != "" {
+			out += "# " + rule.Position.File + ":" + strconv.Itoa(rule.Position.Line) + "\n"
+		}
+		out += ruleToString(rule) + "\n"
+	}
+	return out
+}
+
+func terminalsToString(directive string, terminals []RegexLiteral) string {
+	out := directive + " {"
+	for _, terminal := range terminals {
+		out += " <" + terminal.RegexString + ">"
+	}
+	return out + " }\n"
+}
+
+func ruleToString(rule *Rule) string {
+	out := rule.Name
+	if len(rule.ArgList) > 0 {
+		var params []string
+		for _, param := range rule.ArgList {
+			params = append(params, param.Name)
+		}
+		out += "(" + strings.Join(params, ", ") + ")"
+	}
+
+	out += " {"
+	matchRules := rule.SourceMatchRules
+	if matchRules == nil {
+		matchRules = rule.MatchRules
+	}
+	if len(matchRules) > 0 {
+		out += " " + matchRulesToString(matchRules)
+	}
+	if rule.ReplaceRule != nil {
+		out += " = " + luaLiteralToString(*rule.ReplaceRule)
+	}
+	if rule.CollectRule != nil {
+		out += " COLLECT " + luaLiteralToString(*rule.CollectRule)
+	}
+	return out + " }"
+}
+
+func matchRulesToString(matchRules []MatchRule) string {
+	var out []string
+	for _, matchRule := range matchRules {
+		out = append(out, matchRuleToString(matchRule))
+	}
+	return strings.Join(out, " ")
+}
+
+func matchRuleToString(matchRule MatchRule) string {
+	switch m := matchRule.(type) {
+	case Identifier:
+		out := bindingToString(m.Binding) + m.Name
+		if len(m.ArgList) > 0 {
+			var args []string
+			for _, arg := range m.ArgList {
+				args = append(args, luaLiteralToString(arg))
+			}
+			out += "(" + strings.Join(args, ", ") + ")"
+		}
+		return out
+	case RegexLiteral:
+		return bindingToString(m.Binding) + "<" + m.RegexString + ">"
+	case ebnfMatchRule:
+		//the name of an operand that isn't a group is kept on the operand
+		out := matchRuleToString(m.Items[0])
+		if m.IsGroup {
+			out = bindingToString(m.Binding) + "(" + matchRulesToString(m.Items) + ")"
+		}
+		switch m.Quantifier {
+		case kuuhaku_tokenizer.QUESTION_MARK:
+			out += "?"
+		case kuuhaku_tokenizer.ASTERISK:
+			out += "*"
+		case kuuhaku_tokenizer.PLUS_SIGN:
+			out += "+"
+		}
+		return out
+	}
+	return ""
+}
+
+func bindingToString(binding string) string {
+	if binding == "" {
+		return ""
+	}
+	return binding + ":"
+}
+
+func luaLiteralToString(luaLiteral LuaLiteral) string {
+	if luaLiteral.Type == LUA_LITERAL_TYPE_RETURN {
+		return "`" + strings.TrimPrefix(luaLiteral.LuaString, "return ") + "`"
+	}
+	return "``" + luaLiteral.LuaString + "``"
+}
@@ -0,0 +1,61 @@
+package kuuhaku_runtime
+
+import (
+	"strconv"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_parser"
+)
+
+// compiles the collect pass, which runs the collect rules before any replace rule. A collect rule runs
+// once for each node of its alternative in the order the nodes end in the input, so the nodes under a
+// node are collected before it and the siblings from left to right. The bindings hold the input the
+// children matched, a list made by the EBNF operators is a table of the input of its elements. The
+// collect rules share the COLLECTED table with the replace rules
+func (compiler *luaCompiler) compileCollect(element ParseStackElement, input string) string {
+	tree, ok := element.(*ParseStackTree)
+	if !ok {
+		return ""
+	}
+	out := ""
+	for _, child := range *tree.Children {
+		out += compiler.compileCollect(child, input)
+	}
+	if tree.Rule.CollectRule == nil || tree.Rule.Hidden != kuuhaku_parser.NOT_HIDDEN {
+		return out
+	}
+
+	compiler.owners = append(compiler.owners, tree)
+	defer func() {
+		compiler.owners = compiler.owners[:len(compiler.owners)-1]
+	}()
+	out += "__kuuhaku_collect(function()"
+	for i, child := range *tree.Children {
+		out += "\nlocal " + kuuhaku_parser.GetBinding(tree.Rule.MatchRules[i]) + " = " + compileCollectedText(child, input)
+	}
+	for _, absent := range tree.Rule.AbsentBindings {
+		if absent.IsList {
+			out += "\nlocal " + absent.Name + " = {}"
+		} else {
+			out += "\nlocal " + absent.Name + " = nil"
+		}
+	}
+	out += compileNodeSpan(tree) + "\n"
+	code := tree.Rule.CollectRule.LuaString
+	out += compiler.mark(tree.Rule.CollectRule, code) + code
+	return out + "\nend)\n"
+}
+
+func compileCollectedText(element ParseStackElement, input string) string {
+	tree, ok := element.(*ParseStackTree)
+	if !ok || tree.Rule.Hidden != kuuhaku_parser.HIDDEN_LIST {
+		return strconv.Quote(getText(input, element.GetPosition(), element.GetEnd()))
+	}
+	out := "{"
+	for i, listElement := range getListElements(tree) {
+		if i != 0 {
+			out += ", "
+		}
+		out += strconv.Quote(getText(input, listElement.GetPosition(), listElement.GetEnd()))
+	}
+	return out + "}"
+}
@@ -0,0 +1,301 @@
+package kuuhaku_runtime
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+type DocKind int
+
+const (
+	DOC_TEXT DocKind = iota
+	DOC_CONCAT
+	DOC_LINE     //a space, or a newline when its group is broken
+	DOC_SOFTLINE //nothing, or a newline when its group is broken
+	DOC_HARDLINE //always a newline, the groups around it are broken
+	DOC_GROUP    //flat if it fits in the rest of the line, broken otherwise
+	DOC_NEST     //Text is added to the indentation of the lines in the children
+	DOC_FILL     //the children alternate between contents and separators, see Layout
+	DOC_ALIGN    //a marker aligned with the markers of the same name, Text is the name, see Layout
+)
+
+// a document of the pretty printer. The children of a group, a nest or a fill are concatenated
+type Doc struct {
+	Kind     DocKind
+	Text     string
+	Children []*Doc
+	isBroken bool //has a hardline or a text with a newline, the groups around it can't be flat
+}
+
+func NewDoc(kind DocKind, text string, children ...*Doc) *Doc {
+	doc := &Doc{
+		Kind:     kind,
+		Text:     text,
+		Children: children,
+		isBroken: kind == DOC_HARDLINE || (kind == DOC_TEXT && strings.Contains(text, "\n")),
+	}
+	for _, child := range children {
+		if child.isBroken {
+			doc.isBroken = true
+		}
+	}
+	return doc
+}
+
+// where a marker was laid out, offset is the byte offset in the output
+type docMarker struct {
+	offset int
+	name   string
+}
+
+type docCommand struct {
+	indent string
+	isFlat bool
+	doc    *Doc
+	offset int //the first child of a fill that is left to lay out
+}
+
+// Layout prints the document, a group is laid out flat if it fits in the width along with what
+// follows it up to the next line break. A fill breaks a separator only when the content after it
+// doesn't fit on the line. Columns are counted in characters and the spaces left at the end of a
+// line are trimmed.
+//
+// After the layout the alignment markers are padded with spaces, the markers of one name on
+// consecutive lines are moved to the column of the rightmost of them. Only the first marker of a name
+// on each line is aligned, a marker at the end of a line counts for the column but isn't padded
+func (doc *Doc) Layout(width int) string {
+	var out []byte
+	var markers []docMarker
+	column := 0
+	commands := []docCommand{{doc: doc}}
+	for len(commands) > 0 {
+		command := commands[len(commands)-1]
+		commands = commands[:len(commands)-1]
+		curr := command.doc
+		switch curr.Kind {
+		case DOC_TEXT:
+			out = append(out, curr.Text...)
+			column = getColumnAfter(column, curr.Text)
+		case DOC_LINE, DOC_SOFTLINE, DOC_HARDLINE:
+			if command.isFlat && curr.Kind != DOC_HARDLINE {
+				if curr.Kind == DOC_LINE {
+					out = append(out, ' ')
+					column++
+				}
+				continue
+			}
+			out = append(trimTrailingSpaces(out, markers), '\n')
+			out = append(out, command.indent...)
+			column = utf8.RuneCountInString(command.indent)
+		case DOC_CONCAT, DOC_GROUP, DOC_NEST:
+			isFlat := command.isFlat
+			indent := command.indent
+			if curr.Kind == DOC_GROUP && !isFlat && !curr.isBroken {
+				isFlat = fits(getChildCommands(curr.Children, indent, true), commands, width-column, false)
+			}
+			if curr.Kind == DOC_NEST {
+				indent += curr.Text
+			}
+			commands = append(commands, getChildCommands(curr.Children, indent, isFlat)...)
+		case DOC_FILL:
+			commands = layoutFill(commands, command, width-column)
+		case DOC_ALIGN:
+			markers = append(markers, docMarker{offset: len(out), name: curr.Text})
+		}
+	}
+	return alignMarkers(string(trimTrailingSpaces(out, markers)), markers)
+}
+
+// pushes the next content and separator of the fill, and the fill of the children after them
+func layoutFill(commands []docCommand, command docCommand, width int) []docCommand {
+	parts := command.doc.Children[command.offset:]
+	if len(parts) == 0 {
+		return commands
+	}
+	contentFlat := docCommand{indent: command.indent, isFlat: true, doc: parts[0]}
+	contentBreak := docCommand{indent: command.indent, isFlat: false, doc: parts[0]}
+	isContentFitting := fits([]docCommand{contentFlat}, nil, width, true)
+	if len(parts) == 1 {
+		if isContentFitting {
+			return append(commands, contentFlat)
+		}
+		return append(commands, contentBreak)
+	}
+	separatorFlat := docCommand{indent: command.indent, isFlat: true, doc: parts[1]}
+	separatorBreak := docCommand{indent: command.indent, isFlat: false, doc: parts[1]}
+	if len(parts) == 2 {
+		if isContentFitting {
+			return append(commands, separatorFlat, contentFlat)
+		}
+		return append(commands, separatorBreak, contentBreak)
+	}
+	rest := command
+	rest.offset += 2
+	commands = append(commands, rest)
+	if fits(getChildCommands(parts[:3], command.indent, true), nil, width, true) {
+		return append(commands, separatorFlat, contentFlat)
+	} else if isContentFitting {
+		return append(commands, separatorBreak, contentFlat)
+	}
+	return append(commands, separatorBreak, contentBreak)
+}
+
+// the commands of the children in the order of a stack, the first child is on the top
+func getChildCommands(children []*Doc, indent string, isFlat bool) []docCommand {
+	commands := make([]docCommand, len(children))
+	for i, child := range children {
+		commands[len(children)-1-i] = docCommand{indent: indent, isFlat: isFlat, doc: child}
+	}
+	return commands
+}
+
+// whether the commands fit in the width up to the next line break. rest is the stack of commands that
+// follow them, the broken groups in it end the line. If mustBeFlat is true a broken group doesn't fit
+func fits(next []docCommand, rest []docCommand, width int, mustBeFlat bool) bool {
+	stack := append([]docCommand{}, next...)
+	restIndex := len(rest)
+	for width >= 0 {
+		if len(stack) == 0 {
+			if restIndex == 0 {
+				return true
+			}
+			restIndex--
+			stack = append(stack, rest[restIndex])
+			continue
+		}
+		command := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		curr := command.doc
+		switch curr.Kind {
+		case DOC_TEXT:
+			line, _, isCut := strings.Cut(curr.Text, "\n")
+			width -= utf8.RuneCountInString(line)
+			if isCut {
+				return width >= 0
+			}
+		case DOC_LINE, DOC_SOFTLINE:
+			if !command.isFlat {
+				return true
+			}
+			if curr.Kind == DOC_LINE {
+				width--
+			}
+		case DOC_HARDLINE:
+			return true
+		case DOC_GROUP:
+			if mustBeFlat && curr.isBroken {
+				return false
+			}
+			stack = append(stack, getChildCommands(curr.Children, command.indent, command.isFlat && !curr.isBroken)...)
+		case DOC_CONCAT, DOC_NEST, DOC_FILL:
+			stack = append(stack, getChildCommands(curr.Children[command.offset:], command.indent, command.isFlat)...)
+		}
+	}
+	return false
+}
+
+func getColumnAfter(column int, text string) int {
+	index := strings.LastIndexByte(text, '\n')
+	if index == -1 {
+		return column + utf8.RuneCountInString(text)
+	}
+	return utf8.RuneCountInString(text[index+1:])
+}
+
+// the markers in the trimmed spaces are moved to the end of the line
+func trimTrailingSpaces(out []byte, markers []docMarker) []byte {
+	end := len(out)
+	for end > 0 && (out[end-1] == ' ' || out[end-1] == '\t') {
+		end--
+	}
+	for i := len(markers) - 1; i >= 0 && markers[i].offset > end; i-- {
+		markers[i].offset = end
+	}
+	return out[:end]
+}
+
+type alignedLine struct {
+	text    string
+	markers []docMarker //the offsets are in the line, in the order they were laid out
+}
+
+func alignMarkers(out string, markers []docMarker) string {
+	if len(markers) == 0 {
+		return out
+	}
+	var lines []*alignedLine
+	var names []string
+	isNameAdded := make(map[string]bool)
+	start := 0
+	markerIndex := 0
+	for _, text := range strings.SplitAfter(out, "\n") {
+		line := &alignedLine{text: text}
+		for markerIndex < len(markers) && markers[markerIndex].offset <= start+len(text) {
+			// a marker right after a newline belongs to the next line
+			if markers[markerIndex].offset == start+len(text) && strings.HasSuffix(text, "\n") {
+				break
+			}
+			marker := markers[markerIndex]
+			line.markers = append(line.markers, docMarker{offset: marker.offset - start, name: marker.name})
+			if !isNameAdded[marker.name] {
+				names = append(names, marker.name)
+				isNameAdded[marker.name] = true
+			}
+			markerIndex++
+		}
+		lines = append(lines, line)
+		start += len(text)
+	}
+
+	for _, name := range names {
+		blockStart := -1
+		for i := 0; i <= len(lines); i++ {
+			if i < len(lines) && lines[i].getMarker(name) != -1 {
+				if blockStart == -1 {
+					blockStart = i
+				}
+				continue
+			}
+			if blockStart != -1 {
+				alignBlock(lines[blockStart:i], name)
+				blockStart = -1
+			}
+		}
+	}
+
+	var result strings.Builder
+	for _, line := range lines {
+		result.WriteString(line.text)
+	}
+	return result.String()
+}
+
+// the index of the first marker of the name in the line, -1 if there is none
+func (line *alignedLine) getMarker(name string) int {
+	for i, marker := range line.markers {
+		if marker.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func alignBlock(lines []*alignedLine, name string) {
+	target := 0
+	for _, line := range lines {
+		marker := line.markers[line.getMarker(name)]
+		target = max(target, utf8.RuneCountInString(line.text[:marker.offset]))
+	}
+	for _, line := range lines {
+		index := line.getMarker(name)
+		offset := line.markers[index].offset
+		if strings.TrimRight(line.text[offset:], "\n") == "" {
+			continue
+		}
+		padding := strings.Repeat(" ", target-utf8.RuneCountInString(line.text[:offset]))
+		line.text = line.text[:offset] + padding + line.text[offset:]
+		for i := index + 1; i < len(line.markers); i++ {
+			line.markers[i].offset += len(padding)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package kuuhaku_runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_parser"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+)
+
+type ExportedNodeKind string
+
+const (
+	EXPORTED_NODE     ExportedNodeKind = "node"
+	EXPORTED_LIST     ExportedNodeKind = "list"  //the elements of a repetition, the recursion of the hidden rule is flattened
+	EXPORTED_GROUP    ExportedNodeKind = "group" //a parenthesized group
+	EXPORTED_TERMINAL ExportedNodeKind = "terminal"
+)
+
+// lines and columns are 1-based, offset is the 0-based byte offset in the input
+type ExportedPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Offset int `json:"offset"`
+}
+
+// a parse tree node in the form that is exported as JSON or as an S-expression. Text is the input
+// the node covers, End is the position right after it
+type ExportedNode struct {
+	Kind        ExportedNodeKind `json:"kind"`
+	Rule        string           `json:"rule,omitempty"`
+	Alternative int              `json:"alternative,omitempty"`
+	Regex       string           `json:"regex,omitempty"`
+	Text        string           `json:"text"`
+	Start       ExportedPosition `json:"start"`
+	End         ExportedPosition `json:"end"`
+	Children    []*ExportedNode  `json:"children,omitempty"`
+}
+
+// ExportTree converts a tree returned by ParseTrees, see NewNode
+func ExportTree(tree *ParseStackTree, input string) *ExportedNode {
+	return NewNode(tree, input).Export()
+}
+
+func (node *Node) Export() *ExportedNode {
+	exported := &ExportedNode{
+		Kind:  EXPORTED_NODE,
+		Rule:  node.Rule,
+		Text:  node.Text(),
+		Start: toExportedPosition(node.Start),
+		End:   toExportedPosition(node.End),
+	}
+	switch node.Hidden {
+	case kuuhaku_parser.HIDDEN_LIST:
+		exported.Kind = EXPORTED_LIST
+	case kuuhaku_parser.HIDDEN_GROUP:
+		exported.Kind = EXPORTED_GROUP
+	default:
+		exported.Alternative = node.Alternative
+	}
+	for _, child := range node.Children {
+		childNode, ok := child.(*Node)
+		if ok {
+			exported.Children = append(exported.Children, childNode.Export())
+			continue
+		}
+		terminal := child.(*Terminal)
+		exported.Children = append(exported.Children, &ExportedNode{
+			Kind:  EXPORTED_TERMINAL,
+			Regex: terminal.Regex,
+			Text:  terminal.Text(),
+			Start: toExportedPosition(terminal.Start),
+			End:   toExportedPosition(terminal.End),
+		})
+	}
+	return exported
+}
+
+func toExportedPosition(position kuuhaku_tokenizer.Position) ExportedPosition {
+	return ExportedPosition{
+		Line:   position.Line,
+		Column: position.Column,
+		Offset: position.Raw,
+	}
+}
+
+// ExportJSON writes the trees as a JSON array
+func ExportJSON(nodes []*ExportedNode) ([]byte, error) {
+	if nodes == nil {
+		nodes = []*ExportedNode{}
+	}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(nodes)
+	return buffer.Bytes(), err
+}
+
+// ExportSExpr writes each tree as an S-expression, like
+//
+//	(node :rule "A" :alternative 1 :start (1 1 0) :end (1 2 1) :text "a"
+//	  (terminal :regex "a" :start (1 1 0) :end (1 2 1) :text "a"))
+func ExportSExpr(nodes []*ExportedNode) string {
+	out := ""
+	for _, node := range nodes {
+		out += node.toSExpr(0) + "\n"
+	}
+	return out
+}
+
+func (node *ExportedNode) toSExpr(depth int) string {
+	out := strings.Repeat("  ", depth) + "(" + string(node.Kind)
+	if node.Rule != "" {
+		out += " :rule " + strconv.Quote(node.Rule)
+	}
+	if node.Alternative != 0 {
+		out += " :alternative " + strconv.Itoa(node.Alternative)
+	}
+	if node.Kind == EXPORTED_TERMINAL {
+		out += " :regex " + strconv.Quote(node.Regex)
+	}
+	out += " :start " + node.Start.toSExpr() + " :end " + node.End.toSExpr()
+	out += " :text " + strconv.Quote(node.Text)
+	for _, child := range node.Children {
+		out += "\n" + child.toSExpr(depth+1)
+	}
+	return out + ")"
+}
+
+func (position ExportedPosition) toSExpr() string {
+	return "(" + strconv.Itoa(position.Line) + " " + strconv.Itoa(position.Column) + " " + strconv.Itoa(position.Offset) + ")"
+}
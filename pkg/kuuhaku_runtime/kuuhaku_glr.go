@@ -0,0 +1,240 @@
+package kuuhaku_runtime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_analyzer"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+)
+
+// the maximum amount of steps per input byte before the GLR runtime gives up, this guards against
+// grammars with reduce cycles that would otherwise fork forever
+const glrStepsPerByte = 1000
+
+type glrStack struct {
+	parseStack   []ParseStackElement
+	state        int
+	pos          kuuhaku_tokenizer.Position
+	lastTerminal *ParseStackTerminal //forks share it, the trivia after a token is the same on every fork
+	modes        []string
+}
+
+func (stack *glrStack) fork() *glrStack {
+	parseStack := make([]ParseStackElement, len(stack.parseStack))
+	copy(parseStack, stack.parseStack)
+	return &glrStack{
+		parseStack:   parseStack,
+		state:        stack.state,
+		pos:          stack.pos,
+		lastTerminal: stack.lastTerminal,
+		modes:        stack.modes,
+	}
+}
+
+// two stacks converge if they are at the same input position with the same states and lexer modes,
+// from there on they will always take the same actions
+func (stack *glrStack) key() string {
+	out := strconv.Itoa(stack.pos.Raw) + ":" + strconv.Itoa(stack.state)
+	for _, element := range stack.parseStack {
+		out += "," + strconv.Itoa(element.GetState())
+	}
+	return out + ":" + strings.Join(stack.modes, ",")
+}
+
+// runParseTableGLR is the GLR counterpart of runParseTable. Instead of failing on a multi-action
+// cell, it forks the parse stack for every action and merges the stacks that converge. The stacks are
+// always advanced from the one with the smallest input position so that converging stacks meet.
+func runParseTableGLR(input string, pos kuuhaku_tokenizer.Position, parseTable *kuuhaku_analyzer.ParseTable, preferences []string, isSearchMode bool, printCompiled bool) ([]ParseStackElement, kuuhaku_tokenizer.Position, error) {
+	active := []*glrStack{{state: 0, pos: pos}}
+	var accepted []*glrStack
+
+	furthestPos := pos
+	var furthestExpected []string
+
+	maxSteps := (len(input)-pos.Raw+1)*glrStepsPerByte
+	steps := 0
+	for len(active) > 0 {
+		steps++
+		if steps > maxSteps {
+			return nil, pos, ErrGLRStepLimitExceeded(furthestPos)
+		}
+
+		current := 0
+		for i, stack := range active {
+			if stack.pos.Raw < active[current].pos.Raw {
+				current = i
+			}
+		}
+		stack := active[current]
+		active = append(active[:current], active[current+1:]...)
+
+		nextStacks, acceptedStacks, expected := stepGLRStack(input, stack, parseTable, isSearchMode, printCompiled)
+		accepted = append(accepted, acceptedStacks...)
+		if len(nextStacks) == 0 && len(acceptedStacks) == 0 && stack.pos.Raw >= furthestPos.Raw {
+			furthestPos = stack.pos
+			furthestExpected = expected
+		}
+		if printCompiled && len(nextStacks) > 1 {
+			fmt.Println("Forked the parse stack at position " + strconv.Itoa(stack.pos.Raw) + " into " + strconv.Itoa(len(nextStacks)) + " stacks")
+		}
+
+		for _, next := range nextStacks {
+			isMerged := false
+			for i, other := range active {
+				if other.key() == next.key() {
+					if compareParseStacks(next.parseStack, other.parseStack, preferences) < 0 {
+						active[i] = next
+					}
+					isMerged = true
+					break
+				}
+			}
+			if !isMerged {
+				active = append(active, next)
+			}
+		}
+	}
+
+	if len(accepted) == 0 {
+		return nil, furthestPos, ErrSyntaxError(furthestPos, &furthestExpected)
+	}
+
+	best := accepted[0]
+	for _, stack := range accepted[1:] {
+		if stack.pos.Raw > best.pos.Raw || (stack.pos.Raw == best.pos.Raw && compareParseStacks(stack.parseStack, best.parseStack, preferences) < 0) {
+			best = stack
+		}
+	}
+
+	best.pos = consumeFinalTrivia(input, best.pos, parseTable.Trivia, best.lastTerminal, isSearchMode)
+	if len(best.parseStack) != 1 {
+		return nil, best.pos, ErrParseStackIsNotEmpty(best.pos)
+	}
+	return best.parseStack, best.pos, nil
+}
+
+// applies every action of the current cell to its own copy of the stack. Returns the stacks that
+// are still running, the stacks that have been accepted and the terminals that were expected
+func stepGLRStack(input string, stack *glrStack, parseTable *kuuhaku_analyzer.ParseTable, isSearchMode bool, printCompiled bool) ([]*glrStack, []*glrStack, []string) {
+	currRow := parseTable.States[stack.state]
+	if stack.pos.Raw > len(input) {
+		return nil, nil, nil
+	}
+
+	eligible := getEligibleTerminals(parseTable, stack.modes)
+	trivia := ""
+	tokenPos := stack.pos
+	if eligible == nil && (!isSearchMode || stack.lastTerminal != nil) {
+		trivia, tokenPos = skipTrivia(input, stack.pos, parseTable.Trivia)
+	}
+	lookahead, lookaheadRegex, nextPos, lookaheadFound, expected := matchLookahead(input, tokenPos, parseTable, &currRow, eligible)
+
+	var actions []*kuuhaku_analyzer.ActionCell
+	if (lookaheadFound && tokenPos.Raw < len(input)) || (lookaheadFound && tokenPos.Raw >= len(input) && currRow.EndReduceRule == nil) {
+		actions = append(actions, currRow.ActionTable[lookaheadRegex])
+		actions = append(actions, currRow.ActionTable[lookaheadRegex].ConflictingActions...)
+	} else if currRow.EndReduceRule != nil {
+		actions = append(actions, currRow.EndReduceRule)
+		actions = append(actions, currRow.EndReduceRule.ConflictingActions...)
+	}
+
+	var nextStacks []*glrStack
+	var acceptedStacks []*glrStack
+	for _, action := range actions {
+		next := stack.fork()
+		switch action.Action {
+		case kuuhaku_analyzer.SHIFT:
+			if printCompiled {
+				fmt.Println("Shifted: " + lookahead + " with the regex " + lookaheadRegex)
+			}
+			content := strconv.Quote(lookahead)
+			content = content[1 : len(content)-1]
+			terminal := &ParseStackTerminal{
+				String:   content,
+				State:    action.ShiftState,
+				Position: tokenPos,
+				End:      nextPos,
+				Regex:    lookaheadRegex,
+			}
+			attachTrivia(stack.lastTerminal, terminal, trivia)
+			next.lastTerminal = terminal
+			next.modes = shiftMode(parseTable, stack.modes, lookaheadRegex)
+			next.parseStack = append(next.parseStack, terminal)
+			next.state = action.ShiftState
+			next.pos = nextPos
+		case kuuhaku_analyzer.REDUCE:
+			var err error
+			next.state, err = applyRule(parseTable, action.ReduceRule, &next.parseStack, next.pos, false)
+			if err != nil {
+				continue
+			}
+			if printCompiled {
+				fmt.Println("Reducing rule " + strconv.Itoa(action.ReduceRule.Order) + " with lhs: " + action.ReduceRule.Name)
+			}
+		case kuuhaku_analyzer.ACCEPT:
+			_, err := applyRule(parseTable, action.ReduceRule, &next.parseStack, next.pos, true)
+			if err == nil {
+				acceptedStacks = append(acceptedStacks, next)
+			}
+			continue
+		}
+		nextStacks = append(nextStacks, next)
+	}
+	return nextStacks, acceptedStacks, expected
+}
+
+// compares two parse stacks for the final ambiguity resolution. Returns a negative number if a is
+// preferred, a positive number if b is preferred and 0 if they can't be told apart. The first rule that
+// differs in a pre-order walk decides: the subtree containing the strongest preferred rule wins, then
+// the lower rule order.
+func compareParseStacks(a []ParseStackElement, b []ParseStackElement, preferences []string) int {
+	i := 0
+	for i < len(a) && i < len(b) {
+		res := compareParseStackElements(a[i], b[i], preferences)
+		if res != 0 {
+			return res
+		}
+		i++
+	}
+	return 0
+}
+
+func compareParseStackElements(a ParseStackElement, b ParseStackElement, preferences []string) int {
+	treeA, okA := a.(*ParseStackTree)
+	treeB, okB := b.(*ParseStackTree)
+	if !okA || !okB {
+		return 0
+	}
+	if treeA.Rule != treeB.Rule {
+		rankA := preferenceRank(treeA, preferences)
+		rankB := preferenceRank(treeB, preferences)
+		if rankA != rankB {
+			return rankA - rankB
+		}
+		return treeA.Rule.Order - treeB.Rule.Order
+	}
+	return compareParseStacks(*treeA.Children, *treeB.Children, preferences)
+}
+
+func preferenceRank(tree *ParseStackTree, preferences []string) int {
+	rank := len(preferences)
+	for i, preference := range preferences {
+		if preference == tree.Rule.Name {
+			rank = i
+			break
+		}
+	}
+	for _, child := range *tree.Children {
+		childTree, ok := child.(*ParseStackTree)
+		if !ok {
+			continue
+		}
+		childRank := preferenceRank(childTree, preferences)
+		if childRank < rank {
+			rank = childRank
+		}
+	}
+	return rank
+}
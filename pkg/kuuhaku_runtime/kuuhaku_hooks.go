@@ -0,0 +1,167 @@
+package kuuhaku_runtime
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_analyzer"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_parser"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// the environment the code of the matches runs in when the global lua declares top level locals
+const luaLocalEnvName = "__kuuhaku_env"
+
+func ErrInvalidHookResult(hook string, result string) *EvalError {
+	return &EvalError{
+		Message: hook + " must return a string or nil, got " + result,
+		Type:    INVALID_HOOK_RESULT,
+	}
+}
+
+// the file being formatted, the hooks get it as the ctx table
+type FormatContext struct {
+	Filename  string
+	Extension string
+	InputSize int //in bytes
+	Options   FormatOptions
+}
+
+// the command line options of the formatter
+type FormatOptions struct {
+	Config             string
+	OutputFormat       string
+	SuppressedWarnings []string
+	IsRecursive        bool
+	IsStatic           bool
+	IsWerror           bool
+	IsPlain            bool
+	IsDebugRuntime     bool
+	IsDebugAnalyzer    bool
+	IsDebugParser      bool
+	IsDebugReader      bool
+}
+
+// FormatFile formats the input like Format and runs the hooks the global lua defines. on_start(ctx)
+// runs before the first match and on_finish(output, ctx) after the last one, once for the whole file
+// even in search mode. on_finish may return the output to write instead. The runtime globals and the
+// global lua are set up once per file and the hooks and the code of every match run in the same lua
+// state, so the globals set by on_start and DOC.width are shared by all the matches. The top level
+// locals of the global lua are visible to the matches too, see compileLuaLocalEnv. COLLECTED is
+// emptied before each match, see runParseStack
+func FormatFile(input string, format *kuuhaku_analyzer.AnalyzerResult, ctx *FormatContext, isDebug bool) (string, error) {
+	var globalLua kuuhaku_parser.LuaLiteral
+	if format.GlobalLua != nil {
+		globalLua = *format.GlobalLua
+	}
+	L := lua.NewState()
+	defer L.Close()
+	luaCtx := makeLuaContext(L, ctx)
+	docs := registerLuaGlobals(L)
+
+	if globalLua.LuaString != "" {
+		chunk, err := L.Load(strings.NewReader(globalLua.LuaString+compileLuaLocalEnv(globalLua.LuaString)), luaGlobalChunkName)
+		if err == nil {
+			err = L.CallByParam(lua.P{Fn: chunk, NRet: 0, Protect: true})
+		}
+		if err == nil {
+			_, err = callHook(L, "on_start", luaCtx)
+		}
+		if err != nil {
+			return "", (&luaCompiler{}).mapLuaError(err, "", &globalLua)
+		}
+	}
+
+	out, err := runParseTables(input, format, isDebug, func(parseStack *[]ParseStackElement) (string, error) {
+		return runParseStack(L, docs, parseStack, input, format, isDebug)
+	})
+	if err != nil || globalLua.LuaString == "" {
+		return out, err
+	}
+
+	result, err := callHook(L, "on_finish", lua.LString(out), luaCtx)
+	if err != nil {
+		return "", (&luaCompiler{}).mapLuaError(err, "", &globalLua)
+	}
+	switch result.Type() {
+	case lua.LTString:
+		return result.String(), nil
+	case lua.LTNil:
+		return out, nil
+	}
+	return "", ErrInvalidHookResult("on_finish", result.Type().String())
+}
+
+// the global lua runs in its own chunk, so its top level locals are out of the scope of the matches.
+// They're kept visible through an environment appended to the global lua that reads and writes the
+// locals themselves and falls back to the globals, see runParseStack. The locals are appended after
+// the global lua so the lines of its errors don't move
+func compileLuaLocalEnv(globalLua string) string {
+	chunk, err := parse.Parse(strings.NewReader(globalLua), luaGlobalChunkName)
+	if err != nil {
+		return ""
+	}
+	var names []string
+	for _, stmt := range chunk {
+		if s, ok := stmt.(*ast.LocalAssignStmt); ok {
+			names = append(names, s.Names...)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+
+	index := ""
+	newIndex := ""
+	for _, name := range names {
+		quoted := strconv.Quote(name)
+		index += "\tif __kuuhaku_name == " + quoted + " then return " + name + " end\n"
+		newIndex += "\tif __kuuhaku_name == " + quoted + " then " + name + " = __kuuhaku_value return end\n"
+	}
+	return "\n" + luaLocalEnvName + " = setmetatable({}, {__index = function(_, __kuuhaku_name)\n" +
+		index + "\treturn _G[__kuuhaku_name]\nend, __newindex = function(_, __kuuhaku_name, __kuuhaku_value)\n" +
+		newIndex + "\t_G[__kuuhaku_name] = __kuuhaku_value\nend})"
+}
+
+// calls the global function of the hook if the global lua defines it, the result is nil otherwise
+func callHook(L *lua.LState, name string, args ...lua.LValue) (lua.LValue, error) {
+	hook, ok := L.GetGlobal(name).(*lua.LFunction)
+	if !ok {
+		return lua.LNil, nil
+	}
+	err := L.CallByParam(lua.P{Fn: hook, NRet: 1, Protect: true}, args...)
+	if err != nil {
+		return lua.LNil, err
+	}
+	result := L.Get(-1)
+	L.Pop(1)
+	return result, nil
+}
+
+func makeLuaContext(L *lua.LState, ctx *FormatContext) *lua.LTable {
+	options := L.NewTable()
+	options.RawSetString("config", lua.LString(ctx.Options.Config))
+	options.RawSetString("format", lua.LString(ctx.Options.OutputFormat))
+	suppressed := L.NewTable()
+	for _, code := range ctx.Options.SuppressedWarnings {
+		suppressed.Append(lua.LString(code))
+	}
+	options.RawSetString("wsuppress", suppressed)
+	options.RawSetString("recursive", lua.LBool(ctx.Options.IsRecursive))
+	options.RawSetString("static", lua.LBool(ctx.Options.IsStatic))
+	options.RawSetString("werror", lua.LBool(ctx.Options.IsWerror))
+	options.RawSetString("plain", lua.LBool(ctx.Options.IsPlain))
+	options.RawSetString("debug_runtime", lua.LBool(ctx.Options.IsDebugRuntime))
+	options.RawSetString("debug_analyzer", lua.LBool(ctx.Options.IsDebugAnalyzer))
+	options.RawSetString("debug_parser", lua.LBool(ctx.Options.IsDebugParser))
+	options.RawSetString("debug_reader", lua.LBool(ctx.Options.IsDebugReader))
+
+	luaCtx := L.NewTable()
+	luaCtx.RawSetString("filename", lua.LString(ctx.Filename))
+	luaCtx.RawSetString("extension", lua.LString(ctx.Extension))
+	luaCtx.RawSetString("input_size", lua.LNumber(ctx.InputSize))
+	luaCtx.RawSetString("options", options)
+	return luaCtx
+}
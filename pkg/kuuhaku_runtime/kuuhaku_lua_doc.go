@@ -0,0 +1,194 @@
+package kuuhaku_runtime
+
+import (
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+const DEFAULT_DOC_WIDTH = 80
+const DEFAULT_DOC_INDENT_UNIT = "    "
+
+// the DOC table has the combinators of the pretty printer. A replace rule may return a doc anywhere it
+// returned a string, the docs concatenate with strings and with each other using the .. operator. The
+// formatted output is laid out against DOC.width, the global lua block may set DOC.width and
+// DOC.indent_unit
+type luaDocs struct {
+	options   *lua.LTable
+	metatable *lua.LTable
+}
+
+func registerLuaDocs(L *lua.LState) *luaDocs {
+	docs := &luaDocs{
+		options:   L.NewTable(),
+		metatable: L.NewTable(),
+	}
+	L.SetFuncs(docs.metatable, map[string]lua.LGFunction{
+		"__concat":   docs.concat,
+		"__tostring": docs.tostring,
+	})
+	L.SetFuncs(docs.options, map[string]lua.LGFunction{
+		"text":   docs.text,
+		"concat": docs.newFunction(DOC_CONCAT),
+		"group":  docs.newFunction(DOC_GROUP),
+		"fill":   docs.fill,
+		"nest":   docs.nest,
+		"indent": docs.indent,
+		"align":  docs.align,
+	})
+	docs.options.RawSetString("line", docs.newValue(L, NewDoc(DOC_LINE, "")))
+	docs.options.RawSetString("softline", docs.newValue(L, NewDoc(DOC_SOFTLINE, "")))
+	docs.options.RawSetString("hardline", docs.newValue(L, NewDoc(DOC_HARDLINE, "")))
+	docs.options.RawSetString("width", lua.LNumber(DEFAULT_DOC_WIDTH))
+	docs.options.RawSetString("indent_unit", lua.LString(DEFAULT_DOC_INDENT_UNIT))
+	L.SetGlobal("DOC", docs.options)
+	L.SetGlobal("__kuuhaku_layout", L.NewFunction(docs.layout))
+	return docs
+}
+
+func (docs *luaDocs) newValue(L *lua.LState, doc *Doc) *lua.LUserData {
+	userdata := L.NewUserData()
+	userdata.Value = doc
+	L.SetMetatable(userdata, docs.metatable)
+	return userdata
+}
+
+// strings and numbers are texts, nil is an empty text, nodes are their formatted value and the lists
+// made by the EBNF operators are the concatenation of their elements
+func (docs *luaDocs) toDoc(L *lua.LState, value lua.LValue) *Doc {
+	switch value := value.(type) {
+	case lua.LString:
+		return NewDoc(DOC_TEXT, string(value))
+	case *lua.LNilType:
+		return NewDoc(DOC_TEXT, "")
+	case *lua.LUserData:
+		switch inner := value.Value.(type) {
+		case *Doc:
+			return inner
+		case *luaNode:
+			return docs.toDoc(L, inner.registry.format(L, inner))
+		}
+	case *lua.LTable:
+		if L.GetMetatable(value) == lua.LNil {
+			var children []*Doc
+			for i := 1; i <= value.Len(); i++ {
+				children = append(children, docs.toDoc(L, value.RawGetInt(i)))
+			}
+			return NewDoc(DOC_CONCAT, "", children...)
+		}
+	}
+	// the groups made by the EBNF operators are converted by __tostring, which may return a doc
+	converted := L.ToStringMeta(value)
+	if converted.Type() != lua.LTString && converted != value {
+		return docs.toDoc(L, converted)
+	}
+	return NewDoc(DOC_TEXT, converted.String())
+}
+
+func (docs *luaDocs) getArgs(L *lua.LState, start int) []*Doc {
+	var children []*Doc
+	for i := start; i <= L.GetTop(); i++ {
+		children = append(children, docs.toDoc(L, L.Get(i)))
+	}
+	return children
+}
+
+func isLuaDoc(value lua.LValue) bool {
+	userdata, ok := value.(*lua.LUserData)
+	if !ok {
+		return false
+	}
+	_, ok = userdata.Value.(*Doc)
+	return ok
+}
+
+// DOC.concat(...) and DOC.group(...) take any number of docs
+func (docs *luaDocs) newFunction(kind DocKind) lua.LGFunction {
+	return func(L *lua.LState) int {
+		L.Push(docs.newValue(L, NewDoc(kind, "", docs.getArgs(L, 1)...)))
+		return 1
+	}
+}
+
+// DOC.text(s) turns a string into a doc, which is only needed to call the methods of a doc on it
+func (docs *luaDocs) text(L *lua.LState) int {
+	L.Push(docs.newValue(L, NewDoc(DOC_TEXT, L.CheckString(1))))
+	return 1
+}
+
+// DOC.nest(n, ...) indents the lines in the docs by n spaces, n may also be the indentation itself
+func (docs *luaDocs) nest(L *lua.LState) int {
+	indent := ""
+	switch value := L.CheckAny(1).(type) {
+	case lua.LNumber:
+		indent = strings.Repeat(" ", int(value))
+	case lua.LString:
+		indent = string(value)
+	default:
+		L.ArgError(1, "number or string expected")
+	}
+	L.Push(docs.newValue(L, NewDoc(DOC_NEST, indent, docs.getArgs(L, 2)...)))
+	return 1
+}
+
+// DOC.indent(...) indents the lines in the docs by DOC.indent_unit
+func (docs *luaDocs) indent(L *lua.LState) int {
+	unit, ok := docs.options.RawGetString("indent_unit").(lua.LString)
+	if !ok {
+		L.RaiseError("DOC.indent_unit must be a string")
+	}
+	L.Push(docs.newValue(L, NewDoc(DOC_NEST, string(unit), docs.getArgs(L, 1)...)))
+	return 1
+}
+
+// DOC.align(name) is a marker that is moved to the same column as the markers of the name on the
+// lines around it, like the = of consecutive assignments. The name may be omitted
+func (docs *luaDocs) align(L *lua.LState) int {
+	L.Push(docs.newValue(L, NewDoc(DOC_ALIGN, L.OptString(1, ""))))
+	return 1
+}
+
+// DOC.fill(parts) takes a list that alternates between contents and separators, the parts may also
+// be passed as the arguments
+func (docs *luaDocs) fill(L *lua.LState) int {
+	var parts []*Doc
+	table, ok := L.Get(1).(*lua.LTable)
+	if ok && L.GetTop() == 1 && L.GetMetatable(table) == lua.LNil {
+		for i := 1; i <= table.Len(); i++ {
+			parts = append(parts, docs.toDoc(L, table.RawGetInt(i)))
+		}
+	} else {
+		parts = docs.getArgs(L, 1)
+	}
+	L.Push(docs.newValue(L, NewDoc(DOC_FILL, "", parts...)))
+	return 1
+}
+
+func (docs *luaDocs) concat(L *lua.LState) int {
+	L.Push(docs.newValue(L, NewDoc(DOC_CONCAT, "", docs.toDoc(L, L.Get(1)), docs.toDoc(L, L.Get(2)))))
+	return 1
+}
+
+func (docs *luaDocs) tostring(L *lua.LState) int {
+	L.Push(lua.LString(docs.render(L, docs.toDoc(L, L.Get(1)))))
+	return 1
+}
+
+// lays out the result of the start symbol, a string is returned as it is
+func (docs *luaDocs) layout(L *lua.LState) int {
+	value := L.Get(1)
+	if value.Type() == lua.LTString {
+		L.Push(value)
+		return 1
+	}
+	L.Push(lua.LString(docs.render(L, docs.toDoc(L, value))))
+	return 1
+}
+
+func (docs *luaDocs) render(L *lua.LState, doc *Doc) string {
+	width, ok := docs.options.RawGetString("width").(lua.LNumber)
+	if !ok || width <= 0 {
+		L.RaiseError("DOC.width must be a positive number")
+	}
+	return doc.Layout(int(width))
+}
@@ -0,0 +1,150 @@
+package kuuhaku_runtime
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_parser"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// a lua error traced back to the grammar
+type LuaError struct {
+	Position    kuuhaku_tokenizer.Position //where the failing lua is in the grammar
+	RuleName    string                     //empty if the error is in the global lua
+	Alternative int
+	Start       kuuhaku_tokenizer.Position //the input span of the node being evaluated, zero in the global lua
+	End         kuuhaku_tokenizer.Position
+	Message     string
+}
+
+func (e LuaError) Error() string {
+	if e.RuleName == "" {
+		return fmt.Sprintf("Lua error (%s) in the global lua: %s", e.Position.Location(), e.Message)
+	}
+	out := fmt.Sprintf("Lua error (%s) in rule %s (alternative %d)", e.Position.Location(), e.RuleName, e.Alternative)
+	if e.Start.Line != 0 {
+		out += fmt.Sprintf(" while evaluating the input from (%d, %d) to (%d, %d)", e.Start.Line, e.Start.Column, e.End.Line, e.End.Column)
+	}
+	return out + ": " + e.Message
+}
+
+func ErrLuaInRule(position kuuhaku_tokenizer.Position, tree *ParseStackTree, message string) *LuaError {
+	return &LuaError{
+		Position:    position,
+		RuleName:    tree.Rule.Name,
+		Alternative: tree.Rule.Alternative,
+		Start:       tree.Position,
+		End:         tree.End,
+		Message:     message,
+	}
+}
+
+func ErrLuaInGlobal(position kuuhaku_tokenizer.Position, message string) *LuaError {
+	return &LuaError{
+		Position: position,
+		Message:  message,
+	}
+}
+
+const luaSegmentMarker = "--@kuuhaku "
+
+// the lines of the chunk that hold the code of one rule, either the lua of the grammar or the code
+// generated when the rule has no replace rule
+type luaSegment struct {
+	literal    *kuuhaku_parser.LuaLiteral //nil for generated code
+	tree       *ParseStackTree
+	lineCount  int
+	markerLine int
+}
+
+// the global lua is loaded as its own chunk, the code of the matches is loaded with DoString
+const luaGlobalChunkName = "<global>"
+
+var luaErrorLineRegex = regexp.MustCompile(`(<string>|<global>):(\d+):`)
+var luaErrorPrefixRegex = regexp.MustCompile(`^(<string>|<global>):\d+:\s*`)
+
+// returns the marker line that is put right before the code of a segment
+func (compiler *luaCompiler) mark(literal *kuuhaku_parser.LuaLiteral, code string) string {
+	compiler.segments = append(compiler.segments, luaSegment{
+		literal:   literal,
+		tree:      compiler.getOwner(),
+		lineCount: strings.Count(code, "\n") + 1,
+	})
+	return luaSegmentMarker + strconv.Itoa(len(compiler.segments)-1) + "\n"
+}
+
+// the line numbers in the error and its stack trace refer to the global lua or to the compiled chunk,
+// the first one that is inside the global lua or a segment decides where the error is reported. Errors
+// that can't be traced back are returned as they are
+func (compiler *luaCompiler) mapLuaError(err error, compiled string, globalLua *kuuhaku_parser.LuaLiteral) error {
+	message := err.Error()
+	trace := ""
+	apiError, ok := err.(*lua.ApiError)
+	if ok {
+		message = apiError.Object.String()
+		trace = apiError.StackTrace
+	}
+
+	for i, line := range strings.Split(compiled, "\n") {
+		id, ok := strings.CutPrefix(line, luaSegmentMarker)
+		if !ok {
+			continue
+		}
+		index, err := strconv.Atoi(id)
+		if err == nil && index < len(compiler.segments) {
+			compiler.segments[index].markerLine = i + 1
+		}
+	}
+
+	for _, match := range luaErrorLineRegex.FindAllStringSubmatch(message+"\n"+trace, -1) {
+		line, _ := strconv.Atoi(match[2])
+		strippedMessage := luaErrorPrefixRegex.ReplaceAllString(message, "")
+		if match[1] == luaGlobalChunkName {
+			return ErrLuaInGlobal(getLuaLinePosition(globalLua, line), strippedMessage)
+		}
+		for _, segment := range compiler.segments {
+			if segment.tree == nil || line <= segment.markerLine || line > segment.markerLine+segment.lineCount {
+				continue
+			}
+			position := segment.tree.Rule.Position
+			if segment.literal != nil {
+				position = getLuaLinePosition(segment.literal, line-segment.markerLine)
+			}
+			return ErrLuaInRule(position, segment.tree, strippedMessage)
+		}
+	}
+	return ErrLua(err.Error())
+}
+
+// the position of a line of the lua literal in the grammar. The first line starts after the `` of a
+// multi statement literal or the ` of a return literal
+func getLuaLinePosition(literal *kuuhaku_parser.LuaLiteral, line int) kuuhaku_tokenizer.Position {
+	position := literal.Position
+	if line > 1 {
+		position.Line += line - 1
+		position.Column = 1
+	} else if literal.Type == kuuhaku_parser.LUA_LITERAL_TYPE_RETURN {
+		position.Column += 1
+	} else {
+		position.Column += 2
+	}
+
+	//the offset of the line in the lua string, the lines before it end with a newline
+	offset := 0
+	for i, before := range strings.Split(literal.LuaString, "\n") {
+		if i >= line-1 {
+			break
+		}
+		offset += len(before) + 1
+	}
+	if literal.Type == kuuhaku_parser.LUA_LITERAL_TYPE_RETURN {
+		position.Raw += 1 + max(offset-len("return "), 0)
+	} else {
+		position.Raw += 2 + offset
+	}
+	return position
+}
@@ -0,0 +1,238 @@
+package kuuhaku_runtime
+
+import (
+	"strconv"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_parser"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+	lua "github.com/yuin/gopher-lua"
+)
+
+type luaNodeState int
+
+const (
+	LUA_NODE_NOT_FORMATTED luaNodeState = iota
+	LUA_NODE_FORMATTING
+	LUA_NODE_FORMATTED
+)
+
+// a child passed to lua in node mode. The compiled code of the child is only run when lua reads its
+// formatted text, thunk is nil until the code of its parent has run
+type luaNode struct {
+	element   Element
+	thunk     *lua.LFunction
+	formatted lua.LValue
+	state     luaNodeState
+	registry  *luaNodeRegistry
+}
+
+type luaNodeRegistry struct {
+	ids       []ParseStackElement //the children wrapped by the compiler, indexed by their id
+	elements  map[ParseStackElement]Element
+	userdata  map[Element]*lua.LUserData
+	metatable *lua.LTable
+	docs      *luaDocs
+}
+
+// in node mode the children that aren't hidden are passed to lua as nodes. The elements of lists and
+// groups are nodes too, the lists and groups themselves stay lua tables
+func (compiler *luaCompiler) wrapNode(child ParseStackElement, compiled string) string {
+	if !compiler.isNodeMode {
+		return compiled
+	}
+	tree, ok := child.(*ParseStackTree)
+	if ok && tree.Rule.Hidden != kuuhaku_parser.NOT_HIDDEN {
+		return compiled
+	}
+	compiler.nodes = append(compiler.nodes, child)
+	return "__kuuhaku_node(" + strconv.Itoa(len(compiler.nodes)-1) + ", function() return " + compiled + " end)"
+}
+
+// defines __kuuhaku_node, which the code compiled in node mode calls with the id of each child and a
+// function that returns its formatted text
+func registerLuaNodes(L *lua.LState, root *ParseStackTree, input string, ids []ParseStackElement, docs *luaDocs) {
+	registry := &luaNodeRegistry{
+		ids:      ids,
+		docs:     docs,
+		elements: make(map[ParseStackElement]Element),
+		userdata: make(map[Element]*lua.LUserData),
+	}
+	newNode(getStartTree(root), input, nil, registry.elements)
+
+	registry.metatable = L.NewTable()
+	L.SetFuncs(registry.metatable, map[string]lua.LGFunction{
+		"__index":    registry.index,
+		"__tostring": registry.tostring,
+		"__concat":   registry.concat,
+	})
+	L.SetGlobal("__kuuhaku_node", L.NewFunction(func(L *lua.LState) int {
+		id := L.CheckInt(1)
+		thunk := L.CheckFunction(2)
+		if id < 0 || id >= len(registry.ids) {
+			L.RaiseError("unknown node %d", id)
+		}
+		userdata := registry.get(L, registry.elements[registry.ids[id]])
+		userdata.Value.(*luaNode).thunk = thunk
+		L.Push(userdata)
+		return 1
+	}))
+}
+
+func (registry *luaNodeRegistry) get(L *lua.LState, element Element) *lua.LUserData {
+	userdata, ok := registry.userdata[element]
+	if ok {
+		return userdata
+	}
+	userdata = L.NewUserData()
+	userdata.Value = &luaNode{element: element, registry: registry}
+	L.SetMetatable(userdata, registry.metatable)
+	registry.userdata[element] = userdata
+	return userdata
+}
+
+// node.rule is nil for terminals, node.text is the input the node matched and node.formatted is what
+// its rule evaluates to. node.children has the terminals and the nodes the rule matched with the
+// elements of lists and groups in their place, node.span has the same fields as NODE
+func (registry *luaNodeRegistry) index(L *lua.LState) int {
+	node := checkLuaNode(L, 1)
+	switch L.CheckString(2) {
+	case "rule":
+		tree, ok := node.element.(*Node)
+		if ok {
+			L.Push(lua.LString(tree.Rule))
+		} else {
+			L.Push(lua.LNil)
+		}
+	case "text":
+		L.Push(lua.LString(node.element.Text()))
+	case "formatted":
+		L.Push(registry.format(L, node))
+	case "children":
+		children := L.NewTable()
+		tree, ok := node.element.(*Node)
+		if ok {
+			for _, child := range getVisibleChildren(tree) {
+				children.Append(registry.get(L, child))
+			}
+		}
+		L.Push(children)
+	case "span":
+		start, end := node.element.Span()
+		L.Push(makeLuaSpan(L, start, end))
+	default:
+		L.Push(lua.LNil)
+	}
+	return 1
+}
+
+func (registry *luaNodeRegistry) tostring(L *lua.LState) int {
+	node := checkLuaNode(L, 1)
+	L.Push(L.ToStringMeta(registry.format(L, node)))
+	return 1
+}
+
+// lets the replace rules written for strings concatenate nodes, a node whose formatted value is a doc
+// concatenates as a doc
+func (registry *luaNodeRegistry) concat(L *lua.LState) int {
+	values := []lua.LValue{registry.unwrap(L, L.Get(1)), registry.unwrap(L, L.Get(2))}
+	if isLuaDoc(values[0]) || isLuaDoc(values[1]) {
+		doc := NewDoc(DOC_CONCAT, "", registry.docs.toDoc(L, values[0]), registry.docs.toDoc(L, values[1]))
+		L.Push(registry.docs.newValue(L, doc))
+		return 1
+	}
+	out := ""
+	for _, value := range values {
+		switch value.Type() {
+		case lua.LTString, lua.LTNumber, lua.LTUserData, lua.LTTable:
+			out += L.ToStringMeta(value).String()
+		default:
+			L.RaiseError("attempt to concatenate a %s value", value.Type().String())
+		}
+	}
+	L.Push(lua.LString(out))
+	return 1
+}
+
+// the formatted value of a node, which may itself be a node
+func (registry *luaNodeRegistry) unwrap(L *lua.LState, value lua.LValue) lua.LValue {
+	for {
+		userdata, ok := value.(*lua.LUserData)
+		if !ok {
+			return value
+		}
+		node, ok := userdata.Value.(*luaNode)
+		if !ok {
+			return value
+		}
+		value = registry.format(L, node)
+	}
+}
+
+func (registry *luaNodeRegistry) format(L *lua.LState, node *luaNode) lua.LValue {
+	switch node.state {
+	case LUA_NODE_FORMATTED:
+		return node.formatted
+	case LUA_NODE_FORMATTING:
+		L.RaiseError("the formatted text of %s is read while it is being formatted", getLuaNodeName(node))
+	}
+	// the code of a node is defined when the code of its parent runs
+	if node.thunk == nil {
+		parent := node.element.Parent()
+		for parent != nil && parent.Hidden != kuuhaku_parser.NOT_HIDDEN {
+			parent = parent.Parent()
+		}
+		if parent != nil {
+			registry.format(L, registry.get(L, parent).Value.(*luaNode))
+		}
+	}
+	if node.thunk == nil {
+		L.RaiseError("%s has no formatted text", getLuaNodeName(node))
+	}
+	node.state = LUA_NODE_FORMATTING
+	L.CallByParam(lua.P{Fn: node.thunk, NRet: 1, Protect: false})
+	node.formatted = L.Get(-1)
+	L.Pop(1)
+	node.state = LUA_NODE_FORMATTED
+	return node.formatted
+}
+
+// the children of the hidden rules are spliced into the children of the rule they were desugared from
+func getVisibleChildren(node *Node) []Element {
+	var children []Element
+	for _, child := range node.Children {
+		childNode, ok := child.(*Node)
+		if ok && childNode.Hidden != kuuhaku_parser.NOT_HIDDEN {
+			children = append(children, getVisibleChildren(childNode)...)
+			continue
+		}
+		children = append(children, child)
+	}
+	return children
+}
+
+func checkLuaNode(L *lua.LState, n int) *luaNode {
+	node, ok := L.CheckUserData(n).Value.(*luaNode)
+	if !ok {
+		L.ArgError(n, "node expected")
+	}
+	return node
+}
+
+func getLuaNodeName(node *luaNode) string {
+	tree, ok := node.element.(*Node)
+	if ok {
+		return "the node " + tree.Rule
+	}
+	return "the terminal " + strconv.Quote(node.element.Text())
+}
+
+func makeLuaSpan(L *lua.LState, start kuuhaku_tokenizer.Position, end kuuhaku_tokenizer.Position) *lua.LTable {
+	span := L.NewTable()
+	span.RawSetString("start_line", lua.LNumber(start.Line))
+	span.RawSetString("start_column", lua.LNumber(start.Column))
+	span.RawSetString("start_offset", lua.LNumber(start.Raw))
+	span.RawSetString("end_line", lua.LNumber(end.Line))
+	span.RawSetString("end_column", lua.LNumber(end.Column))
+	span.RawSetString("end_offset", lua.LNumber(end.Raw))
+	return span
+}
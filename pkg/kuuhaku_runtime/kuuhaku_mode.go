@@ -0,0 +1,37 @@
+package kuuhaku_runtime
+
+import (
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_analyzer"
+)
+
+// returns the terminals that are eligible with the mode on top of the mode stack, nil if every terminal
+// is. Trivia is only skipped when every terminal is eligible, a restricted mode has to match its
+// whitespace itself
+func getEligibleTerminals(parseTable *kuuhaku_analyzer.ParseTable, modes []string) map[string]bool {
+	if len(modes) == 0 {
+		return nil
+	}
+	mode := parseTable.Modes[modes[len(modes)-1]]
+	if mode == nil {
+		return nil
+	}
+	return mode.Terminals
+}
+
+// shifting a terminal pops the mode on top if the terminal pops it, otherwise it pushes the mode the
+// terminal pushes. The mode stack is never changed in place since GLR forks share it
+func shiftMode(parseTable *kuuhaku_analyzer.ParseTable, modes []string, terminal string) []string {
+	if len(modes) > 0 {
+		mode := parseTable.Modes[modes[len(modes)-1]]
+		if mode != nil && mode.Pops[terminal] {
+			return modes[:len(modes)-1]
+		}
+	}
+	pushed := parseTable.Pushes[terminal]
+	if pushed == "" {
+		return modes
+	}
+	out := make([]string, len(modes), len(modes)+1)
+	copy(out, modes)
+	return append(out, pushed)
+}
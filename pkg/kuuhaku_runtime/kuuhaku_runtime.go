@@ -21,12 +21,16 @@ type ParseStackElement interface {
 	GetType() ParseStackElementType
 	GetString() string
 	GetState() int
+	GetPosition() kuuhaku_tokenizer.Position
+	GetEnd() kuuhaku_tokenizer.Position
 }
 
 type ParseStackTree struct {
 	Children *[]ParseStackElement
 	Rule *kuuhaku_parser.Rule
 	State  int
+	Position kuuhaku_tokenizer.Position //where the first token starts, an empty tree is right after the token before it
+	End      kuuhaku_tokenizer.Position //the position right after the last token
 }
 
 func (_ *ParseStackTree) GetType() ParseStackElementType {
@@ -37,6 +41,14 @@ func (p *ParseStackTree) GetState() int {
 	return p.State;
 }
 
+func (p *ParseStackTree) GetPosition() kuuhaku_tokenizer.Position {
+	return p.Position
+}
+
+func (p *ParseStackTree) GetEnd() kuuhaku_tokenizer.Position {
+	return p.End
+}
+
 func (p *ParseStackTree) GetString() string {
 	out := "["
 	for i, child := range *p.Children {
@@ -50,8 +62,13 @@ func (p *ParseStackTree) GetString() string {
 }
 
 type ParseStackTerminal struct {
-	String string
-	State  int
+	String         string
+	State          int
+	LeadingTrivia  string //the skipped trivia before the token, unescaped
+	TrailingTrivia string //the skipped trivia after the token up to the end of its line, unescaped
+	Position       kuuhaku_tokenizer.Position //where the token starts in the input
+	End            kuuhaku_tokenizer.Position //the position right after the token
+	Regex          string                     //the terminal that matched the token
 }
 
 func (_ *ParseStackTerminal) GetType() ParseStackElementType {
@@ -66,11 +83,20 @@ func (p *ParseStackTerminal) GetState() int {
 	return p.State;
 }
 
+func (p *ParseStackTerminal) GetPosition() kuuhaku_tokenizer.Position {
+	return p.Position
+}
+
+func (p *ParseStackTerminal) GetEnd() kuuhaku_tokenizer.Position {
+	return p.End
+}
+
 type RuntimeErrorType int
 
 const (
 	PARSE_STACK_IS_NOT_EMPTY RuntimeErrorType = iota
 	REDUCE_RULE_IS_NOT_MATCHING
+	GLR_STEP_LIMIT_EXCEEDED
 )
 
 type EvalErrorType int
@@ -79,6 +105,7 @@ const (
 	START_SYMBOL_WITH_PARAMS EvalErrorType = iota
 	INVALID_ARG_LENGTH
 	EXEC_ERROR
+	INVALID_HOOK_RESULT
 )
 
 type RuntimeError struct {
@@ -147,6 +174,14 @@ func ErrReduceRuleIsNotMatching(position kuuhaku_tokenizer.Position) *RuntimeErr
 	}
 }
 
+func ErrGLRStepLimitExceeded(position kuuhaku_tokenizer.Position) *RuntimeError {
+	return &RuntimeError{
+		Message:  "The GLR parser exceeded its step limit, the grammar might contain a cycle of reductions",
+		Position: position,
+		Type:     GLR_STEP_LIMIT_EXCEEDED,
+	}
+}
+
 func ErrLua(luaError string) *EvalError {
 	return &EvalError{
 		Message:  "Encountered an error while executing Lua chunk:\n\t" + luaError,
@@ -168,7 +203,36 @@ func ErrStartSymbolWithParams(startSymbol string) *EvalError {
 	}
 }
 
+// Format runs the lua of the grammar unless isRun is false, then the parse stacks are printed instead.
+// See FormatFile for the hooks
 func Format(input string, format *kuuhaku_analyzer.AnalyzerResult, isRun bool, isDebug bool) (string, error) {
+	if isRun {
+		return FormatFile(input, format, &FormatContext{InputSize: len(input)}, isDebug)
+	}
+	return runParseTables(input, format, isDebug, func(parseStack *[]ParseStackElement) (string, error) {
+		return parseStackToString(parseStack), nil
+	})
+}
+
+// ParseTrees parses the input without running any lua and returns the tree of each match, there is
+// only one tree unless the grammar is in search mode
+func ParseTrees(input string, format *kuuhaku_analyzer.AnalyzerResult, isDebug bool) ([]*ParseStackTree, error) {
+	var trees []*ParseStackTree
+	_, err := runParseTables(input, format, isDebug, func(parseStack *[]ParseStackElement) (string, error) {
+		tree, _ := (*parseStack)[0].(*ParseStackTree)
+		trees = append(trees, tree)
+		return "", nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return trees, nil
+}
+
+// turns the parse stack of a match into its output
+type parseStackEmitter func(parseStack *[]ParseStackElement) (string, error)
+
+func runParseTables(input string, format *kuuhaku_analyzer.AnalyzerResult, isDebug bool, emit parseStackEmitter) (string, error) {
 	var currPos kuuhaku_tokenizer.Position
 	currPos.Line = 1
 	currPos.Column = 1
@@ -177,11 +241,22 @@ func Format(input string, format *kuuhaku_analyzer.AnalyzerResult, isRun bool, i
 		isThereSuccess := false
 		//TODO: change this to only one parse table
 		for _, parseTable := range format.ParseTables {
-			var globalLua kuuhaku_parser.LuaLiteral
-			if format.GlobalLua != nil {
-				globalLua = *format.GlobalLua
+			var parseStack []ParseStackElement
+			var res string
+			var resPos kuuhaku_tokenizer.Position
+			var err error
+			if format.IsGLRMode {
+				parseStack, resPos, err = runParseTableGLR(input, currPos, &parseTable, format.Preferences, format.IsSearchMode, isDebug)
+			} else {
+				parseStack, resPos, err = runParseTable(input, currPos, &parseTable, format.IsSearchMode, isDebug)
+			}
+			// an empty match in search mode would be found again at the same position forever
+			if err == nil && format.IsSearchMode && resPos.Raw == currPos.Raw {
+				continue
+			}
+			if err == nil {
+				res, err = emit(&parseStack)
 			}
-			res, resPos, err := runParseTable(input, currPos, &parseTable, isRun, globalLua, isDebug)
 			if err == nil {
 				isThereSuccess = true
 				currPos = resPos
@@ -242,18 +317,20 @@ func addToPositionFromSlicedString(prevPos kuuhaku_tokenizer.Position, sliced st
 	}
 }
 
-func runParseTable(input string, pos kuuhaku_tokenizer.Position, parseTable *kuuhaku_analyzer.ParseTable, isRun bool, globalLua kuuhaku_parser.LuaLiteral, printCompiled bool) (string, kuuhaku_tokenizer.Position, error) {
+func runParseTable(input string, pos kuuhaku_tokenizer.Position, parseTable *kuuhaku_analyzer.ParseTable, isSearchMode bool, printCompiled bool) ([]ParseStackElement, kuuhaku_tokenizer.Position, error) {
 	if printCompiled {
 		fmt.Println("Input length: " + strconv.Itoa(len(input)))
 	}
 	var parseStack []ParseStackElement
+	var lastTerminal *ParseStackTerminal
+	var modes []string
 	currState := 0
 	lookahead := ""
 	lookaheadRegex := ""
 
 	var expected []string
 	for true {
-		lookaheadFound := false
+		var lookaheadFound bool
 		currRow := parseTable.States[currState]
 
 		if pos.Raw > len(input) {
@@ -263,14 +340,10 @@ func runParseTable(input string, pos kuuhaku_tokenizer.Position, parseTable *kuu
 				}
 			}
 			//TODO: might return all of the strings inside the parse stack combined on error in the future
-			return "", pos, ErrSyntaxError(pos, &expected)
+			return nil, pos, ErrSyntaxError(pos, &expected)
 		}
 
 
-		slicedInput := input[pos.Raw:]
-		tmpPos := pos
-
-		expected = []string{}
 		if printCompiled {
 			fmt.Println("[")
 			for _, terminal := range parseTable.Terminals {
@@ -278,24 +351,19 @@ func runParseTable(input string, pos kuuhaku_tokenizer.Position, parseTable *kuu
 			}
 			fmt.Println("]")
 		}
-		for _, terminal := range parseTable.Terminals {
-			if currRow.ActionTable[terminal.Terminal] != nil && terminal.Regexp != nil {
-				expected = append(expected, terminal.Terminal)
-				loc := terminal.Regexp.FindStringIndex(slicedInput)
-				if loc == nil {
-					continue
-				} else {
-					lookahead = slicedInput[0:loc[1]]
-					lookaheadRegex = terminal.Terminal
-					tmpPos = addToPositionFromSlicedString(pos, lookahead)
-					lookaheadFound = true
-					break
-				}
-			}
+		// in search mode a match can't start with trivia, the text before the match is kept as is
+		eligible := getEligibleTerminals(parseTable, modes)
+		trivia := ""
+		tokenPos := pos
+		if eligible == nil && (!isSearchMode || lastTerminal != nil) {
+			trivia, tokenPos = skipTrivia(input, pos, parseTable.Trivia)
 		}
-		slicedInput = input[pos.Raw:]
+
+		var tmpPos kuuhaku_tokenizer.Position
+		lookahead, lookaheadRegex, tmpPos, lookaheadFound, expected = matchLookahead(input, tokenPos, parseTable, &currRow, eligible)
+		slicedInput := input[tokenPos.Raw:]
 		if printCompiled {
-			fmt.Println("Position: " + strconv.Itoa(pos.Raw))
+			fmt.Println("Position: " + strconv.Itoa(tokenPos.Raw))
 			slicedInputTo3 := ""
 			if len(slicedInput) > 4 {
 				slicedInputTo3 = slicedInput[:3]
@@ -307,7 +375,7 @@ func runParseTable(input string, pos kuuhaku_tokenizer.Position, parseTable *kuu
 			fmt.Println("Lookahead: " + lookahead)
 			fmt.Println("LookaheadRegex: " + lookaheadRegex)
 		}
-		if (lookaheadFound && pos.Raw < len(input)) || (lookaheadFound && pos.Raw >= len(input) && currRow.EndReduceRule == nil) {
+		if (lookaheadFound && tokenPos.Raw < len(input)) || (lookaheadFound && tokenPos.Raw >= len(input) && currRow.EndReduceRule == nil) {
 			currActionCell := currRow.ActionTable[lookaheadRegex]
 			if currActionCell != nil {
 				if currActionCell.Action == kuuhaku_analyzer.SHIFT {
@@ -317,10 +385,17 @@ func runParseTable(input string, pos kuuhaku_tokenizer.Position, parseTable *kuu
 					}
 					content := strconv.Quote(lookahead)
 					content = content[1:len(content)-1]
-					parseStack = append(parseStack, &ParseStackTerminal {
-						String: content,
-						State:  currState,
-					})
+					terminal := &ParseStackTerminal {
+						String:   content,
+						State:    currActionCell.ShiftState,
+						Position: tokenPos,
+						End:      tmpPos,
+						Regex:    lookaheadRegex,
+					}
+					attachTrivia(lastTerminal, terminal, trivia)
+					lastTerminal = terminal
+					modes = shiftMode(parseTable, modes, lookaheadRegex)
+					parseStack = append(parseStack, terminal)
 					currState = currActionCell.ShiftState
 					pos = tmpPos
 				} else if currActionCell.Action == kuuhaku_analyzer.REDUCE {
@@ -331,11 +406,11 @@ func runParseTable(input string, pos kuuhaku_tokenizer.Position, parseTable *kuu
 						fmt.Println("New state: " + strconv.Itoa(currState))
 					}
 					if err != nil {
-						return "", pos, err
+						return nil, pos, err
 					}
 				}
 			} else {
-				return "", pos, ErrSyntaxError(pos, &expected)
+				return nil, tokenPos, ErrSyntaxError(tokenPos, &expected)
 			}
 		} else {
 			if currRow.EndReduceRule != nil {
@@ -351,28 +426,58 @@ func runParseTable(input string, pos kuuhaku_tokenizer.Position, parseTable *kuu
 					}
 				}
 				if err != nil {
-					return "", pos, err
+					return nil, pos, err
 				}
 			} else {
 				//printParseStack(&parseStack)
-				return "", pos, ErrSyntaxError(pos, &expected)
+				return nil, tokenPos, ErrSyntaxError(tokenPos, &expected)
 			}
 		}
 	}
+	pos = consumeFinalTrivia(input, pos, parseTable.Trivia, lastTerminal, isSearchMode)
 	if len(parseStack) != 1 {
-		return "", pos, ErrParseStackIsNotEmpty(pos)
+		return nil, pos, ErrParseStackIsNotEmpty(pos)
 	}
-	out := ""
-	if isRun {
-		var err error
-		out, err = runParseStack(&parseStack, globalLua, printCompiled)
-		if err != nil {
-			return "", pos, err
+	return parseStack, pos, nil
+}
+
+// returns the first terminal by precedence that has an action on the current row, is eligible in the
+// current lexer mode and matches the input at pos, together with the position after it and all of the
+// terminals that were tried. A nil eligible map means every terminal is eligible. If the first match is
+// a longest match terminal, the longest match among all of the terminals wins instead and the
+// precedence only breaks ties
+func matchLookahead(input string, pos kuuhaku_tokenizer.Position, parseTable *kuuhaku_analyzer.ParseTable, currRow *kuuhaku_analyzer.ParseTableState, eligible map[string]bool) (string, string, kuuhaku_tokenizer.Position, bool, []string) {
+	slicedInput := input[pos.Raw:]
+	var expected []string
+	var matched *kuuhaku_analyzer.TerminalList
+	matchedLength := 0
+	for i, terminal := range parseTable.Terminals {
+		if eligible != nil && !eligible[terminal.Terminal] {
+			continue
+		}
+		if currRow.ActionTable[terminal.Terminal] != nil && terminal.Regexp != nil {
+			expected = append(expected, terminal.Terminal)
+			loc := terminal.Regexp.FindStringIndex(slicedInput)
+			if loc == nil {
+				continue
+			}
+			if matched == nil {
+				matched = &parseTable.Terminals[i]
+				matchedLength = loc[1]
+				if !terminal.IsLongestMatch {
+					break
+				}
+			} else if loc[1] > matchedLength {
+				matched = &parseTable.Terminals[i]
+				matchedLength = loc[1]
+			}
 		}
-	} else {
-		out = parseStackToString(&parseStack)
 	}
-	return out, pos, nil
+	if matched == nil {
+		return "", "", pos, false, expected
+	}
+	lookahead := slicedInput[0:matchedLength]
+	return lookahead, matched.Terminal, addToPositionFromSlicedString(pos, lookahead), true, expected
 }
 
 func printParseStack(parseStack *[]ParseStackElement) {
@@ -397,9 +502,56 @@ func parseStackToString(parseStack *[]ParseStackElement) string {
 	return out
 }
 
-func runParseStack(parseStack *[]ParseStackElement, globalLua kuuhaku_parser.LuaLiteral, printCompiled bool) (string, error) {
-	compiled := globalLua.LuaString + "\nret = tostring("
-	compiledNodes, err := compileNode(&(*parseStack)[0], true, "") 
+// turns the lists and groups made by the EBNF operators into strings for the default concatenation
+const luaPrelude = `local __kuuhaku_tostring
+__kuuhaku_tostring = function(value)
+	if value == nil then
+		return ""
+	end
+	-- docs and nodes concatenate by themselves
+	if type(value) == "userdata" then
+		return value
+	end
+	if type(value) == "table" and getmetatable(value) == nil then
+		local out = ""
+		for _, element in ipairs(value) do
+			out = out .. __kuuhaku_tostring(element)
+		end
+		return out
+	end
+	return tostring(value)
+end
+local __kuuhaku_no_trivia = {leading = "", trailing = ""}
+local __kuuhaku_trivia_metatable = {__index = function()
+	return __kuuhaku_no_trivia
+end}
+local function __kuuhaku_trivia(trivia)
+	return setmetatable(trivia, __kuuhaku_trivia_metatable)
+end
+local TRIVIA = __kuuhaku_trivia({})
+local function __kuuhaku_collect(collect)
+	collect()
+end`
+
+// the globals the runtime defines before the global lua block runs
+func registerLuaGlobals(L *lua.LState) *luaDocs {
+	docs := registerLuaDocs(L)
+	L.SetGlobal("COLLECTED", L.NewTable())
+	return docs
+}
+
+// runs the code of one match in the lua state of the file, the globals are already registered and the
+// global lua already ran, see FormatFile
+func runParseStack(L *lua.LState, docs *luaDocs, parseStack *[]ParseStackElement, input string, format *kuuhaku_analyzer.AnalyzerResult, printCompiled bool) (string, error) {
+	var globalLua kuuhaku_parser.LuaLiteral
+	if format.GlobalLua != nil {
+		globalLua = *format.GlobalLua
+	}
+	compiler := &luaCompiler{isNodeMode: format.IsNodeMode}
+	compiled := luaPrelude + "\n"
+	compiled += compiler.compileCollect((*parseStack)[0], input)
+	compiled += "ret = __kuuhaku_layout("
+	compiledNodes, err := compiler.compileNode(&(*parseStack)[0], true, "") 
 	compiled += compiledNodes
 	compiled += ")"
 	if printCompiled {
@@ -408,89 +560,141 @@ func runParseStack(parseStack *[]ParseStackElement, globalLua kuuhaku_parser.Lua
 	if err != nil {
 		return "", err
 	}
-	L := lua.NewState()
-	defer L.Close()
-	err = L.DoString(compiled)
+	if compiler.isNodeMode {
+		registerLuaNodes(L, (*parseStack)[0].(*ParseStackTree), input, compiler.nodes, docs)
+	}
+	// the globals carry over from one match to the next but what a match collects is its own
+	L.SetGlobal("COLLECTED", L.NewTable())
+	chunk, err := L.LoadString(compiled)
+	if err == nil {
+		if env, ok := L.GetGlobal(luaLocalEnvName).(*lua.LTable); ok {
+			chunk.Env = env
+		}
+		err = L.CallByParam(lua.P{Fn: chunk, NRet: 0, Protect: true})
+	}
 	if err != nil {
-		fmt.Println("Error executing Lua code:", err)
-		return "", ErrLua(err.Error())
+		if printCompiled {
+			fmt.Println("Error executing Lua code:", err)
+		}
+		return "", compiler.mapLuaError(err, compiled, &globalLua)
 	}
 	ret := L.GetGlobal("ret").String()
 	return ret, nil
 }
 
-func compileNode(node *ParseStackElement, isFirst bool, passedArgs string) (string, error) {
+type compiledBinding struct {
+	name   string
+	isList bool
+}
+
+// compiles the parse stack into one lua chunk. The code of each rule is preceded by a marker so lua
+// errors can be traced back to the rule, see kuuhaku_lua_error.go. In node mode the children are
+// passed as nodes whose code runs when they are read, see kuuhaku_lua_node.go
+type luaCompiler struct {
+	segments   []luaSegment
+	owners     []*ParseStackTree //the trees of the rules that aren't hidden that are being compiled
+	isNodeMode bool
+	nodes      []ParseStackElement //the children passed to lua as nodes, indexed by their id
+}
+
+// hidden rules are part of the rule they were desugared from, their code is reported as that rule
+func (compiler *luaCompiler) getOwner() *ParseStackTree {
+	if len(compiler.owners) == 0 {
+		return nil
+	}
+	return compiler.owners[len(compiler.owners)-1]
+}
+
+func (compiler *luaCompiler) compileNode(node *ParseStackElement, isFirst bool, passedArgs string) (string, error) {
 	out := ""
 	if (*node).GetType() == PARSE_STACK_ELEMENT_TYPE_TERMINAL {
 		terminal, _ := (*node).(*ParseStackTerminal)
 		out += "\"" + terminal.String + "\""
 	} else if (*node).GetType() == PARSE_STACK_ELEMENT_TYPE_TREE {
 		tree, _ := (*node).(*ParseStackTree)
-		out += "(function(\n"
-		for i, params := range tree.Rule.ArgList {
-			if i != 0 {
-				out += ","
-			}	
-			out += params.Name 
+		if tree.Rule.Hidden == kuuhaku_parser.HIDDEN_LIST {
+			return compiler.compileList(tree, passedArgs)
+		}
+		if tree.Rule.Hidden == kuuhaku_parser.NOT_HIDDEN {
+			compiler.owners = append(compiler.owners, tree)
+			defer func() {
+				compiler.owners = compiler.owners[:len(compiler.owners)-1]
+			}()
 		}
 
+		out += "(function(\n"
+		out += compileParams(tree.Rule)
 		out += ")\n"
 		
 		//we put the parameters that will be passed to the match rule functions here
 
-		identifierCounts := make(map[string]int)
-		var allVar []string
+		var allVar []compiledBinding
 		for i, child := range *tree.Children {
-			identifier, ok := tree.Rule.MatchRules[i].(kuuhaku_parser.Identifier)
-			if ok {
-				identifierCounts[identifier.Name] += 1
-				varName := identifier.Name + strconv.Itoa(identifierCounts[identifier.Name])
-				allVar = append(allVar, varName)
-				var passingArgs string
-				childTree, ok2 := child.(*ParseStackTree)
-				if ok2 {
-					if len(childTree.Rule.ArgList) != len(identifier.ArgList) {
-						if isFirst {
-							return "", ErrStartSymbolWithParams(childTree.Rule.Name)
-						} else {
-							return "", ErrInvalidArgLength(childTree.Rule.Name, tree.Rule.Name)
-						}
-					}
-					for j, arg := range identifier.ArgList {
-						if j > 0 {
-							passingArgs += ",\n"
-						}
-						passingArgs += "(function()\n" + arg.LuaString + "\nend)()"
-					}
-				}
-				compiledNode, err := compileNode(&child, false, passingArgs)
-				if err != nil {
-					return "", err
-				}
-				out += "local " + varName + " = " + compiledNode
+			matchRule := tree.Rule.MatchRules[i]
+			varName := kuuhaku_parser.GetBinding(matchRule)
+			childTree, isTree := child.(*ParseStackTree)
+			allVar = append(allVar, compiledBinding{
+				name:   varName,
+				isList: isTree && childTree.Rule.Hidden == kuuhaku_parser.HIDDEN_LIST,
+			})
+			compiledNode, err := compiler.compileChild(child, matchRule, tree, isFirst)
+			if err != nil {
+				return "", err
+			}
+			out += "local " + varName + " = " + compiledNode
+		}
+		for _, absent := range tree.Rule.AbsentBindings {
+			if absent.IsList {
+				out += "\nlocal " + absent.Name + " = {}"
 			} else {
-				varName := "LITERAL" + strconv.Itoa(i+1)
-				allVar = append(allVar, varName)
-				compiledNode, err := compileNode(&child, false, "")
-				if err != nil {
-					return "", err
-				}
-				out += "local " + varName + " = " + compiledNode
+				out += "\nlocal " + absent.Name + " = nil"
 			}
 		}
 
+		if tree.Rule.ReplaceRule != nil {
+			out += compileTrivia(tree, allVar)
+			out += compileNodeSpan(tree)
+		}
+
 		out += "\n"
+		code := ""
 		if tree.Rule.ReplaceRule != nil {
-			out += tree.Rule.ReplaceRule.LuaString
+			code += tree.Rule.ReplaceRule.LuaString
+		} else if tree.Rule.Hidden == kuuhaku_parser.HIDDEN_GROUP {
+			code += "local group = {"
+			for i, binding := range allVar {
+				if i != 0 {
+					code += ", "
+				}
+				code += binding.name + " = " + binding.name
+			}
+			for _, absent := range tree.Rule.AbsentBindings {
+				code += ", " + absent.Name + " = " + absent.Name
+			}
+			code += "}\nreturn setmetatable(group, {__tostring = function()\nreturn "
+			for i, binding := range allVar {
+				if i != 0 {
+					code += ".."
+				}
+				code += "__kuuhaku_tostring(" + binding.name + ")"
+			}
+			code += "\nend})"
+		} else if len(allVar) == 0 {
+			code += "return \"\""
 		} else {
-			out += "return "
-			for i, varName := range allVar {
+			code += "return "
+			for i, binding := range allVar {
 				if i != 0 {
-					out += ".."	
+					code += ".."	
+				}
+				if binding.isList {
+					code += "__kuuhaku_tostring(" + binding.name + ")"
+				} else {
+					code += binding.name
 				}
-				out += varName
 			}
 		}
+		out += compiler.mark(tree.Rule.ReplaceRule, code) + code
 		out += "\nend)(\n"
 		out += passedArgs
 		out += ")"
@@ -498,6 +702,87 @@ func compileNode(node *ParseStackElement, isFirst bool, passedArgs string) (stri
 	return out, nil
 }
 
+func compileParams(rule *kuuhaku_parser.Rule) string {
+	out := ""
+	for i, params := range rule.ArgList {
+		if i != 0 {
+			out += ","
+		}	
+		out += params.Name 
+	}
+	return out
+}
+
+// the NODE table of a rule holds the span of the input it matched, the offsets are 0-based byte
+// offsets and the end is the position right after the last token
+func compileNodeSpan(tree *ParseStackTree) string {
+	out := "\nlocal NODE = {"
+	out += "start_line = " + strconv.Itoa(tree.Position.Line) + ", start_column = " + strconv.Itoa(tree.Position.Column) + ", start_offset = " + strconv.Itoa(tree.Position.Raw) + ", "
+	out += "end_line = " + strconv.Itoa(tree.End.Line) + ", end_column = " + strconv.Itoa(tree.End.Column) + ", end_offset = " + strconv.Itoa(tree.End.Raw)
+	return out + "}"
+}
+
+// compiles a child of the tree parent, which is matched by matchRule
+func (compiler *luaCompiler) compileChild(child ParseStackElement, matchRule kuuhaku_parser.MatchRule, parent *ParseStackTree, isFirst bool) (string, error) {
+	identifier, ok := matchRule.(kuuhaku_parser.Identifier)
+	if !ok {
+		compiled, err := compiler.compileNode(&child, false, "")
+		return compiler.wrapNode(child, compiled), err
+	}
+
+	var passingArgs string
+	childTree, ok := child.(*ParseStackTree)
+	if ok {
+		if len(childTree.Rule.ArgList) != len(identifier.ArgList) {
+			if isFirst {
+				return "", ErrStartSymbolWithParams(childTree.Rule.Name)
+			} else {
+				return "", ErrInvalidArgLength(childTree.Rule.Name, parent.Rule.Name)
+			}
+		}
+		for j := range identifier.ArgList {
+			arg := &identifier.ArgList[j]
+			if j > 0 {
+				passingArgs += ",\n"
+			}
+			passingArgs += "(function()\n" + compiler.mark(arg, arg.LuaString) + arg.LuaString + "\nend)()"
+		}
+	}
+	compiled, err := compiler.compileNode(&child, false, passingArgs)
+	return compiler.wrapNode(child, compiled), err
+}
+
+// a hidden list rule is left recursive, the elements of the whole recursion are collected into one
+// lua table. The nested list rules take the same parameters so they can share one function
+func (compiler *luaCompiler) compileList(tree *ParseStackTree, passedArgs string) (string, error) {
+	out := "(function(\n" + compileParams(tree.Rule) + ")\nlocal list = {}\n"
+	var elements []ParseStackElement
+	var elementMatchRules []kuuhaku_parser.MatchRule
+	var elementParents []*ParseStackTree
+	curr := tree
+	for curr != nil {
+		children := *curr.Children
+		last := len(children) - 1
+		elements = append([]ParseStackElement{children[last]}, elements...)
+		elementMatchRules = append([]kuuhaku_parser.MatchRule{curr.Rule.MatchRules[last]}, elementMatchRules...)
+		elementParents = append([]*ParseStackTree{curr}, elementParents...)
+		curr = nil
+		if last > 0 {
+			curr, _ = children[0].(*ParseStackTree)
+		}
+	}
+
+	for i, element := range elements {
+		compiledElement, err := compiler.compileChild(element, elementMatchRules[i], elementParents[i], false)
+		if err != nil {
+			return "", err
+		}
+		out += "table.insert(list, " + compiledElement + ")\n"
+	}
+	out += "return list\nend)(\n" + passedArgs + ")"
+	return out, nil
+}
+
 func copyParseStack(parseStack []ParseStackElement) *[]ParseStackElement {
 	var newParseStack []ParseStackElement
 	for _, e := range parseStack {
@@ -526,6 +811,8 @@ func copyParseStackTreeRecursive(e *ParseStackElement) *ParseStackTree {
 			Children: &children,
 			Rule: parseStackTree.Rule,
 			State: parseStackTree.State,
+			Position: parseStackTree.Position,
+			End: parseStackTree.End,
 		}
 	}
 	return nil
@@ -561,11 +848,35 @@ func applyRule(parseTable *kuuhaku_analyzer.ParseTable, rule *kuuhaku_parser.Rul
 		nextState = 0
 	}
 
+	start, end := getChildrenSpan(*targetStack, pos)
 	*parseStack = append(*parseStack, &ParseStackTree{
 		Children: targetStack,
 		Rule: rule,
 		State:  nextState,
+		Position: start,
+		End: end,
 	})
 
 	return nextState, nil
 }
+
+// the span from the first to the last child that isn't empty, pos is the position after the last token
+// that was shifted which is where the tree is if all of the children are empty
+func getChildrenSpan(children []ParseStackElement, pos kuuhaku_tokenizer.Position) (kuuhaku_tokenizer.Position, kuuhaku_tokenizer.Position) {
+	var first, last ParseStackElement
+	for _, child := range children {
+		if child.GetEnd().Raw > child.GetPosition().Raw {
+			if first == nil {
+				first = child
+			}
+			last = child
+		}
+	}
+	if first == nil {
+		if len(children) != 0 {
+			return children[0].GetPosition(), children[0].GetPosition()
+		}
+		return pos, pos
+	}
+	return first.GetPosition(), last.GetEnd()
+}
@@ -1,8 +1,13 @@
 package kuuhaku_runtime
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/ciii1/kuuhaku/internal/helper"
@@ -351,3 +356,812 @@ func TestRunArray(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestRunGLR(t *testing.T) {
+	println("TestRunGLR:")
+	res := analyzeGrammar(t,
+		"GLR_MODE " +
+		"E{E PLUS E}" +
+		"E{N}" +
+		"N{<[0-9]>}" +
+		"PLUS{<\\+>}",
+	)
+	if len(res.Conflicts) == 0 {
+		println("Expected the conflicts to be kept in the parse table")
+		t.Fatal()
+	}
+	strRes, err := Format("1+2+3", &res, false, false)
+
+	if err != nil {
+		println("Unexpected runtime error:")
+		println(err.Error())
+		t.Fatal()
+	}
+
+	if strRes != "[[[[[1]],[+],[[2]]],[+],[[3]]]]" {
+		println("Expected the string to be \"[[[[[1]],[+],[[2]]],[+],[[3]]]]\", got \"" + strRes + "\"")
+		t.Fatal()
+	}
+}
+
+func TestRunGLRPreference(t *testing.T) {
+	println("TestRunGLRPreference:")
+	res := analyzeGrammar(t,
+		"GLR_MODE PREFER(Call) " +
+		"S{Decl = `\"decl\"`}" +
+		"S{Call = `\"call\"`}" +
+		"Decl{ID OPEN ID CLOSE}" +
+		"Call{ID OPEN ID CLOSE}" +
+		"ID{<[a-z]+>}" +
+		"OPEN{<\\(>}" +
+		"CLOSE{<\\)>}",
+	)
+	strRes, err := Format("foo(bar)", &res, true, false)
+
+	if err != nil {
+		println("Unexpected runtime error:")
+		println(err.Error())
+		t.Fatal()
+	}
+
+	if strRes != "call" {
+		println("Expected the result to be call, got " + strRes)
+		t.Fatal()
+	}
+}
+
+func TestRunEBNF(t *testing.T) {
+	println("TestRunEBNF:")
+	res := analyzeGrammar(t,
+		"Array{OPEN W (ID W)* CLOSE = ``" +
+		"local out = \"[\" " +
+		"for _, element in ipairs(GROUP1) do out = out .. element.ID1 .. \";\" end " +
+		"return out .. \"]\"``}" +
+		"ID{<[a-z]+>}" +
+		"OPEN{<\\{>}" +
+		"CLOSE{<\\}>}" +
+		"W{<[ ]*>}",
+	)
+
+	tests := map[string]string{
+		"{ a b  c }": "[a;b;c;]",
+		"{}":         "[]",
+	}
+	for input, expected := range tests {
+		strRes, err := Format(input, &res, true, false)
+		if err != nil {
+			println("Unexpected runtime error:")
+			println(err.Error())
+			t.Fatal()
+		}
+		if strRes != expected {
+			println("Expected the result to be " + expected + ", got " + strRes)
+			t.Fatal()
+		}
+	}
+}
+
+func TestRunEBNFDefaults(t *testing.T) {
+	println("TestRunEBNFDefaults:")
+	res := analyzeGrammar(t,
+		"S{Item+ SEMI? = `table.concat(Item1, \",\") .. tostring(SEMI1)`}" +
+		"S{DOT (Item Item)+}" +
+		"Item{<[a-z]>}" +
+		"SEMI{<;>}" +
+		"DOT{<\\.>}",
+	)
+
+	tests := map[string]string{
+		"abc":   "a,b,cnil",
+		"ab;":   "a,b;",
+		".abcd": ".abcd",
+	}
+	for input, expected := range tests {
+		strRes, err := Format(input, &res, true, false)
+		if err != nil {
+			println("Unexpected runtime error:")
+			println(err.Error())
+			t.Fatal()
+		}
+		if strRes != expected {
+			println("Expected the result to be " + expected + ", got " + strRes)
+			t.Fatal()
+		}
+	}
+}
+
+func TestRunMixed(t *testing.T) {
+	println("TestRunMixed:")
+	res := analyzeGrammar(t,
+		"Call{ID <\\(> Args <\\)> = `ID1 .. LITERAL1 .. \" \" .. Args1 .. \" \" .. LITERAL2`}" +
+		"Args{Args <,> ID = `Args1 .. \";\" .. ID1`}" +
+		"Args{ID}" +
+		"ID{<[a-z]+>}",
+	)
+	strRes, err := Format("foo(a,b,c)", &res, true, false)
+
+	if err != nil {
+		println("Unexpected runtime error:")
+		println(err.Error())
+		t.Fatal()
+	}
+
+	if strRes != "foo( a;b;c )" {
+		println("Expected the result to be foo( a;b;c ), got " + strRes)
+		t.Fatal()
+	}
+}
+
+func TestRunNamedBindings(t *testing.T) {
+	println("TestRunNamedBindings:")
+	res := analyzeGrammar(t,
+		"Pair{key:ID <\\s*=\\s*> value:ID rest:(<,> item:ID)* = ``" +
+		"local out = value .. \"=\" .. key " +
+		"for _, element in ipairs(rest) do out = out .. \",\" .. element.item end " +
+		"return out``}" +
+		"ID{<[a-z]+>}",
+	)
+	strRes, err := Format("a = b,c,d", &res, true, false)
+
+	if err != nil {
+		println("Unexpected runtime error:")
+		println(err.Error())
+		t.Fatal()
+	}
+
+	if strRes != "b=a,c,d" {
+		println("Expected the result to be b=a,c,d, got " + strRes)
+		t.Fatal()
+	}
+}
+
+func TestRunImport(t *testing.T) {
+	println("TestRunImport:")
+	dir := t.TempDir()
+	helper.Check(os.WriteFile(filepath.Join(dir, "list.khk"), []byte(
+		"List{List <,> Item = `List1 .. \", \" .. Item1`}\n" +
+		"List{Item}\n" +
+		"Item{<[a-z]+>}",
+	), 0644))
+	helper.Check(os.WriteFile(filepath.Join(dir, "main.khk"), []byte(
+		"IMPORT list AS l\n" +
+		"Call{l.Item <\\(> l.List <\\)> = `Item1 .. \"(\" .. List1 .. \")\"`}",
+	), 0644))
+
+	res := analyzeGrammarFile(t, filepath.Join(dir, "main.khk"))
+	strRes, err := Format("f(a,b)", &res, true, false)
+
+	if err != nil {
+		println("Unexpected runtime error:")
+		println(err.Error())
+		t.Fatal()
+	}
+
+	if strRes != "f(a, b)" {
+		println("Expected the result to be f(a, b), got " + strRes)
+		t.Fatal()
+	}
+}
+
+func TestRunStartSymbols(t *testing.T) {
+	println("TestRunStartSymbols:")
+	// each start symbol has its own parse table, X is in both of them
+	runGrammar(t, "SEARCH_MODE A{<a> X = `\"A\"`} B{<b> X = `\"B\"`} X{<x>}", "ax bx", "A B")
+}
+
+func TestRunExtends(t *testing.T) {
+	println("TestRunExtends:")
+	dir := t.TempDir()
+	helper.Check(os.WriteFile(filepath.Join(dir, "base.khk"), []byte(
+		"Start{Item+ = `table.concat(Item1, \" \")`}\n" +
+		"Item{Letter}\n" +
+		"Item{Digit}\n" +
+		"Letter{<[a-z]>}\n" +
+		"Digit{<[0-9]>}",
+	), 0644))
+	helper.Check(os.WriteFile(filepath.Join(dir, "child.khk"), []byte(
+		"EXTENDS base\n" +
+		"OVERRIDE Start{Item+ = `table.concat(Item1, \"-\")`}\n" +
+		"Item{Letter = `string.upper(Letter1)`}",
+	), 0644))
+	helper.Check(os.WriteFile(filepath.Join(dir, "keywords.khk"), []byte(
+		"Start{Word = `Word1`}\n" +
+		"Word{Kw = `\"KW\"`}\n" +
+		"Word{Id = `\"ID\"`}\n" +
+		"Kw{<if>}\n" +
+		"Id{<[a-z]+>}",
+	), 0644))
+	helper.Check(os.WriteFile(filepath.Join(dir, "keywords_child.khk"), []byte(
+		"EXTENDS keywords\n" +
+		"Kw{<if> = `LITERAL1`}",
+	), 0644))
+
+	tests := []struct {
+		file     string
+		input    string
+		expected string
+	}{
+		{"base.khk", "ab1", "a b 1"},
+		{"child.khk", "ab", "A-B"},
+		// a rule defined again keeps the precedence of its terminals
+		{"keywords_child.khk", "if", "KW"},
+	}
+	for _, test := range tests {
+		res := analyzeGrammarFile(t, filepath.Join(dir, test.file))
+		checkFormat(t, &res, test.input, test.expected)
+	}
+}
+
+func TestRunEmptyRules(t *testing.T) {
+	println("TestRunEmptyRules:")
+	tests := []struct {
+		grammar  string
+		input    string
+		expected string
+	}{
+		{"S{Sign Num Suffix = `\"[\" .. Sign1 .. \"]\" .. Num1 .. \"[\" .. Suffix1 .. \"]\"`} Sign{<->} Sign{} Num{<[0-9]+>} Suffix{<%>} Suffix{ = `\"none\"`}", "12", "[]12[none]"},
+		{"S{Sign Num Suffix = `\"[\" .. Sign1 .. \"]\" .. Num1 .. \"[\" .. Suffix1 .. \"]\"`} Sign{<->} Sign{} Num{<[0-9]+>} Suffix{<%>} Suffix{ = `\"none\"`}", "-5%", "[-]5[%]"},
+		// Open is reduced on the terminals of Close because everything in between can be empty
+		{"S{Open Mid Opt Close} Open{<\\(>} Mid{} Mid{<x>} Opt{} Opt{<y>} Close{<\\)>}", "()", "()"},
+		{"S{Open Mid Opt Close} Open{<\\(>} Mid{} Mid{<x>} Opt{} Opt{<y>} Close{<\\)>}", "(x)", "(x)"},
+		{"S{Open Mid Opt Close} Open{<\\(>} Mid{} Mid{<x>} Opt{} Opt{<y>} Close{<\\)>}", "(xy)", "(xy)"},
+		{"S{Open Mid Opt Close} Open{<\\(>} Mid{} Mid{<x>} Opt{} Opt{<y>} Close{<\\)>}", "(y)", "(y)"},
+		{"SEARCH_MODE S{A* = `\"<\" .. table.concat(A1) .. \">\"`} A{<a>}", "xaay", "x<aa>y"},
+	}
+	for _, test := range tests {
+		runGrammar(t, test.grammar, test.input, test.expected)
+	}
+}
+
+func TestRunTrivia(t *testing.T) {
+	println("TestRunTrivia:")
+	statements := "IGNORE { <[ \\t]+> <\\n> <#[^\\n]*> }" +
+		"S{Stmt+ = `table.concat(Stmt1)`}" +
+		"Stmt{Word <;> = `TRIVIA.Word1.leading .. Word1 .. \";\" .. TRIVIA.LITERAL1.trailing`}" +
+		"Word{<[a-z]+>}"
+	tests := []struct {
+		grammar  string
+		input    string
+		expected string
+	}{
+		// lua receives the tokens without trivia by default
+		{"IGNORE { <[ \\t\\n]+> <#[^\\n]*> } S{Item+ = `table.concat(Item1, \",\")`} Item{<[a-z]+>}", "  a b # c\n  d\n", "a,b,d"},
+		{statements, "a; # one\nb;\n", "a; # one\nb;\n"},
+		{statements, "# head\na;  b;", "# head\na;  b;"},
+		{"GLR_MODE " + statements, "# head\na; # one\nb;\n", "# head\na; # one\nb;\n"},
+		// in search mode the trivia around a match stays in the output
+		{"SEARCH_MODE IGNORE { <[ ]+> } S{<[a-z]+> <=> <[0-9]+> = `LITERAL1 .. \"=\" .. LITERAL3`}", "x = 1, y  =2 ", "x=1, y=2 "},
+	}
+	for _, test := range tests {
+		runGrammar(t, test.grammar, test.input, test.expected)
+	}
+}
+
+func TestRunLexerModes(t *testing.T) {
+	println("TestRunLexerModes:")
+	grammar := "IGNORE { <[ ]+> }" +
+		"MODE string { <[^\"\\\\]+> <\\\\.> <\"> }" +
+		"PUSH string { <\"> }" +
+		"POP string { <\"> }" +
+		"S{Item+ = `table.concat(Item1, \"|\")`}" +
+		"Item{<[a-z]+>}" +
+		"Item{<\"> Char* <\"> = `\"STR(\" .. table.concat(Char1) .. \")\"`}" +
+		"Char{<[^\"\\\\]+>}" +
+		"Char{<\\\\.>}"
+	tests := []struct {
+		grammar  string
+		input    string
+		expected string
+	}{
+		// the whitespace inside a string isn't trivia since the string mode doesn't skip it
+		{grammar, "ab \" x  y\\\"z\" c", "ab|STR( x  y\\\"z)|c"},
+		{grammar, "\"\"  d", "STR()|d"},
+		{"GLR_MODE " + grammar, "ab \" x  y\" c", "ab|STR( x  y)|c"},
+	}
+	for _, test := range tests {
+		runGrammar(t, test.grammar, test.input, test.expected)
+	}
+}
+
+func TestRunLongestMatch(t *testing.T) {
+	println("TestRunLongestMatch:")
+	rules := "IGNORE { <[ ]+> }" +
+		"S{Word+ = `table.concat(Word1, \" \")`}" +
+		"Word{<if> = `\"KW\"`}" +
+		"Word{<[a-z]+> = `\"ID(\" .. LITERAL1 .. \")\"`}"
+	tests := []struct {
+		grammar  string
+		expected string
+	}{
+		{rules, "KW KW ID(fy) ID(x)"},
+		{"LONGEST_MATCH " + rules, "KW ID(iffy) ID(x)"},
+		{"LONGEST_MATCH { <if> } " + rules, "KW ID(iffy) ID(x)"},
+		{"LONGEST_MATCH FIRST_MATCH { <if> } " + rules, "KW KW ID(fy) ID(x)"},
+		{"GLR_MODE LONGEST_MATCH " + rules, "KW ID(iffy) ID(x)"},
+	}
+	for _, test := range tests {
+		runGrammar(t, test.grammar, "if iffy x", test.expected)
+	}
+}
+
+func TestRunLuaErrorPositions(t *testing.T) {
+	println("TestRunLuaErrorPositions:")
+	tests := []struct {
+		grammar  string
+		input    string
+		expected string
+	}{
+		{"S{A B}\nA{<a> = `\"a\"`}\nB{<b> = ``\nlocal x = nil\nreturn x .. LITERAL1``}", "ab",
+			"Lua error (5, 1) in rule B (alternative 1) while evaluating the input from (1, 2) to (1, 3): cannot perform concat operation between nil and string"},
+		{"S{A}\nA{<a>}\nA{<b> = `nil .. LITERAL1`}", "b",
+			"Lua error (3, 10) in rule A (alternative 2) while evaluating the input from (1, 1) to (1, 2): cannot perform concat operation between nil and string"},
+		{"S{<a> B(`nil .. 1`) <c>} B(x){<b> = `x`}", "abc",
+			"Lua error (1, 10) in rule S (alternative 1) while evaluating the input from (1, 1) to (1, 4): cannot perform concat operation between nil and number"},
+		{"S{A <b>} A{<a> = `nil`}", "ab",
+			"Lua error (1, 2) in rule S (alternative 1) while evaluating the input from (1, 1) to (1, 3): cannot perform concat operation between nil and string"},
+		{"``\nlocal t = nil\nt.x = 1\n``\nS{<a>}", "a",
+			"Lua error (3, 1) in the global lua: attempt to index a non-table object(nil) with key 'x'"},
+		{"``\nfunction f()\nreturn nil .. 1\nend\n``\nS{<a> = `f()`}", "a",
+			"Lua error (3, 1) in the global lua: cannot perform concat operation between nil and number"},
+		{"GLR_MODE S{A B}\nA{<a> = `\"a\"`}\nB{<b> = ``\nlocal x = nil\nreturn x .. LITERAL1``}", "ab",
+			"Lua error (5, 1) in rule B (alternative 1) while evaluating the input from (1, 2) to (1, 3): cannot perform concat operation between nil and string"},
+		{"S{Items:(<a> C(`nil .. 1`))+} C(x){<c>}", "acac",
+			"Lua error (1, 17) in rule S (alternative 1) while evaluating the input from (1, 1) to (1, 5): cannot perform concat operation between nil and number"},
+		{"S{A}\nA{<a> COLLECT ``\nlocal x = nil\nreturn x .. LITERAL1``}", "a",
+			"Lua error (4, 1) in rule A (alternative 1) while evaluating the input from (1, 1) to (1, 2): cannot perform concat operation between nil and string"},
+	}
+	for _, test := range tests {
+		res := analyzeGrammar(t, test.grammar)
+		_, err := Format(test.input, &res, true, false)
+		var luaError *LuaError
+		if !errors.As(err, &luaError) {
+			println("Expected a LuaError for " + test.grammar)
+			if err != nil {
+				println(err.Error())
+			}
+			t.Fail()
+			continue
+		}
+		if !strings.HasPrefix(luaError.Error(), test.expected) {
+			println("Expected the error of " + test.grammar + " to be:\n" + test.expected + "\ngot:\n" + luaError.Error())
+			t.Fail()
+		}
+		// the offset points at the same place as the line and the column
+		lines := strings.Split(test.grammar, "\n")
+		raw := luaError.Position.Column - 1
+		for _, line := range lines[:luaError.Position.Line-1] {
+			raw += len(line) + 1
+		}
+		if luaError.Position.Raw != raw {
+			println("Expected the raw position of the error of " + test.grammar + " to be " + strconv.Itoa(raw) + ", got " + strconv.Itoa(luaError.Position.Raw))
+			t.Fail()
+		}
+	}
+}
+
+func TestExportTree(t *testing.T) {
+	println("TestExportTree:")
+	ast, errs := kuuhaku_parser.Parse("S{Num Sign Num*} Sign{} Sign{<->} Num{<[0-9]>}")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	res, errs := kuuhaku_analyzer.Analyze(&ast, false)
+	if len(errs) != 0 {
+		println("Expected analyzer errors length to be 0, got " + strconv.Itoa(len(errs)))
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	trees, err := ParseTrees("123", &res, false)
+	if err != nil {
+		println("Unexpected runtime error:")
+		println(err.Error())
+		t.Fatal()
+	}
+	if len(trees) != 1 {
+		println("Expected 1 tree, got " + strconv.Itoa(len(trees)))
+		t.Fatal()
+	}
+	nodes := []*ExportedNode{ExportTree(trees[0], "123")}
+	expected := "" +
+		"(node :rule \"S\" :alternative 1 :start (1 1 0) :end (1 4 3) :text \"123\"\n" +
+		"  (node :rule \"Num\" :alternative 1 :start (1 1 0) :end (1 2 1) :text \"1\"\n" +
+		"    (terminal :regex \"[0-9]\" :start (1 1 0) :end (1 2 1) :text \"1\"))\n" +
+		"  (node :rule \"Sign\" :alternative 1 :start (1 2 1) :end (1 2 1) :text \"\")\n" +
+		"  (list :rule \"S#1\" :start (1 2 1) :end (1 4 3) :text \"23\"\n" +
+		"    (node :rule \"Num\" :alternative 1 :start (1 2 1) :end (1 3 2) :text \"2\"\n" +
+		"      (terminal :regex \"[0-9]\" :start (1 2 1) :end (1 3 2) :text \"2\"))\n" +
+		"    (node :rule \"Num\" :alternative 1 :start (1 3 2) :end (1 4 3) :text \"3\"\n" +
+		"      (terminal :regex \"[0-9]\" :start (1 3 2) :end (1 4 3) :text \"3\"))))\n"
+	sExpr := ExportSExpr(nodes)
+	if sExpr != expected {
+		println("Expected the S-expression to be:\n" + expected + "got:\n" + sExpr)
+		t.Fail()
+	}
+
+	out, err := ExportJSON(nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []*ExportedNode
+	err = json.Unmarshal(out, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 1 || ExportSExpr(decoded) != expected {
+		println("Expected the JSON to hold the same tree, got:\n" + string(out))
+		t.Fail()
+	}
+}
+
+func TestRunNodeSpans(t *testing.T) {
+	println("TestRunNodeSpans:")
+	// a blank line is kept between two statements if there was one in the input
+	statements := "IGNORE { <[ \\n]+> } " +
+		"S{Stmt+ = ``local out = \"\" local last = nil " +
+		"for _, s in ipairs(Stmt1) do if last ~= nil then if s.first > last + 1 then out = out .. \"\\n\" end out = out .. \"\\n\" end out = out .. s.text last = s.last end " +
+		"return out``} " +
+		"Stmt{<[a-z]+> <;> = `{text = LITERAL1 .. \";\", first = NODE.start_line, last = NODE.end_line}`}"
+	tests := []struct {
+		grammar  string
+		input    string
+		expected string
+	}{
+		{statements, "a;  b;\n\n\n c;\nd;", "a;\nb;\n\nc;\nd;"},
+		{"GLR_MODE " + statements, "a;\n\nb;", "a;\n\nb;"},
+		// an empty node is right after the token before it
+		{"S{Num Sign Num = `Sign1 .. NODE.start_column .. \"-\" .. NODE.end_column .. \":\" .. NODE.end_offset`} Sign{ = `NODE.start_column .. \"-\" .. NODE.end_column .. \",\"`} Num{<[0-9]>}", "12", "2-2,1-3:2"},
+	}
+	for _, test := range tests {
+		res := analyzeGrammar(t, test.grammar)
+		if len(res.Warnings) != 0 {
+			println("Expected NODE to be known to the analyzer")
+			helper.DisplayAllErrors(res.Warnings)
+			t.Fatal()
+		}
+		checkFormat(t, &res, test.input, test.expected)
+	}
+}
+
+func TestRunNodeMode(t *testing.T) {
+	println("TestRunNodeMode:")
+	// a block with one statement is kept on one line
+	blocks := "NODE_MODE IGNORE { <[ \\n]+> } " +
+		"S{Block+} " +
+		"Block{<begin> Stmt* <end> = ``if #Stmt1 == 1 then return \"begin \" .. Stmt1[1] .. \" end\\n\" end " +
+		"local out = \"begin\\n\" for _, s in ipairs(Stmt1) do out = out .. \"  \" .. s.formatted .. \"\\n\" end return out .. \"end\\n\"``} " +
+		"Stmt{<[a-z]+> <;> = `LITERAL1 .. \";\"`}"
+	tests := []struct {
+		grammar  string
+		input    string
+		expected string
+	}{
+		{blocks, "begin a; end begin a;b; end", "begin a; end\nbegin\n  a;\n  b;\nend\n"},
+		{"GLR_MODE " + blocks, "begin  x ; end", "begin x; end\n"},
+		// rule, text, children and span of the nodes
+		{"NODE_MODE S{A B = `A1.rule .. \":\" .. B1.text .. \":\" .. #B1.children .. \":\" .. B1.span.start_column .. \"-\" .. B1.span.end_column .. \":\" .. tostring(B1.children[1].rule)`} A{<a>} B{<b> <c>}", "abc", "A:bc:2:2-4:nil"},
+		// the code of a node only runs when the node is read
+		{"NODE_MODE S{A B = `B1`} A{<a> = `error(\"unread\")`} B{<b>}", "ab", "b"},
+		// the elements of lists and groups are spliced into the children
+		{"NODE_MODE S{X = `#X1.children .. X1.children[3].rule`} X{(A <,>)+} A{<a>}", "a,a,", "4A"},
+	}
+	for _, test := range tests {
+		runGrammar(t, test.grammar, test.input, test.expected)
+	}
+}
+
+func TestRunDocs(t *testing.T) {
+	println("TestRunDocs:")
+	// the arguments of a call are broken into lines when the call doesn't fit
+	calls := "IGNORE { <[ \\n]+> } " +
+		"S{Call} " +
+		"Call{ID <\\(> Args <\\)> = `DOC.group(ID1, \"(\", DOC.indent(DOC.softline, Args1), DOC.softline, \")\")`} " +
+		"Args{Arg} " +
+		"Args{Args <,> Arg = `Args1 .. \",\" .. DOC.line .. Arg1`} " +
+		"Arg{Call} Arg{ID} " +
+		"ID{<[a-z]+>}"
+	tests := []struct {
+		grammar  string
+		input    string
+		expected string
+	}{
+		{calls, "f(a,\n b)", "f(a, b)"},
+		{"``DOC.width = 16`` " + calls, "f(aaa, g(bbb, ccc))", "f(\n    aaa,\n    g(bbb, ccc)\n)"},
+		{"``DOC.width = 8 DOC.indent_unit = \"\\t\"`` " + calls, "f(aaa, g(bbb, ccc))", "f(\n\taaa,\n\tg(\n\t\tbbb,\n\t\tccc\n\t)\n)"},
+		{"NODE_MODE ``DOC.width = 16`` " + calls, "f(aaa, g(bbb, ccc))", "f(\n    aaa,\n    g(bbb, ccc)\n)"},
+		// a string with a newline breaks the group around it
+		{"S{A B = `DOC.group(A1, DOC.line, B1)`} A{<a> = `\"a\\n\"`} B{<b>}", "ab", "a\n\nb"},
+		// the words are filled into the lines
+		{"``DOC.width = 7`` IGNORE { <[ ]+> } S{Word+ = ``local parts = {} for i, word in ipairs(Word1) do if i > 1 then table.insert(parts, DOC.line) end table.insert(parts, word) end return DOC.fill(parts)``} Word{<[a-z]+>}", "aa bb cc dd ee", "aa bb\ncc dd\nee"},
+	}
+	for _, test := range tests {
+		res := analyzeGrammar(t, test.grammar)
+		if len(res.Warnings) != 0 {
+			println("Expected DOC to be known to the analyzer")
+			helper.DisplayAllErrors(res.Warnings)
+			t.Fatal()
+		}
+		checkFormat(t, &res, test.input, test.expected)
+	}
+}
+
+func TestDocLayout(t *testing.T) {
+	println("TestDocLayout:")
+	text := func(s string) *Doc { return NewDoc(DOC_TEXT, s) }
+	line := NewDoc(DOC_LINE, "")
+	list := NewDoc(DOC_GROUP, "", text("["), NewDoc(DOC_NEST, "  ", NewDoc(DOC_SOFTLINE, ""), text("1,"), line, text("2")), NewDoc(DOC_SOFTLINE, ""), text("]"))
+	tests := []struct {
+		doc      *Doc
+		width    int
+		expected string
+	}{
+		{list, 6, "[1, 2]"},
+		{list, 5, "[\n  1,\n  2\n]"},
+		// the text after a group counts for the group up to the next line break
+		{NewDoc(DOC_CONCAT, "", list, text(";"), NewDoc(DOC_HARDLINE, ""), text("xxxxxxxx")), 6, "[\n  1,\n  2\n];\nxxxxxxxx"},
+		// trailing spaces are trimmed before a line break
+		{NewDoc(DOC_CONCAT, "", text("a "), NewDoc(DOC_HARDLINE, ""), text("b")), 80, "a\nb"},
+		// markers are aligned by characters, a marker at the end of a line isn't padded
+		{NewDoc(DOC_CONCAT, "", text("é"), NewDoc(DOC_ALIGN, ""), text(":1"), NewDoc(DOC_HARDLINE, ""), text("abc"), NewDoc(DOC_ALIGN, ""), text(":2"), NewDoc(DOC_HARDLINE, ""), text("abcd"), NewDoc(DOC_ALIGN, "")), 80, "é   :1\nabc :2\nabcd"},
+	}
+	for _, test := range tests {
+		out := test.doc.Layout(test.width)
+		if out != test.expected {
+			println("Expected the layout at width " + strconv.Itoa(test.width) + " to be " + strconv.Quote(test.expected) + ", got " + strconv.Quote(out))
+			t.Fatal()
+		}
+	}
+}
+
+func TestRunAlign(t *testing.T) {
+	println("TestRunAlign:")
+	// the = and the comments of consecutive entries are aligned, a blank line ends the block
+	entries := "IGNORE { <[ ]+> } " +
+		"S{Line+ = `DOC.concat(Line1)`} " +
+		"Line{Key <=> Value Comment? = `Key1 .. DOC.align(\"=\") .. \" = \" .. Value1 .. (Comment1 and DOC.align(\"comment\") .. \" \" .. Comment1 or \"\")`} " +
+		"Line{<\\n> = `DOC.hardline`} " +
+		"Key{<[a-z]+>} Value{<[0-9]+>} Comment{<#[a-z]*>}"
+	tests := []struct {
+		grammar  string
+		input    string
+		expected string
+	}{
+		{entries, "a=1 #x\nlong=22 #z\nbb=333 #y", "a    = 1   #x\nlong = 22  #z\nbb   = 333 #y"},
+		// a line without a comment ends the block of the comments
+		{entries, "a=1 #x\nlong=22\nbb=333 #y", "a    = 1 #x\nlong = 22\nbb   = 333 #y"},
+		{entries, "a=1\n\nlong=2", "a = 1\n\nlong = 2"},
+		{"NODE_MODE " + entries, "a=1 #x\nbb=22 #y", "a  = 1  #x\nbb = 22 #y"},
+	}
+	for _, test := range tests {
+		runGrammar(t, test.grammar, test.input, test.expected)
+	}
+}
+
+func TestRunCollect(t *testing.T) {
+	println("TestRunCollect:")
+	// the keys are padded to the longest key in the input
+	entries := "IGNORE { <[ \\n]+> } " +
+		"S{Entry+ = `table.concat(Entry1, \"\\n\")`} " +
+		"Entry{Key <=> Value = `Key1 .. string.rep(\" \", COLLECTED.width - #Key1) .. \" = \" .. Value1` " +
+		"COLLECT ``COLLECTED.width = math.max(COLLECTED.width or 0, #Key1)``} " +
+		"Key{<[a-z]+>} Value{<[0-9]+>}"
+	// the collect rules run before the replace rules, the nodes under a node before it
+	order := "IGNORE { <[ ]+> } " +
+		"S{Pair+ = `table.concat(COLLECTED.order, \",\")` COLLECT ``table.insert(COLLECTED.order, \"S:\" .. table.concat(Pair1, \"|\"))``} " +
+		"Pair{Item Item = `\"\"` COLLECT ``table.insert(COLLECTED.order, \"Pair\")``} " +
+		"Item{<[a-z]+> COLLECT ``COLLECTED.order = COLLECTED.order or {} table.insert(COLLECTED.order, LITERAL1 .. NODE.start_column)``}"
+	tests := []struct {
+		grammar  string
+		input    string
+		expected string
+	}{
+		{entries, "a=1\nlong=2\nbb=3", "a    = 1\nlong = 2\nbb   = 3"},
+		{order, "a b c d", "a1,b3,Pair,c5,d7,Pair,S:a b|c d"},
+		{"GLR_MODE " + order, "a b", "a1,b3,Pair,S:a b"},
+		// each match of the search mode starts with an empty COLLECTED
+		{"SEARCH_MODE S{<a> = `COLLECTED.n` COLLECT ``COLLECTED.n = (COLLECTED.n or 0) + 1``}", "xaxa", "x1x1"},
+	}
+	for _, test := range tests {
+		res := analyzeGrammar(t, test.grammar)
+		if len(res.Warnings) != 0 {
+			println("Expected the bindings of the collect rules and COLLECTED to be known to the analyzer")
+			helper.DisplayAllErrors(res.Warnings)
+			t.Fatal()
+		}
+		checkFormat(t, &res, test.input, test.expected)
+	}
+}
+
+func TestRunHooks(t *testing.T) {
+	println("TestRunHooks:")
+	ctx := &FormatContext{
+		Filename:  "dir/file.x",
+		Extension: ".x",
+		InputSize: 5,
+		Options:   FormatOptions{IsRecursive: true, SuppressedWarnings: []string{"W004"}},
+	}
+	tests := []struct {
+		grammar  string
+		input    string
+		expected string
+	}{
+		// a trailing newline is added if there is none
+		{"``function on_finish(output, ctx) if output:sub(-1) ~= \"\\n\" then return output .. \"\\n\" end end`` S{<a>}", "a", "a\n"},
+		{"``function on_finish(output, ctx) return ctx.filename .. \" \" .. ctx.extension .. \" \" .. ctx.input_size .. \" \" .. tostring(ctx.options.recursive) .. \" \" .. ctx.options.wsuppress[1] end`` S{<a>}", "a", "dir/file.x .x 5 true W004"},
+		// the globals set by on_start are seen by the replace rules
+		{"``function on_start(ctx) prefix = ctx.extension end`` S{<a> = `prefix .. LITERAL1`}", "a", ".xa"},
+		{"``name = \"default\" function on_start(ctx) name = ctx.filename end`` S{<a> = `name`}", "a", "dir/file.x"},
+		{"``function on_start(ctx) DOC.width = 2 end`` S{<a> <b> = `DOC.group(LITERAL1, DOC.line, LITERAL2)`}", "ab", "a\nb"},
+		// the global lua runs once per file, the matches share its globals
+		{"SEARCH_MODE ``count = 0`` S{<a> = ``count = count + 1 return tostring(count)``}", "xaxax", "x1x2x"},
+		// the hooks run once for the whole file in search mode
+		{"SEARCH_MODE ``function on_start(ctx) starts = (starts or 0) + 1 end function on_finish(output, ctx) return output .. \":\" .. starts .. \":\" .. matches end`` S{<a> = ``matches = (matches or 0) + 1 return \"b\"``}", "xaxax", "xbxbx:1:2"},
+	}
+	for _, test := range tests {
+		res := analyzeGrammar(t, test.grammar)
+		strRes, err := FormatFile(test.input, &res, ctx, false)
+		checkResult(t, test.input, test.expected, strRes, err)
+	}
+
+	ast, _ := kuuhaku_parser.Parse("``\nfunction on_start(ctx)\nerror(\"stop\")\nend\n`` S{<a>}")
+	res, _ := kuuhaku_analyzer.Analyze(&ast, false)
+	_, err := FormatFile("a", &res, ctx, false)
+	var luaError *LuaError
+	if !errors.As(err, &luaError) || !strings.HasPrefix(luaError.Error(), "Lua error (3, 1) in the global lua") {
+		println("Expected the error of on_start to be reported in the global lua")
+		if err != nil {
+			println(err.Error())
+		}
+		t.Fail()
+	}
+
+	ast, _ = kuuhaku_parser.Parse("``function on_finish(output, ctx) return 1 end`` S{<a>}")
+	res, _ = kuuhaku_analyzer.Analyze(&ast, false)
+	_, err = FormatFile("a", &res, ctx, false)
+	var evalError *EvalError
+	if !errors.As(err, &evalError) || evalError.Type != INVALID_HOOK_RESULT {
+		println("Expected an InvalidHookResult error")
+		t.Fail()
+	}
+}
+
+func TestRunGlobalLocals(t *testing.T) {
+	println("TestRunGlobalLocals:")
+	runGrammar(t, "``local function helper(x) return x .. x end`` S{<a> = `helper(LITERAL1)`}", "a", "aa")
+	runGrammar(t, "``local sep = \",\"`` S{<a> <b> = `LITERAL1 .. sep .. LITERAL2`}", "ab", "a,b")
+	runGrammar(t, "SEARCH_MODE ``local function helper(x) return x .. x end`` S{<a> = `helper(LITERAL1)`}", "xaxa", "xaaxaa")
+	// the matches write the local itself, so the functions of the global lua see the change
+	runGrammar(t, "SEARCH_MODE ``local count = 0 function get() return count end`` S{<a> = ``count = count + 1 return get()``}", "xaxa", "x1x2")
+	runGrammar(t, "NODE_MODE ``local sep = \",\"`` S{A A = `A1 .. sep .. A2`} A{<a>}", "aa", "a,a")
+}
+
+func TestParseTree(t *testing.T) {
+	println("TestParseTree:")
+	ast, errs := kuuhaku_parser.Parse("IGNORE { <[ ]+> } S{Pair+} Pair{Key <=> Value <;>} Key{<[a-z]+>} Value{<[0-9]+>}")
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	res, errs := kuuhaku_analyzer.Analyze(&ast, false)
+	if len(errs) != 0 {
+		println("Expected analyzer errors length to be 0, got " + strconv.Itoa(len(errs)))
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	tree, err := Parse("a = 1; bc=23;", &res)
+	if err != nil {
+		println("Unexpected runtime error:")
+		println(err.Error())
+		t.Fatal()
+	}
+	if len(tree.Roots) != 1 || tree.Roots[0].Rule != "S" {
+		println("Expected the root to be S")
+		t.Fatal()
+	}
+
+	pairs := tree.Find("Pair")
+	if len(pairs) != 2 {
+		println("Expected 2 pairs, got " + strconv.Itoa(len(pairs)))
+		t.Fatal()
+	}
+	if pairs[1].Text() != "bc=23;" || pairs[1].Child("Key").Text() != "bc" || pairs[1].Child("Value").Text() != "23" {
+		println("Expected the second pair to be bc=23;, got " + pairs[1].Text())
+		t.Fail()
+	}
+	if pairs[0].Parent().Hidden != kuuhaku_parser.HIDDEN_LIST || pairs[0].Parent().Parent() != tree.Roots[0] {
+		println("Expected the pairs to be in the list of S")
+		t.Fail()
+	}
+	terminals := pairs[0].ChildTerminals()
+	if len(terminals) != 2 || terminals[0].Text() != "=" || terminals[0].Regex != "=" || terminals[1].Start.Column != 6 {
+		println("Expected the terminals of the first pair to be = and ;")
+		t.Fail()
+	}
+
+	var texts []string
+	for _, terminal := range tree.Roots[0].Terminals() {
+		texts = append(texts, terminal.Text())
+	}
+	if strings.Join(texts, " ") != "a = 1 ; bc = 23 ;" {
+		println("Expected the terminals to be in the order of the input, got " + strings.Join(texts, " "))
+		t.Fail()
+	}
+
+	// the children of a Pair are skipped, post is called after the children
+	var visited []string
+	tree.Walk(func(element Element) bool {
+		node, ok := element.(*Node)
+		if ok {
+			visited = append(visited, "pre "+node.Rule)
+			return node.Rule != "Pair"
+		}
+		return true
+	}, func(element Element) {
+		node, ok := element.(*Node)
+		if ok && node.Hidden == kuuhaku_parser.NOT_HIDDEN {
+			visited = append(visited, "post "+node.Rule)
+		}
+	})
+	expected := "pre S, pre S#1, pre Pair, post Pair, pre Pair, post Pair, post S"
+	if strings.Join(visited, ", ") != expected {
+		println("Expected the walk to be " + expected + ", got " + strings.Join(visited, ", "))
+		t.Fail()
+	}
+}
+
+// parses and analyzes the grammar of a test, the test stops if there is an error
+func analyzeGrammar(t *testing.T, grammar string) kuuhaku_analyzer.AnalyzerResult {
+	t.Helper()
+	ast, errs := kuuhaku_parser.Parse(grammar)
+	return analyzeAst(t, ast, errs)
+}
+
+func analyzeGrammarFile(t *testing.T, path string) kuuhaku_analyzer.AnalyzerResult {
+	t.Helper()
+	ast, errs := kuuhaku_parser.ParseFile(path, nil)
+	return analyzeAst(t, ast, errs)
+}
+
+func analyzeAst(t *testing.T, ast kuuhaku_parser.Ast, errs []error) kuuhaku_analyzer.AnalyzerResult {
+	t.Helper()
+	if len(errs) != 0 {
+		println("Expected parser errors length to be 0")
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	res, errs := kuuhaku_analyzer.Analyze(&ast, false)
+	if len(errs) != 0 {
+		println("Expected analyzer errors length to be 0, got " + strconv.Itoa(len(errs)))
+		helper.DisplayAllErrors(errs)
+		t.Fatal()
+	}
+	return res
+}
+
+// formats the input with the grammar and compares the result with the expected output
+func runGrammar(t *testing.T, grammar string, input string, expected string) {
+	t.Helper()
+	res := analyzeGrammar(t, grammar)
+	checkFormat(t, &res, input, expected)
+}
+
+func checkFormat(t *testing.T, res *kuuhaku_analyzer.AnalyzerResult, input string, expected string) {
+	t.Helper()
+	strRes, err := Format(input, res, true, false)
+	checkResult(t, input, expected, strRes, err)
+}
+
+func checkResult(t *testing.T, input string, expected string, strRes string, err error) {
+	t.Helper()
+	if err != nil {
+		println("Unexpected runtime error:")
+		println(err.Error())
+		t.Fatal()
+	}
+	if strRes != expected {
+		println("Expected the result of " + strconv.Quote(input) + " to be " + strconv.Quote(expected) + ", got " + strconv.Quote(strRes))
+		t.Fatal()
+	}
+}
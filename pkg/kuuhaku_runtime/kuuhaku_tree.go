@@ -0,0 +1,257 @@
+package kuuhaku_runtime
+
+import (
+	"strings"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_analyzer"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_parser"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+)
+
+// the parse trees of an input. There is one root unless the grammar is in search mode, where each
+// match has its own root
+type Tree struct {
+	Input string
+	Roots []*Node
+}
+
+// an element of a Tree, either a *Node or a *Terminal
+type Element interface {
+	Text() string //the input the element covers
+	Span() (kuuhaku_tokenizer.Position, kuuhaku_tokenizer.Position)
+	Parent() *Node
+}
+
+// a matched rule. The recursion of a list made by the EBNF operators is flattened, a list node has
+// the elements of the whole repetition as its children
+type Node struct {
+	Rule        string
+	Alternative int
+	Hidden      kuuhaku_parser.HiddenRuleType
+	Start       kuuhaku_tokenizer.Position
+	End         kuuhaku_tokenizer.Position //the position right after the last token
+	Children    []Element
+	parent      *Node
+	input       string
+}
+
+type Terminal struct {
+	Regex          string //the terminal that matched the token
+	LeadingTrivia  string
+	TrailingTrivia string
+	Start          kuuhaku_tokenizer.Position
+	End            kuuhaku_tokenizer.Position
+	parent         *Node
+	input          string
+}
+
+// Parse parses the input without running any lua
+func Parse(input string, grammar *kuuhaku_analyzer.AnalyzerResult) (*Tree, error) {
+	trees, err := ParseTrees(input, grammar, false)
+	if err != nil {
+		return nil, err
+	}
+	tree := &Tree{Input: input}
+	for _, parseStackTree := range trees {
+		tree.Roots = append(tree.Roots, NewNode(parseStackTree, input))
+	}
+	return tree, nil
+}
+
+// NewNode converts a tree returned by ParseTrees, the augmented start rule is left out so the root is
+// the start symbol
+func NewNode(tree *ParseStackTree, input string) *Node {
+	return newNode(getStartTree(tree), input, nil, nil)
+}
+
+// the tree of the start symbol inside the tree of the augmented start rule
+func getStartTree(tree *ParseStackTree) *ParseStackTree {
+	if len(*tree.Children) == 1 && strings.HasPrefix(tree.Rule.Name, "S") {
+		child, ok := (*tree.Children)[0].(*ParseStackTree)
+		if ok && "S"+child.Rule.Name == tree.Rule.Name {
+			return child
+		}
+	}
+	return tree
+}
+
+// elements maps the parse stack elements to the elements made from them if it isn't nil
+func newNode(tree *ParseStackTree, input string, parent *Node, elements map[ParseStackElement]Element) *Node {
+	node := &Node{
+		Rule:        tree.Rule.Name,
+		Alternative: tree.Rule.Alternative,
+		Hidden:      tree.Rule.Hidden,
+		Start:       tree.Position,
+		End:         tree.End,
+		parent:      parent,
+		input:       input,
+	}
+	if elements != nil {
+		elements[tree] = node
+	}
+	children := *tree.Children
+	if tree.Rule.Hidden == kuuhaku_parser.HIDDEN_LIST {
+		children = getListElements(tree)
+	}
+	for _, child := range children {
+		childTree, ok := child.(*ParseStackTree)
+		if ok {
+			node.Children = append(node.Children, newNode(childTree, input, node, elements))
+			continue
+		}
+		terminal := child.(*ParseStackTerminal)
+		element := &Terminal{
+			Regex:          terminal.Regex,
+			LeadingTrivia:  terminal.LeadingTrivia,
+			TrailingTrivia: terminal.TrailingTrivia,
+			Start:          terminal.Position,
+			End:            terminal.End,
+			parent:         node,
+			input:          input,
+		}
+		if elements != nil {
+			elements[terminal] = element
+		}
+		node.Children = append(node.Children, element)
+	}
+	return node
+}
+
+// the elements of a hidden list rule, which is left recursive
+func getListElements(tree *ParseStackTree) []ParseStackElement {
+	var elements []ParseStackElement
+	curr := tree
+	for curr != nil {
+		children := *curr.Children
+		last := len(children) - 1
+		elements = append([]ParseStackElement{children[last]}, elements...)
+		curr = nil
+		if last > 0 {
+			curr, _ = children[0].(*ParseStackTree)
+		}
+	}
+	return elements
+}
+
+func getText(input string, start kuuhaku_tokenizer.Position, end kuuhaku_tokenizer.Position) string {
+	if start.Raw > end.Raw || end.Raw > len(input) {
+		return ""
+	}
+	return input[start.Raw:end.Raw]
+}
+
+func (node *Node) Text() string {
+	return getText(node.input, node.Start, node.End)
+}
+
+func (node *Node) Span() (kuuhaku_tokenizer.Position, kuuhaku_tokenizer.Position) {
+	return node.Start, node.End
+}
+
+// nil for a root
+func (node *Node) Parent() *Node {
+	return node.parent
+}
+
+func (terminal *Terminal) Text() string {
+	return getText(terminal.input, terminal.Start, terminal.End)
+}
+
+func (terminal *Terminal) Span() (kuuhaku_tokenizer.Position, kuuhaku_tokenizer.Position) {
+	return terminal.Start, terminal.End
+}
+
+func (terminal *Terminal) Parent() *Node {
+	return terminal.parent
+}
+
+func (node *Node) ChildNodes() []*Node {
+	var nodes []*Node
+	for _, child := range node.Children {
+		childNode, ok := child.(*Node)
+		if ok {
+			nodes = append(nodes, childNode)
+		}
+	}
+	return nodes
+}
+
+func (node *Node) ChildTerminals() []*Terminal {
+	var terminals []*Terminal
+	for _, child := range node.Children {
+		terminal, ok := child.(*Terminal)
+		if ok {
+			terminals = append(terminals, terminal)
+		}
+	}
+	return terminals
+}
+
+// the first child node of the rule, nil if there is none
+func (node *Node) Child(rule string) *Node {
+	for _, child := range node.ChildNodes() {
+		if child.Rule == rule {
+			return child
+		}
+	}
+	return nil
+}
+
+// every terminal under the node in the order of the input
+func (node *Node) Terminals() []*Terminal {
+	var terminals []*Terminal
+	Walk(node, func(element Element) bool {
+		terminal, ok := element.(*Terminal)
+		if ok {
+			terminals = append(terminals, terminal)
+		}
+		return true
+	}, nil)
+	return terminals
+}
+
+// the node and the nodes under it that match the rule, in the order of the input
+func (node *Node) Find(rule string) []*Node {
+	var nodes []*Node
+	Walk(node, func(element Element) bool {
+		found, ok := element.(*Node)
+		if ok && found.Rule == rule {
+			nodes = append(nodes, found)
+		}
+		return true
+	}, nil)
+	return nodes
+}
+
+func (tree *Tree) Find(rule string) []*Node {
+	var nodes []*Node
+	for _, root := range tree.Roots {
+		nodes = append(nodes, root.Find(rule)...)
+	}
+	return nodes
+}
+
+// Walk visits the element and everything under it depth first. pre is called before the children
+// of an element are visited and skips them by returning false, post is called after them. Either
+// hook may be nil
+func Walk(element Element, pre func(element Element) bool, post func(element Element)) {
+	isVisitingChildren := true
+	if pre != nil {
+		isVisitingChildren = pre(element)
+	}
+	node, ok := element.(*Node)
+	if ok && isVisitingChildren {
+		for _, child := range node.Children {
+			Walk(child, pre, post)
+		}
+	}
+	if post != nil {
+		post(element)
+	}
+}
+
+func (tree *Tree) Walk(pre func(element Element) bool, post func(element Element)) {
+	for _, root := range tree.Roots {
+		Walk(root, pre, post)
+	}
+}
@@ -0,0 +1,122 @@
+package kuuhaku_runtime
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_analyzer"
+	"github.com/ciii1/kuuhaku/pkg/kuuhaku_tokenizer"
+)
+
+// skips the trivia terminals at pos for as long as one of them matches, returns the skipped text and
+// the position after it. A trivia terminal that matches the empty string is never skipped
+func skipTrivia(input string, pos kuuhaku_tokenizer.Position, trivia []kuuhaku_analyzer.TerminalList) (string, kuuhaku_tokenizer.Position) {
+	skipped := ""
+	isFound := true
+	for isFound {
+		isFound = false
+		slicedInput := input[pos.Raw:]
+		for _, terminal := range trivia {
+			loc := terminal.Regexp.FindStringIndex(slicedInput)
+			if loc == nil || loc[1] == 0 {
+				continue
+			}
+			skipped += slicedInput[:loc[1]]
+			pos = addToPositionFromSlicedString(pos, slicedInput[:loc[1]])
+			isFound = true
+			break
+		}
+	}
+	return skipped, pos
+}
+
+// the trivia between two tokens up to and including the first newline is the trailing trivia of the
+// previous token, the rest is the leading trivia of the next one. Before the first token all of it is
+// leading trivia
+func attachTrivia(previous *ParseStackTerminal, next *ParseStackTerminal, trivia string) {
+	if previous == nil {
+		next.LeadingTrivia = trivia
+		return
+	}
+	newline := strings.IndexByte(trivia, '\n')
+	if newline == -1 {
+		previous.TrailingTrivia = trivia
+		return
+	}
+	previous.TrailingTrivia = trivia[:newline+1]
+	next.LeadingTrivia = trivia[newline+1:]
+}
+
+// the trivia after the last token is its trailing trivia if nothing but trivia is left in the input.
+// Returns the position after the trivia in that case. In search mode the match ends at the last token
+// so the trivia after it is left in the output
+func consumeFinalTrivia(input string, pos kuuhaku_tokenizer.Position, trivia []kuuhaku_analyzer.TerminalList, lastTerminal *ParseStackTerminal, isSearchMode bool) kuuhaku_tokenizer.Position {
+	if isSearchMode {
+		return pos
+	}
+	skipped, end := skipTrivia(input, pos, trivia)
+	if end.Raw < len(input) {
+		return pos
+	}
+	if lastTerminal != nil {
+		lastTerminal.TrailingTrivia = skipped
+	}
+	return end
+}
+
+func getFirstTerminal(element ParseStackElement) *ParseStackTerminal {
+	tree, ok := element.(*ParseStackTree)
+	if !ok {
+		terminal, _ := element.(*ParseStackTerminal)
+		return terminal
+	}
+	for _, child := range *tree.Children {
+		terminal := getFirstTerminal(child)
+		if terminal != nil {
+			return terminal
+		}
+	}
+	return nil
+}
+
+func getLastTerminal(element ParseStackElement) *ParseStackTerminal {
+	tree, ok := element.(*ParseStackTree)
+	if !ok {
+		terminal, _ := element.(*ParseStackTerminal)
+		return terminal
+	}
+	children := *tree.Children
+	for i := len(children) - 1; i >= 0; i-- {
+		terminal := getLastTerminal(children[i])
+		if terminal != nil {
+			return terminal
+		}
+	}
+	return nil
+}
+
+// the TRIVIA table of a rule holds the leading trivia of the first token and the trailing trivia of the
+// last token of each match rule, keyed by its binding. Match rules without trivia are left out and
+// read as empty strings through the metatable of __kuuhaku_trivia
+func compileTrivia(tree *ParseStackTree, allVar []compiledBinding) string {
+	entries := ""
+	for i, child := range *tree.Children {
+		var leading, trailing string
+		first := getFirstTerminal(child)
+		if first != nil {
+			leading = first.LeadingTrivia
+		}
+		last := getLastTerminal(child)
+		if last != nil {
+			trailing = last.TrailingTrivia
+		}
+		if leading == "" && trailing == "" {
+			continue
+		}
+		entries += allVar[i].name + " = {leading = " + strconv.Quote(leading) + ", trailing = " + strconv.Quote(trailing) + "}, "
+	}
+	if entries == "" {
+		return ""
+	}
+	return "\nlocal TRIVIA = __kuuhaku_trivia({" + entries + "})"
+}
@@ -12,6 +12,7 @@ const (
 	LUA_LITERAL_UNTERMINATED
 	LUA_RETURN_LITERAL_UNTERMINATED
 	ILLEGAL_CAPTURE_GROUP
+	STRING_LITERAL_UNTERMINATED
 )
 
 type TokenizeError struct {
@@ -21,7 +22,7 @@ type TokenizeError struct {
 }
 
 func (e TokenizeError) Error() string {
-	return fmt.Sprintf("Tokenize error (%d, %d): %s", e.Position.Line, e.Position.Column, e.Message)
+	return fmt.Sprintf("Tokenize error (%s): %s", e.Position.Location(), e.Message)
 }
 
 func ErrPatternUnrecognized(tokenizer Tokenizer) *TokenizeError {
@@ -47,6 +48,14 @@ func ErrLuaReturnLiteralUnterminated(tokenizer Tokenizer) *TokenizeError {
 	}
 }
 
+func ErrStringLiteralUnterminated(tokenizer Tokenizer) *TokenizeError {
+	return &TokenizeError{
+		Message:  "String literal is not terminated",
+		Position: tokenizer.Position,
+		Type:     STRING_LITERAL_UNTERMINATED,
+	}
+}
+
 // we want the position instead of the tokenizer because the position we have to display is not the current position
 func ErrLuaLiteralUnterminated(pos Position) *TokenizeError {
 	return &TokenizeError{
@@ -70,10 +79,47 @@ const (
 	CLOSING_BRACKET
 	COMMA
 	EQUAL_SIGN
+	QUESTION_MARK
+	ASTERISK
+	PLUS_SIGN
+	COLON
 	SEARCH_MODE_KEYWORD
+	GLR_MODE_KEYWORD
+	PREFER_KEYWORD
+	IMPORT_KEYWORD
+	EXTENDS_KEYWORD
+	OVERRIDE_KEYWORD
+	IGNORE_KEYWORD
+	MODE_KEYWORD
+	PUSH_KEYWORD
+	POP_KEYWORD
+	LONGEST_MATCH_KEYWORD
+	FIRST_MATCH_KEYWORD
+	NODE_MODE_KEYWORD
 	EOF
 )
 
+var keywords = map[string]TokenType{
+	"SEARCH_MODE": SEARCH_MODE_KEYWORD,
+}
+
+// the words that start a directive. They are returned as identifiers so they can still name rules, the
+// parser only reads them as directives where a directive can start
+var DirectiveKeywords = map[string]TokenType{
+	"GLR_MODE":      GLR_MODE_KEYWORD,
+	"PREFER":        PREFER_KEYWORD,
+	"IMPORT":        IMPORT_KEYWORD,
+	"EXTENDS":       EXTENDS_KEYWORD,
+	"OVERRIDE":      OVERRIDE_KEYWORD,
+	"IGNORE":        IGNORE_KEYWORD,
+	"MODE":          MODE_KEYWORD,
+	"PUSH":          PUSH_KEYWORD,
+	"POP":           POP_KEYWORD,
+	"LONGEST_MATCH": LONGEST_MATCH_KEYWORD,
+	"FIRST_MATCH":   FIRST_MATCH_KEYWORD,
+	"NODE_MODE":     NODE_MODE_KEYWORD,
+}
+
 type Token struct {
 	Type     TokenType
 	Content  string
@@ -84,6 +130,15 @@ type Position struct {
 	Column int
 	Line   int
 	Raw    int
+	File   string //the grammar file the position is in, empty if the grammar didn't come from a file
+}
+
+// formats the position for error messages
+func (position Position) Location() string {
+	if position.File == "" {
+		return fmt.Sprintf("%d, %d", position.Line, position.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", position.File, position.Line, position.Column)
 }
 
 type Tokenizer struct {
@@ -95,11 +150,17 @@ type Tokenizer struct {
 }
 
 func Init(input string) Tokenizer {
+	return InitFile(input, "")
+}
+
+// file is stored in the positions of the tokens and errors
+func InitFile(input string, file string) Tokenizer {
 	tokenizer := Tokenizer{
 		Position: Position{
 			Column: 1,
 			Line:   1,
 			Raw:    0,
+			File:   file,
 		},
 		currToken: nil,
 		currError: nil,
@@ -165,6 +226,26 @@ func (tokenizer *Tokenizer) Next() (*Token, error) {
 		return tokenizer.returnToken(token, nil)
 	}
 
+	token = tokenizer.consumeSingleCharacter('?', QUESTION_MARK)
+	if token != nil {
+		return tokenizer.returnToken(token, nil)
+	}
+
+	token = tokenizer.consumeSingleCharacter('*', ASTERISK)
+	if token != nil {
+		return tokenizer.returnToken(token, nil)
+	}
+
+	token = tokenizer.consumeSingleCharacter('+', PLUS_SIGN)
+	if token != nil {
+		return tokenizer.returnToken(token, nil)
+	}
+
+	token = tokenizer.consumeSingleCharacter(':', COLON)
+	if token != nil {
+		return tokenizer.returnToken(token, nil)
+	}
+
 	token, err := tokenizer.consumeLuaLiteral()
 	if err != nil {
 		return tokenizer.returnToken(nil, err)
@@ -189,12 +270,26 @@ func (tokenizer *Tokenizer) Next() (*Token, error) {
 		return tokenizer.returnToken(token, nil)
 	}
 
+	token, err = tokenizer.consumeStringLiteral()
+	if err != nil {
+		return tokenizer.returnToken(nil, err)
+	}
+	if token != nil {
+		return tokenizer.returnToken(token, nil)
+	}
+
 	tokenizerState := *tokenizer
 	tokenizer.nextChar()
 	return tokenizer.returnToken(nil, ErrPatternUnrecognized(tokenizerState))
 }
 
 func (tokenizer *Tokenizer) returnToken(token *Token, err error) (*Token, error) {
+	if token != nil {
+		token.Position.File = tokenizer.Position.File
+	}
+	if tokenizeError, ok := err.(*TokenizeError); ok {
+		tokenizeError.Position.File = tokenizer.Position.File
+	}
 	tokenizer.currToken = token
 	tokenizer.currError = err
 	return token, err
@@ -402,6 +497,29 @@ func (tokenizer *Tokenizer) consumeComma() *Token {
 	}
 }
 
+func (tokenizer *Tokenizer) consumeSingleCharacter(char byte, tokenType TokenType) *Token {
+	positionRaw := tokenizer.Position.Raw
+	column := tokenizer.Position.Column
+	line := tokenizer.Position.Line
+
+	currChar := tokenizer.peekChar()
+	if currChar != char {
+		return nil
+	}
+
+	tokenizer.nextChar()
+
+	return &Token{
+		Position: Position{
+			Raw:    positionRaw,
+			Column: column,
+			Line:   line,
+		},
+		Type:    tokenType,
+		Content: string(char),
+	}
+}
+
 func (tokenizer *Tokenizer) consumeLuaLiteral() (*Token, error) {
 	positionRaw := tokenizer.Position.Raw
 	column := tokenizer.Position.Column
@@ -544,6 +662,38 @@ func (tokenizer *Tokenizer) consumeRegexLiteral() (*Token, error) {
 	}, nil
 }
 
+func (tokenizer *Tokenizer) consumeStringLiteral() (*Token, error) {
+	positionRaw := tokenizer.Position.Raw
+	column := tokenizer.Position.Column
+	line := tokenizer.Position.Line
+	content := ""
+
+	currChar := tokenizer.peekChar()
+	if currChar != '"' {
+		return nil, nil
+	}
+
+	currChar = tokenizer.nextChar()
+	for currChar != '"' {
+		if currChar == '\n' || currChar == '\003' {
+			return nil, ErrStringLiteralUnterminated(*tokenizer)
+		}
+		content += string(currChar)
+		currChar = tokenizer.nextChar()
+	}
+	tokenizer.nextChar()
+
+	return &Token{
+		Position: Position{
+			Raw:    positionRaw,
+			Column: column,
+			Line:   line,
+		},
+		Type:    STRING_LITERAL,
+		Content: content,
+	}, nil
+}
+
 func (tokenizer *Tokenizer) consumeIdentifierOrKeyword() *Token {
 	positionRaw := tokenizer.Position.Raw
 	column := tokenizer.Position.Column
@@ -556,17 +706,16 @@ func (tokenizer *Tokenizer) consumeIdentifierOrKeyword() *Token {
 
 	tokenContent := ""
 
+	//dots separate the namespace of an imported rule from its name
 	isCurrCharBetween_0_9 := false
-	for isRuneIdentifier(currChar) || isCurrCharBetween_0_9 {
+	for isRuneIdentifier(currChar) || isCurrCharBetween_0_9 || currChar == '.' {
 		tokenContent += string(currChar)
 		currChar = tokenizer.nextChar()
 		isCurrCharBetween_0_9 = isRuneNumber(currChar)
 	}
 
-	var tokenType TokenType
-	if tokenContent == "SEARCH_MODE" {
-		tokenType = SEARCH_MODE_KEYWORD
-	} else {
+	tokenType, ok := keywords[tokenContent]
+	if !ok {
 		tokenType = IDENTIFIER
 	}
 
@@ -418,6 +418,58 @@ func TestSigns(t *testing.T) {
 	}
 }
 
+func TestQuantifiers(t *testing.T) {
+	tokenizer := Init("a?(b)*c+ d:e")
+	expected := []TokenType{IDENTIFIER, QUESTION_MARK, OPENING_BRACKET, IDENTIFIER, CLOSING_BRACKET, ASTERISK, IDENTIFIER, PLUS_SIGN, IDENTIFIER, COLON, IDENTIFIER, EOF}
+	token, err := tokenizer.Peek()
+	for i, tokenType := range expected {
+		helper.Check(err)
+		if token.Type != tokenType {
+			println("Unexpected token type at token " + strconv.Itoa(i) + ", got " + token.Content)
+			t.Fail()
+		}
+		token, err = tokenizer.Next()
+	}
+}
+
+func TestImport(t *testing.T) {
+	tokenizer := InitFile("IMPORT \"common/ws.khk\" AS ws ws.Value", "main.khk")
+	expected := []TokenType{IDENTIFIER, STRING_LITERAL, IDENTIFIER, IDENTIFIER, IDENTIFIER, EOF}
+	token, err := tokenizer.Peek()
+	for i, tokenType := range expected {
+		helper.Check(err)
+		if token.Type != tokenType {
+			println("Unexpected token type at token " + strconv.Itoa(i) + ", got " + token.Content)
+			t.Fail()
+		}
+		if i == 1 && token.Content != "common/ws.khk" {
+			println("Expected the string literal to contain common/ws.khk, got " + token.Content)
+			t.Fail()
+		}
+		if i == 4 && token.Content != "ws.Value" {
+			println("Expected the identifier to be ws.Value, got " + token.Content)
+			t.Fail()
+		}
+		if token.Position.File != "main.khk" {
+			println("Expected the token to be in main.khk")
+			t.Fail()
+		}
+		token, err = tokenizer.Next()
+	}
+
+	tokenizer = InitFile("\"unterminated", "main.khk")
+	_, err = tokenizer.Peek()
+	var tokenizeError *TokenizeError
+	if !errors.As(err, &tokenizeError) || tokenizeError.Type != STRING_LITERAL_UNTERMINATED {
+		println("Expected STRING_LITERAL_UNTERMINATED")
+		t.Fatal()
+	}
+	if tokenizeError.Error() != "Tokenize error (main.khk:1:14): String literal is not terminated" {
+		println("Unexpected error message: " + tokenizeError.Error())
+		t.Fail()
+	}
+}
+
 func TestPosition(t *testing.T) {
 	tokenizer := Init("test #test\n#test again\ntest third``\ntest\ntest\ntest\n``hello")
 	token, err := tokenizer.Peek()